@@ -0,0 +1,486 @@
+package asm
+
+import (
+	"chui/code"
+	"chui/compiler"
+	"chui/object"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Assemble() turns a listing in the format Disassemble writes back into bytecode.
+//
+// The .const section lists the constant pool, one `int 1`, `string "text"` or
+// `func "name" params=1 locals=1 defaults=0 variadic=false` entry per line, optionally
+// preceded by the constant's index. The .main section holds the instructions of the main
+// program, and a `.func index` section those of the function constant at index; both
+// take an optional tryslots=N. An instruction is an opcode name followed by its operands,
+// optionally preceded by its offset, which is ignored, and by OpWide to force the wide form
+// that operands too large for their widths get anyway. Jump operands and the offsets of
+// `.handler start end target slot` lines may be labels, defined by a `name:` line before
+// the instruction they mark. Everything after a semicolon is a comment.
+func Assemble(input string) (*compiler.Bytecode, error) {
+	a := &assembler{
+		bytecode: &compiler.Bytecode{Instructions: code.Instructions{}},
+		sections: map[string]bool{},
+	}
+
+	for i, line := range strings.Split(input, "\n") {
+		a.lineNumber = i + 1
+		if err := a.line(line); err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+	}
+
+	if err := a.finish(); err != nil {
+		return nil, err
+	}
+
+	for i, constant := range a.bytecode.Constants {
+		if _, ok := constant.(*object.CompiledFunction); ok && !a.sections[funcSection(i)] {
+			return nil, fmt.Errorf("function constant %d has no .func section", i)
+		}
+	}
+
+	return a.bytecode, nil
+}
+
+// assembler holds the state of an Assemble run: the section being read and, while it is
+// a code section, its instructions so far.
+type assembler struct {
+	bytecode *compiler.Bytecode
+	sections map[string]bool // the sections read so far, to reject duplicates
+
+	lineNumber int // the line being read, counting from 1
+	inConst    bool
+	block      *codeBlock // nil outside of code sections
+}
+
+// codeBlock collects the instructions of a code section until its labels can be resolved.
+type codeBlock struct {
+	ins         code.Instructions
+	labels      map[string]int
+	fixups      []fixup
+	handlers    []handlerLine
+	numTrySlots int
+
+	// store writes the finished instructions where they belong
+	store func(ins code.Instructions, handlers []code.Handler, numTrySlots int)
+}
+
+// fixup is a jump operand naming a label, patched once the whole section is read.
+type fixup struct {
+	offset int // where the operand starts in ins
+	width  int
+	label  string
+	line   int
+}
+
+type handlerLine struct {
+	offsets [3]string // start, end and target, labels or numbers
+	slot    int
+	line    int
+}
+
+func (a *assembler) line(line string) error {
+	fields, err := splitFields(line)
+	if err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	if strings.HasPrefix(fields[0], ".") && fields[0] != ".handler" {
+		return a.section(fields)
+	}
+
+	if a.inConst {
+		return a.constant(fields)
+	}
+
+	if a.block == nil {
+		return fmt.Errorf("%s outside of a section", fields[0])
+	}
+
+	switch {
+	case fields[0] == ".handler":
+		return a.handler(fields)
+
+	case len(fields) == 1 && strings.HasSuffix(fields[0], ":"):
+		label := strings.TrimSuffix(fields[0], ":")
+		if _, ok := a.block.labels[label]; ok {
+			return fmt.Errorf("label %s defined twice", label)
+		}
+		a.block.labels[label] = len(a.block.ins)
+		return nil
+
+	default:
+		return a.instruction(fields)
+	}
+}
+
+// section starts the section named by a header line, finishing the previous one.
+func (a *assembler) section(fields []string) error {
+	if err := a.finish(); err != nil {
+		return err
+	}
+
+	name := fields[0]
+	attributes := fields[1:]
+	var fn *object.CompiledFunction
+	if name == ".func" {
+		if len(fields) < 2 {
+			return fmt.Errorf(".func needs the index of a function constant")
+		}
+		index, err := strconv.Atoi(fields[1])
+		if err != nil || index < 0 || index >= len(a.bytecode.Constants) {
+			return fmt.Errorf("no constant %s", fields[1])
+		}
+		var ok bool
+		if fn, ok = a.bytecode.Constants[index].(*object.CompiledFunction); !ok {
+			return fmt.Errorf("constant %d is not a function", index)
+		}
+		name = funcSection(index)
+		attributes = fields[2:]
+	}
+
+	if a.sections[name] {
+		return fmt.Errorf("section %s defined twice", name)
+	}
+	a.sections[name] = true
+
+	switch {
+	case name == ".const":
+		if len(attributes) != 0 {
+			return fmt.Errorf(".const takes no attributes")
+		}
+		a.inConst = true
+		return nil
+
+	case name == ".main":
+		a.block = &codeBlock{store: func(ins code.Instructions, handlers []code.Handler, numTrySlots int) {
+			a.bytecode.Instructions = ins
+			a.bytecode.Handlers = handlers
+			a.bytecode.NumTrySlots = numTrySlots
+		}}
+
+	case fn != nil:
+		a.block = &codeBlock{store: func(ins code.Instructions, handlers []code.Handler, numTrySlots int) {
+			fn.Instructions = ins
+			fn.Handlers = handlers
+			fn.NumTrySlots = numTrySlots
+		}}
+
+	default:
+		return fmt.Errorf("unknown section %s", name)
+	}
+
+	a.block.ins = code.Instructions{}
+	a.block.labels = map[string]int{}
+
+	values, err := parseAttributes(attributes, map[string]bool{"tryslots": false})
+	if err != nil {
+		return err
+	}
+	a.block.numTrySlots = values["tryslots"]
+
+	return nil
+}
+
+func funcSection(index int) string {
+	return fmt.Sprintf(".func %d", index)
+}
+
+// constant adds the constant of a .const entry to the pool.
+func (a *assembler) constant(fields []string) error {
+	index := len(a.bytecode.Constants)
+	if n, err := strconv.Atoi(fields[0]); err == nil {
+		if n != index {
+			return fmt.Errorf("constant %d listed where constant %d belongs", n, index)
+		}
+		fields = fields[1:]
+	}
+
+	if len(fields) < 2 {
+		return fmt.Errorf("constant needs a type and a value")
+	}
+
+	var constant object.Object
+
+	switch fields[0] {
+	case "int":
+		if len(fields) != 2 {
+			return fmt.Errorf("int takes a single value")
+		}
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid int %s", fields[1])
+		}
+		constant = &object.Integer{Value: value}
+
+	case "string":
+		if len(fields) != 2 {
+			return fmt.Errorf("string takes a single value")
+		}
+		value, err := strconv.Unquote(fields[1])
+		if err != nil {
+			return fmt.Errorf("invalid string %s", fields[1])
+		}
+		constant = &object.String{Value: value}
+
+	case "func":
+		name, err := strconv.Unquote(fields[1])
+		if err != nil {
+			return fmt.Errorf("invalid function name %s", fields[1])
+		}
+		values, err := parseAttributes(fields[2:], map[string]bool{
+			"params": false, "locals": false, "defaults": false, "variadic": true,
+		})
+		if err != nil {
+			return err
+		}
+		constant = &object.CompiledFunction{
+			Name:          name,
+			Instructions:  code.Instructions{},
+			NumParameters: values["params"],
+			NumLocals:     values["locals"],
+			NumDefaults:   values["defaults"],
+			Variadic:      values["variadic"] == 1,
+		}
+
+	default:
+		return fmt.Errorf("unknown constant type %s", fields[0])
+	}
+
+	a.bytecode.Constants = append(a.bytecode.Constants, constant)
+	return nil
+}
+
+// instruction appends an instruction line to the code section.
+func (a *assembler) instruction(fields []string) error {
+	if _, err := strconv.Atoi(fields[0]); err == nil {
+		fields = fields[1:]
+		if len(fields) == 0 {
+			return fmt.Errorf("offset without an instruction")
+		}
+	}
+
+	// OpWide in front of an opcode forces the wide form, which is also used without it
+	// when a number operand doesn't fit its width
+	wide := false
+	if op, ok := code.LookupName(fields[0]); ok && op == code.OpWide && len(fields) > 1 {
+		wide = true
+		fields = fields[1:]
+	}
+
+	op, ok := code.LookupName(fields[0])
+	if !ok {
+		return fmt.Errorf("unknown opcode %s", fields[0])
+	}
+	def, _ := code.Lookup(byte(op))
+
+	args := fields[1:]
+	if len(args) != len(def.OperandWidths) {
+		return fmt.Errorf("%s takes %d operands, got %d", def.Name, len(def.OperandWidths), len(args))
+	}
+	if wide && len(args) == 0 {
+		return fmt.Errorf("OpWide prefixes %s, which has no operands", def.Name)
+	}
+
+	jumpIndex, jumps := code.JumpOperand(op)
+	operands := make([]int, len(args))
+	labels := make([]string, len(args))
+
+	for i, arg := range args {
+		n, err := strconv.Atoi(arg)
+		switch {
+		case err != nil && jumps && i == jumpIndex:
+			labels[i] = arg
+		case err != nil:
+			return fmt.Errorf("invalid operand %s of %s", arg, def.Name)
+		case !code.OperandFits(n, code.WideOperandWidth(def.OperandWidths[i])):
+			return fmt.Errorf("operand %d of %s does not fit in %d bytes", n, def.Name, code.WideOperandWidth(def.OperandWidths[i]))
+		default:
+			operands[i] = n
+			wide = wide || !code.OperandFits(n, def.OperandWidths[i])
+		}
+	}
+
+	offset := len(a.block.ins) + 1
+	if wide {
+		offset++
+	}
+	for i, label := range labels {
+		width := def.OperandWidths[i]
+		if wide {
+			width = code.WideOperandWidth(width)
+		}
+		if label != "" {
+			a.block.fixups = append(a.block.fixups, fixup{offset: offset, width: width, label: label, line: a.lineNumber})
+		}
+		offset += width
+	}
+
+	if wide {
+		a.block.ins = append(a.block.ins, code.MakeWide(op, operands...)...)
+	} else {
+		a.block.ins = append(a.block.ins, code.Make(op, operands...)...)
+	}
+	return nil
+}
+
+func (a *assembler) handler(fields []string) error {
+	if len(fields) != 5 {
+		return fmt.Errorf(".handler takes a start, end, target and slot")
+	}
+
+	slot, err := strconv.Atoi(fields[4])
+	if err != nil || slot < 0 {
+		return fmt.Errorf("invalid handler slot %s", fields[4])
+	}
+
+	a.block.handlers = append(a.block.handlers, handlerLine{
+		offsets: [3]string{fields[1], fields[2], fields[3]},
+		slot:    slot,
+		line:    a.lineNumber,
+	})
+	return nil
+}
+
+// finish resolves the labels of the code section being read and stores its instructions.
+func (a *assembler) finish() error {
+	a.inConst = false
+
+	block := a.block
+	if block == nil {
+		return nil
+	}
+	a.block = nil
+
+	for _, f := range block.fixups {
+		target, ok := block.labels[f.label]
+		if !ok {
+			return fmt.Errorf("line %d: undefined label %s", f.line, f.label)
+		}
+		if !code.OperandFits(target, f.width) {
+			return fmt.Errorf("line %d: offset %d of label %s does not fit in %d bytes, the jump needs OpWide",
+				f.line, target, f.label, f.width)
+		}
+		patch(block.ins[f.offset:], f.width, target)
+	}
+
+	var handlers []code.Handler
+	for _, h := range block.handlers {
+		offsets := [3]int{}
+		for i, name := range h.offsets {
+			offset, ok := block.labels[name]
+			if !ok {
+				n, err := strconv.Atoi(name)
+				if err != nil {
+					return fmt.Errorf("line %d: undefined label %s", h.line, name)
+				}
+				offset = n
+			}
+			offsets[i] = offset
+		}
+
+		handlers = append(handlers, code.Handler{Start: offsets[0], End: offsets[1], Target: offsets[2], Slot: h.slot})
+	}
+
+	block.store(block.ins, handlers, block.numTrySlots)
+	return nil
+}
+
+// patch writes n into the width bytes of an operand, big endian like code.Make.
+func patch(operand []byte, width, n int) {
+	for i := width - 1; i >= 0; i-- {
+		operand[i] = byte(n)
+		n >>= 8
+	}
+}
+
+// parseAttributes reads `key=value` fields. allowed maps every key that may appear to
+// whether its value is a boolean, stored as 0 or 1; the other values are numbers.
+func parseAttributes(fields []string, allowed map[string]bool) (map[string]int, error) {
+	values := map[string]int{}
+
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		isBool, known := allowed[key]
+		if !ok || !known {
+			return nil, fmt.Errorf("unknown attribute %s", field)
+		}
+
+		if isBool {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %s, want true or false", key, value)
+			}
+			if b {
+				values[key] = 1
+			}
+			continue
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s %s", key, value)
+		}
+		values[key] = n
+	}
+
+	return values, nil
+}
+
+// splitFields splits a line at whitespace, keeping quoted strings, escapes included, in
+// one field, and drops the comment that starts at the first semicolon outside of one.
+func splitFields(line string) ([]string, error) {
+	fields := []string{}
+	var field strings.Builder
+	inString := false
+
+	flush := func() {
+		if field.Len() > 0 {
+			fields = append(fields, field.String())
+			field.Reset()
+		}
+	}
+
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+
+		switch {
+		case inString:
+			field.WriteByte(ch)
+			if ch == '\\' && i+1 < len(line) {
+				i++
+				field.WriteByte(line[i])
+			} else if ch == '"' {
+				inString = false
+			}
+
+		case ch == '"':
+			inString = true
+			field.WriteByte(ch)
+
+		case ch == ';':
+			flush()
+			return fields, nil
+
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			flush()
+
+		default:
+			field.WriteByte(ch)
+		}
+	}
+
+	if inString {
+		return nil, fmt.Errorf("unterminated string")
+	}
+
+	flush()
+	return fields, nil
+}
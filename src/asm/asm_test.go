@@ -0,0 +1,182 @@
+package asm
+
+import (
+	"chui/code"
+	"chui/compiler"
+	"chui/object"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	input := `
+.const
+    int 10
+    string "a; \"b\""          ; quoted semicolons aren't comments
+    func "inc" params=1 locals=1 defaults=0 variadic=false
+
+.main
+    OpConstant 0
+    OpJumpNotTruthy else      ; labels may be used before they are defined
+    OpConstant 1
+    OpJump end
+else:
+    OpNull
+end:
+    OpPop
+
+.func 2 tryslots=1
+    0000 OpTry 0              ; offsets in front of instructions are ignored
+start:
+    OpGetLocal 0
+    OpReturnValue
+catch:
+    OpReturn
+    .handler start catch catch 0
+`
+
+	bytecode, err := Assemble(input)
+	if err != nil {
+		t.Fatalf("assembler error: %s", err)
+	}
+
+	expected := &compiler.Bytecode{
+		Instructions: concat(
+			code.Make(code.OpConstant, 0),
+			code.Make(code.OpJumpNotTruthy, 12),
+			code.Make(code.OpConstant, 1),
+			code.Make(code.OpJump, 13),
+			code.Make(code.OpNull),
+			code.Make(code.OpPop),
+		),
+		Constants: []object.Object{
+			&object.Integer{Value: 10},
+			&object.String{Value: `a; "b"`},
+			&object.CompiledFunction{
+				Name: "inc",
+				Instructions: concat(
+					code.Make(code.OpTry, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+					code.Make(code.OpReturn),
+				),
+				NumParameters: 1,
+				NumLocals:     1,
+				Handlers:      []code.Handler{{Start: 3, End: 6, Target: 6, Slot: 0}},
+				NumTrySlots:   1,
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(bytecode, expected) {
+		t.Errorf("wrong bytecode.\nwant=\n%s\ngot=\n%s", Disassemble(expected), Disassemble(bytecode))
+	}
+}
+
+func TestAssembleWide(t *testing.T) {
+	input := `
+.const
+    int 1
+.main
+    OpWide OpConstant 0
+    OpWide OpJump end
+    OpArray 70000             ; too large for 2 bytes, wide without asking
+end:
+    OpPop
+`
+
+	bytecode, err := Assemble(input)
+	if err != nil {
+		t.Fatalf("assembler error: %s", err)
+	}
+
+	expected := concat(
+		code.MakeWide(code.OpConstant, 0),
+		code.MakeWide(code.OpJump, 18),
+		code.Make(code.OpArray, 70000),
+		code.Make(code.OpPop),
+	)
+	if !reflect.DeepEqual(bytecode.Instructions, expected) {
+		t.Errorf("wrong instructions.\nwant=\n%s\ngot=\n%s", expected, bytecode.Instructions)
+	}
+}
+
+func TestAssembleDisassembledPrograms(t *testing.T) {
+	inputs := []string{
+		`let max = func(a, b = 0) { if (a > b) { a } else { b } }; max(1, 2);`,
+		`let f = func(...xs) { try { throw len(xs) } catch (e) { e } finally { puts("done") } }; f(1, 2)`,
+		`let add = func(a) { func(b) { a + b } }; add(1)(2); match ([1, 2]) { [x, ...rest] => x, _ => 0 }`,
+		"if (true) { " + strings.Repeat("true; ", 33000) + "1 } else { 2 }",
+	}
+
+	for _, input := range inputs {
+		bytecode := compile(t, input)
+		listing := Disassemble(bytecode)
+
+		assembled, err := Assemble(listing)
+		if err != nil {
+			t.Fatalf("assembler error for %q: %s\n%s", input, err, listing)
+		}
+
+		// Listings have no source positions
+		bytecode.SourceMap = nil
+		for _, constant := range bytecode.Constants {
+			if fn, ok := constant.(*object.CompiledFunction); ok {
+				fn.SourceMap = nil
+			}
+		}
+
+		if !reflect.DeepEqual(assembled, bytecode) {
+			t.Errorf("assembled bytecode differs for %q.\nwant=\n%s\ngot=\n%s",
+				input, listing, Disassemble(assembled))
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"OpPop", "line 1: OpPop outside of a section"},
+		{".data", "line 1: unknown section .data"},
+		{".main\n.main", "line 2: section .main defined twice"},
+		{".main\nOpFly", "line 2: unknown opcode OpFly"},
+		{".main\nOpConstant", "line 2: OpConstant takes 1 operands, got 0"},
+		{".main\nOpGetLocal 65536", "line 2: operand 65536 of OpGetLocal does not fit in 2 bytes"},
+		{".main\nOpWide OpPop", "line 2: OpWide prefixes OpPop, which has no operands"},
+		{".main\nOpConstant here", "line 2: invalid operand here of OpConstant"},
+		{".main\nOpJump nowhere", "line 2: undefined label nowhere"},
+		{".main\na:\na:", "line 3: label a defined twice"},
+		{".main\n.handler a b", "line 2: .handler takes a start, end, target and slot"},
+		{".const\n1 int 1", "line 2: constant 1 listed where constant 0 belongs"},
+		{".const\nfloat 1.5", "line 2: unknown constant type float"},
+		{".const\nstring \"open", "line 2: unterminated string"},
+		{".const\nfunc \"f\" arity=1", "line 2: unknown attribute arity=1"},
+		{".const\nfunc \"f\" variadic=maybe", "line 2: invalid variadic maybe, want true or false"},
+		{".const\nint 1\n.func 0", "line 3: constant 0 is not a function"},
+		{".func 0", "line 1: no constant 0"},
+		{".const\nfunc \"f\"", "function constant 0 has no .func section"},
+	}
+
+	for _, tt := range tests {
+		_, err := Assemble(tt.input)
+		if err == nil {
+			t.Errorf("expected an error assembling %q", tt.input)
+			continue
+		}
+
+		if err.Error() != tt.expectedError {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, tt.expectedError, err.Error())
+		}
+	}
+}
+
+func concat(instructions ...[]byte) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range instructions {
+		out = append(out, ins...)
+	}
+	return out
+}
@@ -0,0 +1,139 @@
+// Package asm converts bytecode to and from a readable assembly listing, to inspect what
+// the compiler produced and to write bytecode by hand.
+package asm
+
+import (
+	"bytes"
+	"chui/code"
+	"chui/compiler"
+	"chui/ir"
+	"chui/object"
+	"fmt"
+	"strconv"
+)
+
+// Disassemble() returns the listing of bytecode: a .const section with the constant pool,
+// a .main section with the instructions of the main program and a .func section with the
+// instructions of every compiled function in the pool. The constants instructions load
+// are shown in comments.
+func Disassemble(bytecode *compiler.Bytecode) string {
+	var out bytes.Buffer
+
+	out.WriteString(".const\n")
+	for i, constant := range bytecode.Constants {
+		fmt.Fprintf(&out, "    %d %s\n", i, constantEntry(constant))
+	}
+
+	annotate := func(op code.Opcode, operands []int) string {
+		return annotation(bytecode.Constants, op, operands)
+	}
+
+	fmt.Fprintf(&out, "\n.main%s\n", tryslots(bytecode.NumTrySlots))
+	out.WriteString(code.Disassemble(bytecode.Instructions, bytecode.Handlers, annotate))
+
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		fmt.Fprintf(&out, "\n.func %d%s\n", i, tryslots(fn.NumTrySlots))
+		out.WriteString(code.Disassemble(fn.Instructions, fn.Handlers, annotate))
+	}
+
+	return out.String()
+}
+
+// DumpIR() lists the control flow graph of the main program and of every compiled function in the pool, as ir.Dump
+// does, in .main and .func sections like Disassemble's. The constants instructions load are shown in comments.
+func DumpIR(bytecode *compiler.Bytecode) string {
+	var out bytes.Buffer
+
+	annotate := func(op code.Opcode, operands []int) string {
+		return annotation(bytecode.Constants, op, operands)
+	}
+
+	dump := func(ins code.Instructions, handlers []code.Handler, sourceMap code.SourceMap) string {
+		decoded, err := code.DecodeInstructions(ins)
+		if err != nil {
+			return fmt.Sprintf("    ERROR: %s\n", err)
+		}
+		fn, err := ir.Build(decoded, len(ins), handlers, sourceMap)
+		if err != nil {
+			return fmt.Sprintf("    ERROR: %s\n", err)
+		}
+		return ir.Dump(fn, annotate)
+	}
+
+	fmt.Fprintf(&out, ".main%s\n", tryslots(bytecode.NumTrySlots))
+	out.WriteString(dump(bytecode.Instructions, bytecode.Handlers, bytecode.SourceMap))
+
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+
+		fmt.Fprintf(&out, "\n.func %d%s\n", i, tryslots(fn.NumTrySlots))
+		out.WriteString(dump(fn.Instructions, fn.Handlers, fn.SourceMap))
+	}
+
+	return out.String()
+}
+
+// constantEntry() formats a constant for the .const section.
+func constantEntry(constant object.Object) string {
+	switch constant := constant.(type) {
+	case *object.Integer:
+		return fmt.Sprintf("int %d", constant.Value)
+
+	case *object.String:
+		return "string " + strconv.Quote(constant.Value)
+
+	case *object.CompiledFunction:
+		return fmt.Sprintf("func %s params=%d locals=%d defaults=%d variadic=%t",
+			strconv.Quote(constant.Name), constant.NumParameters, constant.NumLocals,
+			constant.NumDefaults, constant.Variadic)
+
+	default:
+		return fmt.Sprintf("ERROR: cannot list a constant of type %s", constant.Type())
+	}
+}
+
+// annotation() describes the constant or builtin an instruction refers to.
+func annotation(constants []object.Object, op code.Opcode, operands []int) string {
+	switch op {
+	case code.OpConstant, code.OpClosure:
+		index := operands[0]
+		if index >= len(constants) {
+			return "no such constant"
+		}
+
+		switch constant := constants[index].(type) {
+		case *object.Integer:
+			return fmt.Sprint(constant.Value)
+		case *object.String:
+			return strconv.Quote(constant.Value)
+		case *object.CompiledFunction:
+			return "func " + strconv.Quote(constant.Name)
+		default:
+			return constant.Inspect()
+		}
+
+	case code.OpGetBuiltin:
+		index := operands[0]
+		if index >= len(object.Builtins) {
+			return "no such builtin"
+		}
+		return object.Builtins[index].Name
+	}
+
+	return ""
+}
+
+func tryslots(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" tryslots=%d", n)
+}
@@ -0,0 +1,108 @@
+package asm
+
+import (
+	"chui/compiler"
+	"chui/lexer"
+	"chui/parser"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	input := `let max = func(a, b = 0) { if (a > b) { a } else { b } };
+try { puts(max(1, "two")) } catch { 0 };`
+
+	expected := `.const
+    0 int 0
+    1 func "max" params=2 locals=2 defaults=1 variadic=false
+    2 int 1
+    3 string "two"
+
+.main tryslots=1
+    0000 OpClosure 1 0            ; func "max"
+    0004 OpSetGlobal 0
+    0007 OpTry 0
+L0:
+    0010 OpGetBuiltin 1           ; puts
+    0012 OpGetGlobal 0
+    0015 OpConstant 2             ; 1
+    0018 OpConstant 3             ; "two"
+    0021 OpCall 2
+    0023 OpCall 1
+L1:
+    0025 OpJump L3
+L2:
+    0028 OpPop
+    0029 OpConstant 0             ; 0
+L3:
+    0032 OpPop
+    .handler L0 L1 L2 0
+
+.func 1
+    0000 OpJumpIfArgPassed 1 L0
+    0004 OpConstant 0             ; 0
+    0007 OpSetLocal 1
+L0:
+    0009 OpGetLocal 0
+    0011 OpGetLocal 1
+    0013 OpGreaterThan
+    0014 OpJumpNotTruthy L1
+    0017 OpGetLocal 0
+    0019 OpJump L2
+L1:
+    0022 OpGetLocal 1
+L2:
+    0024 OpReturnValue
+`
+
+	if actual := Disassemble(compile(t, input)); actual != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+}
+
+func TestDumpIR(t *testing.T) {
+	input := `let f = func(x) { if (x) { 2 } else { 3 } };`
+
+	expected := `.main
+b0:
+    OpClosure 2 0            ; func "f"
+    OpSetGlobal 0
+    -> b1
+b1:
+
+.func 2
+b0:
+    OpGetLocal 0
+    OpJumpNotTruthy b2
+    -> b1
+b1:
+    OpConstant 0             ; 2
+    -> b3
+b2:
+    OpConstant 1             ; 3
+    -> b3
+b3:
+    OpReturnValue
+b4:
+`
+
+	if actual := DumpIR(compile(t, input)); actual != expected {
+		t.Errorf("wrong dump.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+}
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	return comp.Bytecode()
+}
@@ -3,6 +3,7 @@ package ast
 import (
 	"bytes"
 	"chui/token"
+	"fmt"
 	"strings"
 )
 
@@ -71,6 +72,31 @@ func (ls *LetStatement) String() string {
 	return out.String()
 }
 
+// DestructuringLetStatement binds the parts of an array or hash, e.g. `let [a, ...rest] = arr;`.
+type DestructuringLetStatement struct {
+	Token   token.Token // the token.LET token
+	Pattern Expression  // an *ArrayPattern or *HashPattern, or an unquote call in quoted macro code
+	Value   Expression
+}
+
+func (ds *DestructuringLetStatement) statementNode()       {}
+func (ds *DestructuringLetStatement) TokenLiteral() string { return ds.Token.Literal }
+func (ds *DestructuringLetStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ds.TokenLiteral() + " ")
+	out.WriteString(ds.Pattern.String())
+	out.WriteString(" = ")
+
+	if ds.Value != nil {
+		out.WriteString(ds.Value.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
+
 type ReturnStatement struct {
 	Token       token.Token // the 'return' token
 	ReturnValue Expression
@@ -92,6 +118,27 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+type ThrowStatement struct {
+	Token token.Token // the 'throw' token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ts.TokenLiteral() + " ")
+
+	if ts.Value != nil {
+		out.WriteString(ts.Value.String())
+	}
+
+	out.WriteString(";")
+
+	return out.String()
+}
+
 type ExpressionStatement struct {
 	Token      token.Token // the first token of the expression
 	Expression Expression
@@ -219,7 +266,10 @@ func (ie *IfExpression) String() string {
 type FunctionLiteral struct {
 	Token      token.Token // The 'fn' token
 	Parameters []*Identifier
+	Defaults   []Expression // one per parameter, nil entries for parameters without a default
+	Rest       *Identifier  // nil unless the function is variadic, e.g. `func(a, ...rest)`
 	Body       *BlockStatement
+	Name       string // the name the function is bound to by a let statement, if any
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
@@ -228,11 +278,21 @@ func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, p := range fl.Parameters {
+	for i, p := range fl.Parameters {
+		if fl.Defaults != nil && fl.Defaults[i] != nil {
+			params = append(params, p.String()+" = "+fl.Defaults[i].String())
+			continue
+		}
 		params = append(params, p.String())
 	}
+	if fl.Rest != nil {
+		params = append(params, "..."+fl.Rest.String())
+	}
 
 	out.WriteString(fl.TokenLiteral())
+	if fl.Name != "" {
+		out.WriteString(fmt.Sprintf("<%s>", fl.Name))
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") ")
@@ -274,6 +334,16 @@ func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 
+// NullLiteral is the null value. The language has no syntax for it, it is only built by
+// macros that unquote a null, e.g. the result of `puts()`.
+type NullLiteral struct {
+	Token token.Token
+}
+
+func (nl *NullLiteral) expressionNode()      {}
+func (nl *NullLiteral) TokenLiteral() string { return nl.Token.Literal }
+func (nl *NullLiteral) String() string       { return "null" }
+
 type ArrayLiteral struct {
 	Token    token.Token // the '[' token
 	Elements []Expression
@@ -341,6 +411,7 @@ func (hl *HashLiteral) String() string {
 type MacroLiteral struct {
 	Token      token.Token // The 'macro' token
 	Parameters []*Identifier
+	Rest       *Identifier // nil unless the macro is variadic, e.g. `macro(first, ...rest)`
 	Body       *BlockStatement
 }
 
@@ -353,6 +424,9 @@ func (ml *MacroLiteral) String() string {
 	for _, p := range ml.Parameters {
 		params = append(params, p.String())
 	}
+	if ml.Rest != nil {
+		params = append(params, "..."+ml.Rest.String())
+	}
 
 	out.WriteString(ml.TokenLiteral())
 	out.WriteString("(")
@@ -362,3 +436,156 @@ func (ml *MacroLiteral) String() string {
 
 	return out.String()
 }
+
+type MatchExpression struct {
+	Token   token.Token // The 'match' token
+	Subject Expression
+	Arms    []*MatchArm
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+
+	arms := []string{}
+	for _, a := range me.Arms {
+		arms = append(arms, a.String())
+	}
+
+	out.WriteString("match (")
+	out.WriteString(me.Subject.String())
+	out.WriteString(") { ")
+	out.WriteString(strings.Join(arms, ", "))
+	out.WriteString(" }")
+
+	return out.String()
+}
+
+// MatchArm is a single `pattern [if guard] => body` arm of a match expression.
+type MatchArm struct {
+	Token   token.Token // The first token of the pattern
+	Pattern Expression
+	Guard   Expression // nil when the arm has no `if` guard
+	Body    Expression
+}
+
+func (ma *MatchArm) TokenLiteral() string { return ma.Token.Literal }
+func (ma *MatchArm) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ma.Pattern.String())
+	if ma.Guard != nil {
+		out.WriteString(" if ")
+		out.WriteString(ma.Guard.String())
+	}
+	out.WriteString(" => ")
+	out.WriteString(ma.Body.String())
+
+	return out.String()
+}
+
+// ArrayPattern matches arrays element by element, e.g. `[first, ...rest]`.
+type ArrayPattern struct {
+	Token    token.Token // the '[' token
+	Elements []Expression
+	Rest     *Identifier // nil when the pattern has no `...rest` element
+}
+
+func (ap *ArrayPattern) expressionNode()      {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) String() string {
+	var out bytes.Buffer
+
+	elements := []string{}
+	for _, el := range ap.Elements {
+		elements = append(elements, el.String())
+	}
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	}
+
+	out.WriteString("[")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("]")
+
+	return out.String()
+}
+
+type HashPatternPair struct {
+	Key   Expression
+	Value Expression
+}
+
+// HashPattern matches hashes containing the given keys, e.g. `{"name": n}`.
+// Pairs are kept in source order so patterns are tested deterministically.
+type HashPattern struct {
+	Token token.Token // the '{' token
+	Pairs []HashPatternPair
+}
+
+func (hp *HashPattern) expressionNode()      {}
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) String() string {
+	var out bytes.Buffer
+
+	pairs := []string{}
+	for _, pair := range hp.Pairs {
+		pairs = append(pairs, pair.Key.String()+":"+pair.Value.String())
+	}
+
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// DefaultPattern gives a destructured element a fallback, e.g. the `b = 10` in `let [a, b = 10] = arr;`.
+// The default is used when the element is missing or null.
+type DefaultPattern struct {
+	Token   token.Token // the '=' token
+	Target  Expression
+	Default Expression
+}
+
+func (dp *DefaultPattern) expressionNode()      {}
+func (dp *DefaultPattern) TokenLiteral() string { return dp.Token.Literal }
+func (dp *DefaultPattern) String() string {
+	return dp.Target.String() + " = " + dp.Default.String()
+}
+
+// TryExpression evaluates Body and, if it raises an error, binds the error to
+// CatchParameter and evaluates Catch instead. Finally, when present, always
+// runs last; its value is discarded.
+type TryExpression struct {
+	Token          token.Token // The 'try' token
+	Body           *BlockStatement
+	CatchParameter *Identifier     // nil for `catch { ... }` or when there is no catch clause
+	Catch          *BlockStatement // nil when there is no catch clause
+	Finally        *BlockStatement // nil when there is no finally clause
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Body.String())
+
+	if te.Catch != nil {
+		out.WriteString(" catch ")
+		if te.CatchParameter != nil {
+			out.WriteString("(" + te.CatchParameter.String() + ") ")
+		}
+		out.WriteString(te.Catch.String())
+	}
+
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
@@ -0,0 +1,251 @@
+package ast
+
+// Clone returns a deep copy of node, so the copy can be changed, for example by
+// Modify, without affecting the original. Tokens, and with them positions, are kept.
+func Clone(node Node) Node {
+	switch node := node.(type) {
+	case *Program:
+		return &Program{Statements: cloneStatements(node.Statements)}
+
+	case *LetStatement:
+		return &LetStatement{
+			Token: node.Token,
+			Name:  cloneIdentifier(node.Name),
+			Value: cloneExpression(node.Value),
+		}
+
+	case *DestructuringLetStatement:
+		return &DestructuringLetStatement{
+			Token:   node.Token,
+			Pattern: cloneExpression(node.Pattern),
+			Value:   cloneExpression(node.Value),
+		}
+
+	case *ReturnStatement:
+		return &ReturnStatement{Token: node.Token, ReturnValue: cloneExpression(node.ReturnValue)}
+
+	case *ThrowStatement:
+		return &ThrowStatement{Token: node.Token, Value: cloneExpression(node.Value)}
+
+	case *ExpressionStatement:
+		return &ExpressionStatement{Token: node.Token, Expression: cloneExpression(node.Expression)}
+
+	case *BlockStatement:
+		return cloneBlock(node)
+
+	case *Identifier:
+		return cloneIdentifier(node)
+
+	case *Boolean:
+		clone := *node
+		return &clone
+
+	case *IntegerLiteral:
+		clone := *node
+		return &clone
+
+	case *StringLiteral:
+		clone := *node
+		return &clone
+
+	case *NullLiteral:
+		clone := *node
+		return &clone
+
+	case *PrefixExpression:
+		return &PrefixExpression{
+			Token:    node.Token,
+			Operator: node.Operator,
+			Right:    cloneExpression(node.Right),
+		}
+
+	case *InfixExpression:
+		return &InfixExpression{
+			Token:    node.Token,
+			Left:     cloneExpression(node.Left),
+			Operator: node.Operator,
+			Right:    cloneExpression(node.Right),
+		}
+
+	case *IfExpression:
+		return &IfExpression{
+			Token:       node.Token,
+			Condition:   cloneExpression(node.Condition),
+			Consequence: cloneBlock(node.Consequence),
+			Alternative: cloneBlock(node.Alternative),
+		}
+
+	case *FunctionLiteral:
+		return &FunctionLiteral{
+			Token:      node.Token,
+			Parameters: cloneIdentifiers(node.Parameters),
+			Defaults:   cloneExpressions(node.Defaults),
+			Rest:       cloneIdentifier(node.Rest),
+			Body:       cloneBlock(node.Body),
+			Name:       node.Name,
+		}
+
+	case *CallExpression:
+		return &CallExpression{
+			Token:     node.Token,
+			Function:  cloneExpression(node.Function),
+			Arguments: cloneExpressions(node.Arguments),
+		}
+
+	case *ArrayLiteral:
+		return &ArrayLiteral{Token: node.Token, Elements: cloneExpressions(node.Elements)}
+
+	case *IndexExpression:
+		return &IndexExpression{
+			Token: node.Token,
+			Left:  cloneExpression(node.Left),
+			Index: cloneExpression(node.Index),
+		}
+
+	case *HashLiteral:
+		var pairs map[Expression]Expression
+		if node.Pairs != nil {
+			pairs = make(map[Expression]Expression, len(node.Pairs))
+			for key, val := range node.Pairs {
+				pairs[cloneExpression(key)] = cloneExpression(val)
+			}
+		}
+		return &HashLiteral{Token: node.Token, Pairs: pairs}
+
+	case *MacroLiteral:
+		return &MacroLiteral{
+			Token:      node.Token,
+			Parameters: cloneIdentifiers(node.Parameters),
+			Rest:       cloneIdentifier(node.Rest),
+			Body:       cloneBlock(node.Body),
+		}
+
+	case *MatchExpression:
+		var arms []*MatchArm
+		if node.Arms != nil {
+			arms = make([]*MatchArm, len(node.Arms))
+			for i, arm := range node.Arms {
+				arms[i] = cloneMatchArm(arm)
+			}
+		}
+		return &MatchExpression{Token: node.Token, Subject: cloneExpression(node.Subject), Arms: arms}
+
+	case *MatchArm:
+		return cloneMatchArm(node)
+
+	case *ArrayPattern:
+		return &ArrayPattern{
+			Token:    node.Token,
+			Elements: cloneExpressions(node.Elements),
+			Rest:     cloneIdentifier(node.Rest),
+		}
+
+	case *HashPattern:
+		var pairs []HashPatternPair
+		if node.Pairs != nil {
+			pairs = make([]HashPatternPair, len(node.Pairs))
+			for i, pair := range node.Pairs {
+				pairs[i] = HashPatternPair{Key: cloneExpression(pair.Key), Value: cloneExpression(pair.Value)}
+			}
+		}
+		return &HashPattern{Token: node.Token, Pairs: pairs}
+
+	case *DefaultPattern:
+		return &DefaultPattern{
+			Token:   node.Token,
+			Target:  cloneExpression(node.Target),
+			Default: cloneExpression(node.Default),
+		}
+
+	case *TryExpression:
+		return &TryExpression{
+			Token:          node.Token,
+			Body:           cloneBlock(node.Body),
+			CatchParameter: cloneIdentifier(node.CatchParameter),
+			Catch:          cloneBlock(node.Catch),
+			Finally:        cloneBlock(node.Finally),
+		}
+	}
+
+	return node
+}
+
+func cloneExpression(exp Expression) Expression {
+	if exp == nil {
+		return nil
+	}
+	return Clone(exp).(Expression)
+}
+
+func cloneStatement(stmt Statement) Statement {
+	if stmt == nil {
+		return nil
+	}
+	return Clone(stmt).(Statement)
+}
+
+func cloneExpressions(exps []Expression) []Expression {
+	if exps == nil {
+		return nil
+	}
+
+	clones := make([]Expression, len(exps))
+	for i, exp := range exps {
+		clones[i] = cloneExpression(exp)
+	}
+	return clones
+}
+
+func cloneBlock(block *BlockStatement) *BlockStatement {
+	if block == nil {
+		return nil
+	}
+
+	return &BlockStatement{Token: block.Token, Statements: cloneStatements(block.Statements)}
+}
+
+func cloneStatements(stmts []Statement) []Statement {
+	if stmts == nil {
+		return nil
+	}
+
+	clones := make([]Statement, len(stmts))
+	for i, stmt := range stmts {
+		clones[i] = cloneStatement(stmt)
+	}
+	return clones
+}
+
+func cloneIdentifier(ident *Identifier) *Identifier {
+	if ident == nil {
+		return nil
+	}
+
+	clone := *ident
+	return &clone
+}
+
+func cloneIdentifiers(idents []*Identifier) []*Identifier {
+	if idents == nil {
+		return nil
+	}
+
+	clones := make([]*Identifier, len(idents))
+	for i, ident := range idents {
+		clones[i] = cloneIdentifier(ident)
+	}
+	return clones
+}
+
+func cloneMatchArm(arm *MatchArm) *MatchArm {
+	if arm == nil {
+		return nil
+	}
+
+	return &MatchArm{
+		Token:   arm.Token,
+		Pattern: cloneExpression(arm.Pattern),
+		Guard:   cloneExpression(arm.Guard),
+		Body:    cloneExpression(arm.Body),
+	}
+}
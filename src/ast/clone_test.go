@@ -0,0 +1,52 @@
+package ast
+
+import "testing"
+
+func TestClone(t *testing.T) {
+	original := everyNodeProgram()
+	clone := Clone(original)
+
+	if !Equal(clone, original) {
+		t.Fatalf("clone differs from the original.\nwant=%s\ngot=%s", original.String(), clone.String())
+	}
+
+	originalNodes := map[Node]bool{}
+	Inspect(original, func(node Node) bool {
+		originalNodes[node] = true
+		return true
+	})
+
+	Inspect(clone, func(node Node) bool {
+		if originalNodes[node] {
+			t.Errorf("clone shares a %T with the original: %s", node, node.String())
+		}
+		return true
+	})
+}
+
+func TestCloneIsIndependent(t *testing.T) {
+	original := &InfixExpression{
+		Left:     &IntegerLiteral{Value: 1},
+		Operator: "+",
+		Right:    &Identifier{Value: "x"},
+	}
+	before := Clone(original)
+
+	clone := Clone(original)
+	_, err := Modify(clone, func(node Node) Node {
+		switch node := node.(type) {
+		case *IntegerLiteral:
+			node.Value = 2
+		case *Identifier:
+			return &IntegerLiteral{Value: 3}
+		}
+		return node
+	})
+	if err != nil {
+		t.Fatalf("Modify returned an error: %s", err)
+	}
+
+	if !Equal(original, before) {
+		t.Errorf("modifying the clone changed the original: %#v", original)
+	}
+}
@@ -0,0 +1,242 @@
+package ast
+
+// Equal reports whether a and b are the same tree: nodes of the same types with the
+// same values, operators and names. Tokens are ignored, so trees parsed from
+// differently formatted source, or built by macros, compare equal. Hash literal
+// pairs are compared regardless of order.
+func Equal(a, b Node) bool {
+	if isNil(a) || isNil(b) {
+		return isNil(a) && isNil(b)
+	}
+
+	switch a := a.(type) {
+	case *Program:
+		b, ok := b.(*Program)
+		return ok && equalStatements(a.Statements, b.Statements)
+
+	case *LetStatement:
+		b, ok := b.(*LetStatement)
+		return ok && Equal(a.Name, b.Name) && Equal(a.Value, b.Value)
+
+	case *DestructuringLetStatement:
+		b, ok := b.(*DestructuringLetStatement)
+		return ok && Equal(a.Pattern, b.Pattern) && Equal(a.Value, b.Value)
+
+	case *ReturnStatement:
+		b, ok := b.(*ReturnStatement)
+		return ok && Equal(a.ReturnValue, b.ReturnValue)
+
+	case *ThrowStatement:
+		b, ok := b.(*ThrowStatement)
+		return ok && Equal(a.Value, b.Value)
+
+	case *ExpressionStatement:
+		b, ok := b.(*ExpressionStatement)
+		return ok && Equal(a.Expression, b.Expression)
+
+	case *BlockStatement:
+		b, ok := b.(*BlockStatement)
+		return ok && equalStatements(a.Statements, b.Statements)
+
+	case *Identifier:
+		b, ok := b.(*Identifier)
+		return ok && a.Value == b.Value
+
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+
+	case *IntegerLiteral:
+		b, ok := b.(*IntegerLiteral)
+		return ok && a.Value == b.Value
+
+	case *StringLiteral:
+		b, ok := b.(*StringLiteral)
+		return ok && a.Value == b.Value
+
+	case *NullLiteral:
+		_, ok := b.(*NullLiteral)
+		return ok
+
+	case *PrefixExpression:
+		b, ok := b.(*PrefixExpression)
+		return ok && a.Operator == b.Operator && Equal(a.Right, b.Right)
+
+	case *InfixExpression:
+		b, ok := b.(*InfixExpression)
+		return ok && a.Operator == b.Operator && Equal(a.Left, b.Left) && Equal(a.Right, b.Right)
+
+	case *IfExpression:
+		b, ok := b.(*IfExpression)
+		return ok && Equal(a.Condition, b.Condition) &&
+			Equal(a.Consequence, b.Consequence) && Equal(a.Alternative, b.Alternative)
+
+	case *FunctionLiteral:
+		b, ok := b.(*FunctionLiteral)
+		return ok && a.Name == b.Name &&
+			equalIdentifiers(a.Parameters, b.Parameters) &&
+			equalDefaults(a.Defaults, b.Defaults) &&
+			Equal(a.Rest, b.Rest) && Equal(a.Body, b.Body)
+
+	case *CallExpression:
+		b, ok := b.(*CallExpression)
+		return ok && Equal(a.Function, b.Function) && equalExpressions(a.Arguments, b.Arguments)
+
+	case *ArrayLiteral:
+		b, ok := b.(*ArrayLiteral)
+		return ok && equalExpressions(a.Elements, b.Elements)
+
+	case *IndexExpression:
+		b, ok := b.(*IndexExpression)
+		return ok && Equal(a.Left, b.Left) && Equal(a.Index, b.Index)
+
+	case *HashLiteral:
+		b, ok := b.(*HashLiteral)
+		return ok && equalPairs(a.Pairs, b.Pairs)
+
+	case *MacroLiteral:
+		b, ok := b.(*MacroLiteral)
+		return ok && equalIdentifiers(a.Parameters, b.Parameters) &&
+			Equal(a.Rest, b.Rest) && Equal(a.Body, b.Body)
+
+	case *MatchExpression:
+		b, ok := b.(*MatchExpression)
+		if !ok || !Equal(a.Subject, b.Subject) || len(a.Arms) != len(b.Arms) {
+			return false
+		}
+		for i := range a.Arms {
+			if !Equal(a.Arms[i], b.Arms[i]) {
+				return false
+			}
+		}
+		return true
+
+	case *MatchArm:
+		b, ok := b.(*MatchArm)
+		return ok && Equal(a.Pattern, b.Pattern) && Equal(a.Guard, b.Guard) && Equal(a.Body, b.Body)
+
+	case *ArrayPattern:
+		b, ok := b.(*ArrayPattern)
+		return ok && equalExpressions(a.Elements, b.Elements) && Equal(a.Rest, b.Rest)
+
+	case *HashPattern:
+		b, ok := b.(*HashPattern)
+		if !ok || len(a.Pairs) != len(b.Pairs) {
+			return false
+		}
+		for i := range a.Pairs {
+			if !Equal(a.Pairs[i].Key, b.Pairs[i].Key) || !Equal(a.Pairs[i].Value, b.Pairs[i].Value) {
+				return false
+			}
+		}
+		return true
+
+	case *DefaultPattern:
+		b, ok := b.(*DefaultPattern)
+		return ok && Equal(a.Target, b.Target) && Equal(a.Default, b.Default)
+
+	case *TryExpression:
+		b, ok := b.(*TryExpression)
+		return ok && Equal(a.Body, b.Body) && Equal(a.CatchParameter, b.CatchParameter) &&
+			Equal(a.Catch, b.Catch) && Equal(a.Finally, b.Finally)
+	}
+
+	return false
+}
+
+// isNil reports whether node is nil, including a nil pointer stored in the interface,
+// such as the missing else branch of an if expression.
+func isNil(node Node) bool {
+	switch node := node.(type) {
+	case nil:
+		return true
+	case *BlockStatement:
+		return node == nil
+	case *Identifier:
+		return node == nil
+	case *MatchArm:
+		return node == nil
+	}
+	return false
+}
+
+func equalStatements(a, b []Statement) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalExpressions(a, b []Expression) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// equalDefaults compares the defaults of two functions, where a missing slice is
+// the same as one without any defaults.
+func equalDefaults(a, b []Expression) bool {
+	get := func(defaults []Expression, i int) Expression {
+		if i < len(defaults) {
+			return defaults[i]
+		}
+		return nil
+	}
+
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if !Equal(get(a, i), get(b, i)) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalIdentifiers(a, b []*Identifier) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// equalPairs matches every pair of a with a distinct equal pair of b.
+func equalPairs(a, b map[Expression]Expression) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	matched := make(map[Expression]bool, len(b))
+	for keyA, valA := range a {
+		found := false
+		for keyB, valB := range b {
+			if !matched[keyB] && Equal(keyA, keyB) && Equal(valA, valB) {
+				matched[keyB] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package ast
+
+import (
+	"chui/token"
+	"testing"
+)
+
+func TestEqual(t *testing.T) {
+	at := func(line, column int) token.Token {
+		return token.Token{Type: token.IDENT, Literal: "x", Line: line, Column: column}
+	}
+	ident := func(name string) *Identifier { return &Identifier{Value: name} }
+	integer := func(value int64) *IntegerLiteral { return &IntegerLiteral{Value: value} }
+	str := func(value string) *StringLiteral { return &StringLiteral{Value: value} }
+
+	tests := []struct {
+		a, b     Node
+		expected bool
+	}{
+		{everyNodeProgram(), everyNodeProgram(), true},
+		{
+			&Identifier{Token: at(1, 1), Value: "x"},
+			&Identifier{Token: at(7, 3), Value: "x"},
+			true,
+		},
+		{ident("x"), ident("y"), false},
+		{ident("x"), str("x"), false},
+		{
+			&InfixExpression{Left: integer(1), Operator: "+", Right: integer(2)},
+			&InfixExpression{Left: integer(1), Operator: "-", Right: integer(2)},
+			false,
+		},
+		{
+			&IfExpression{Condition: ident("x"), Consequence: &BlockStatement{}},
+			&IfExpression{Condition: ident("x"), Consequence: &BlockStatement{}, Alternative: &BlockStatement{}},
+			false,
+		},
+		{
+			&CallExpression{Function: ident("f"), Arguments: []Expression{integer(1)}},
+			&CallExpression{Function: ident("f"), Arguments: []Expression{integer(1), integer(2)}},
+			false,
+		},
+		{
+			&FunctionLiteral{Parameters: []*Identifier{ident("a")}, Body: &BlockStatement{}},
+			&FunctionLiteral{Parameters: []*Identifier{ident("a")}, Defaults: []Expression{nil}, Body: &BlockStatement{}},
+			true,
+		},
+		{
+			&FunctionLiteral{Parameters: []*Identifier{ident("a")}, Body: &BlockStatement{}},
+			&FunctionLiteral{Parameters: []*Identifier{ident("a")}, Rest: ident("b"), Body: &BlockStatement{}},
+			false,
+		},
+		{
+			&HashLiteral{Pairs: map[Expression]Expression{str("a"): integer(1), str("b"): integer(2)}},
+			&HashLiteral{Pairs: map[Expression]Expression{str("b"): integer(2), str("a"): integer(1)}},
+			true,
+		},
+		{
+			&HashLiteral{Pairs: map[Expression]Expression{str("a"): integer(1), str("b"): integer(2)}},
+			&HashLiteral{Pairs: map[Expression]Expression{str("a"): integer(2), str("b"): integer(1)}},
+			false,
+		},
+		{
+			&MatchExpression{Subject: ident("x"), Arms: []*MatchArm{{Pattern: integer(1), Body: integer(2)}}},
+			&MatchExpression{Subject: ident("x"), Arms: []*MatchArm{{Pattern: integer(1), Guard: ident("y"), Body: integer(2)}}},
+			false,
+		},
+		{nil, nil, true},
+		{ident("x"), nil, false},
+	}
+
+	for i, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.expected {
+			t.Errorf("tests[%d] - Equal(%v, %v) wrong. want=%t, got=%t", i, tt.a, tt.b, tt.expected, got)
+		}
+		if got := Equal(tt.b, tt.a); got != tt.expected {
+			t.Errorf("tests[%d] - Equal is not symmetric. want=%t, got=%t", i, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package ast
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// indentUnit is what Format indents the statements of a block with.
+const indentUnit = "    "
+
+// Format prints node as source code, one statement per line with blocks indented,
+// adding only the parentheses the operators need. Unlike String, the output reads like
+// a program someone wrote, and a program with no macro-made names, such as the ones
+// gensym() returns, parses back to an equal tree.
+func Format(node Node) string {
+	f := &formatter{}
+	f.node(node)
+	return f.out.String()
+}
+
+// Operator precedences, as in the parser, used to decide where parentheses are needed.
+const (
+	precLowest = iota
+	precEquals
+	precLessGreater
+	precSum
+	precProduct
+	precPrefix
+	precCall
+)
+
+var infixPrecedences = map[string]int{
+	"==": precEquals,
+	"!=": precEquals,
+	"<":  precLessGreater,
+	">":  precLessGreater,
+	"+":  precSum,
+	"-":  precSum,
+	"*":  precProduct,
+	"/":  precProduct,
+}
+
+type formatter struct {
+	out    bytes.Buffer
+	indent int
+}
+
+func (f *formatter) write(s string) {
+	f.out.WriteString(s)
+}
+
+func (f *formatter) newline() {
+	f.write("\n")
+	f.write(strings.Repeat(indentUnit, f.indent))
+}
+
+func (f *formatter) node(node Node) {
+	switch node := node.(type) {
+	case *Program:
+		for i, stmt := range node.Statements {
+			if i > 0 {
+				f.write("\n")
+			}
+			f.statement(stmt)
+		}
+		if len(node.Statements) > 0 {
+			f.write("\n")
+		}
+
+	case *BlockStatement:
+		f.block(node)
+
+	case *MatchArm:
+		f.matchArm(node)
+
+	case Statement:
+		f.statement(node)
+
+	case Expression:
+		f.expression(node, precLowest)
+	}
+}
+
+func (f *formatter) statement(stmt Statement) {
+	switch stmt := stmt.(type) {
+	case *LetStatement:
+		f.write("let ")
+		f.write(stmt.Name.Value)
+		f.write(" = ")
+		f.expression(stmt.Value, precLowest)
+		f.write(";")
+
+	case *DestructuringLetStatement:
+		f.write("let ")
+		f.expression(stmt.Pattern, precLowest)
+		f.write(" = ")
+		f.expression(stmt.Value, precLowest)
+		f.write(";")
+
+	case *ReturnStatement:
+		f.write("return")
+		if stmt.ReturnValue != nil {
+			f.write(" ")
+			f.expression(stmt.ReturnValue, precLowest)
+		}
+		f.write(";")
+
+	case *ThrowStatement:
+		f.write("throw ")
+		f.expression(stmt.Value, precLowest)
+		f.write(";")
+
+	case *ExpressionStatement:
+		f.expression(stmt.Expression, precLowest)
+		if !endsWithBlock(stmt.Expression) {
+			f.write(";")
+		}
+
+	case *BlockStatement:
+		f.block(stmt)
+	}
+}
+
+// endsWithBlock reports whether exp ends with a closing brace that needs no semicolon after it.
+func endsWithBlock(exp Expression) bool {
+	switch exp.(type) {
+	case *IfExpression, *MatchExpression, *TryExpression:
+		return true
+	}
+	return false
+}
+
+func (f *formatter) block(block *BlockStatement) {
+	if block == nil || len(block.Statements) == 0 {
+		f.write("{ }")
+		return
+	}
+
+	f.write("{")
+	f.indent++
+	for _, stmt := range block.Statements {
+		f.newline()
+		f.statement(stmt)
+	}
+	f.indent--
+	f.newline()
+	f.write("}")
+}
+
+// expression prints exp, in parentheses when it binds less tightly than prec requires.
+func (f *formatter) expression(exp Expression, prec int) {
+	switch exp := exp.(type) {
+	case nil:
+		return
+
+	case *Identifier:
+		f.write(exp.Value)
+
+	case *IntegerLiteral:
+		f.write(strconv.FormatInt(exp.Value, 10))
+
+	case *Boolean:
+		if exp.Value {
+			f.write("true")
+		} else {
+			f.write("false")
+		}
+
+	case *StringLiteral:
+		f.write(`"` + exp.Value + `"`)
+
+	case *NullLiteral:
+		f.write("null")
+
+	case *PrefixExpression:
+		f.parenthesize(prec > precPrefix, func() {
+			f.write(exp.Operator)
+			f.expression(exp.Right, precPrefix)
+		})
+
+	case *InfixExpression:
+		own := infixPrecedences[exp.Operator]
+		f.parenthesize(prec > own, func() {
+			f.expression(exp.Left, own)
+			f.write(" " + exp.Operator + " ")
+			// Infix operators are left-associative, so an equal operator on the right keeps its parentheses
+			f.expression(exp.Right, own+1)
+		})
+
+	case *IfExpression:
+		f.write("if (")
+		f.expression(exp.Condition, precLowest)
+		f.write(") ")
+		f.block(exp.Consequence)
+		if exp.Alternative != nil {
+			f.write(" else ")
+			f.block(exp.Alternative)
+		}
+
+	case *FunctionLiteral:
+		f.write("func(")
+		f.parameters(exp.Parameters, exp.Defaults, exp.Rest)
+		f.write(") ")
+		f.block(exp.Body)
+
+	case *MacroLiteral:
+		f.write("macro(")
+		f.parameters(exp.Parameters, nil, exp.Rest)
+		f.write(") ")
+		f.block(exp.Body)
+
+	case *CallExpression:
+		f.expression(exp.Function, precCall)
+		f.write("(")
+		f.expressionList(exp.Arguments)
+		f.write(")")
+
+	case *IndexExpression:
+		f.expression(exp.Left, precCall)
+		f.write("[")
+		f.expression(exp.Index, precLowest)
+		f.write("]")
+
+	case *ArrayLiteral:
+		f.write("[")
+		f.expressionList(exp.Elements)
+		f.write("]")
+
+	case *HashLiteral:
+		f.write("{")
+		for i, key := range sortedKeys(exp.Pairs) {
+			if i > 0 {
+				f.write(", ")
+			}
+			f.expression(key, precLowest)
+			f.write(": ")
+			f.expression(exp.Pairs[key], precLowest)
+		}
+		f.write("}")
+
+	case *MatchExpression:
+		f.write("match (")
+		f.expression(exp.Subject, precLowest)
+		f.write(") {")
+		f.indent++
+		for i, arm := range exp.Arms {
+			f.newline()
+			f.matchArm(arm)
+			if i < len(exp.Arms)-1 {
+				f.write(",")
+			}
+		}
+		f.indent--
+		f.newline()
+		f.write("}")
+
+	case *ArrayPattern:
+		f.write("[")
+		f.expressionList(exp.Elements)
+		if exp.Rest != nil {
+			if len(exp.Elements) > 0 {
+				f.write(", ")
+			}
+			f.write("..." + exp.Rest.Value)
+		}
+		f.write("]")
+
+	case *HashPattern:
+		f.write("{")
+		for i, pair := range exp.Pairs {
+			if i > 0 {
+				f.write(", ")
+			}
+			f.expression(pair.Key, precLowest)
+			f.write(": ")
+			f.expression(pair.Value, precLowest)
+		}
+		f.write("}")
+
+	case *DefaultPattern:
+		f.expression(exp.Target, precLowest)
+		f.write(" = ")
+		f.expression(exp.Default, precLowest)
+
+	case *TryExpression:
+		f.write("try ")
+		f.block(exp.Body)
+		if exp.Catch != nil {
+			f.write(" catch ")
+			if exp.CatchParameter != nil {
+				f.write("(" + exp.CatchParameter.Value + ") ")
+			}
+			f.block(exp.Catch)
+		}
+		if exp.Finally != nil {
+			f.write(" finally ")
+			f.block(exp.Finally)
+		}
+
+	default:
+		f.write(exp.String())
+	}
+}
+
+func (f *formatter) parenthesize(needed bool, print func()) {
+	if needed {
+		f.write("(")
+	}
+	print()
+	if needed {
+		f.write(")")
+	}
+}
+
+func (f *formatter) expressionList(exps []Expression) {
+	for i, exp := range exps {
+		if i > 0 {
+			f.write(", ")
+		}
+		f.expression(exp, precLowest)
+	}
+}
+
+func (f *formatter) parameters(params []*Identifier, defaults []Expression, rest *Identifier) {
+	for i, param := range params {
+		if i > 0 {
+			f.write(", ")
+		}
+		f.write(param.Value)
+		if i < len(defaults) && defaults[i] != nil {
+			f.write(" = ")
+			f.expression(defaults[i], precLowest)
+		}
+	}
+	if rest != nil {
+		if len(params) > 0 {
+			f.write(", ")
+		}
+		f.write("..." + rest.Value)
+	}
+}
+
+func (f *formatter) matchArm(arm *MatchArm) {
+	f.expression(arm.Pattern, precLowest)
+	if arm.Guard != nil {
+		f.write(" if ")
+		f.expression(arm.Guard, precLowest)
+	}
+	f.write(" => ")
+	f.expression(arm.Body, precLowest)
+}
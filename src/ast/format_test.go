@@ -0,0 +1,82 @@
+package ast
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	ident := func(name string) *Identifier { return &Identifier{Value: name} }
+	integer := func(value int64) *IntegerLiteral { return &IntegerLiteral{Value: value} }
+	infix := func(left Expression, operator string, right Expression) *InfixExpression {
+		return &InfixExpression{Left: left, Operator: operator, Right: right}
+	}
+
+	tests := []struct {
+		node     Node
+		expected string
+	}{
+		{infix(infix(integer(1), "+", integer(2)), "*", integer(3)), "(1 + 2) * 3"},
+		{infix(integer(1), "+", infix(integer(2), "*", integer(3))), "1 + 2 * 3"},
+		{infix(integer(1), "-", infix(integer(2), "-", integer(3))), "1 - (2 - 3)"},
+		{infix(infix(integer(1), "-", integer(2)), "-", integer(3)), "1 - 2 - 3"},
+		{&PrefixExpression{Operator: "-", Right: infix(ident("a"), "+", ident("b"))}, "-(a + b)"},
+		{&StringLiteral{Value: "hi"}, `"hi"`},
+		{&NullLiteral{}, "null"},
+		{
+			&CallExpression{Function: ident("f"), Arguments: []Expression{
+				&ArrayLiteral{Elements: []Expression{integer(1), &Boolean{Value: true}}},
+				&IndexExpression{Left: infix(ident("a"), "+", ident("b")), Index: integer(0)},
+			}},
+			"f([1, true], (a + b)[0])",
+		},
+		{
+			&Program{Statements: []Statement{
+				&LetStatement{Name: ident("max"), Value: &FunctionLiteral{
+					Parameters: []*Identifier{ident("a"), ident("b")},
+					Defaults:   []Expression{nil, integer(0)},
+					Body: &BlockStatement{Statements: []Statement{
+						&ExpressionStatement{Expression: &IfExpression{
+							Condition:   infix(ident("a"), ">", ident("b")),
+							Consequence: &BlockStatement{Statements: []Statement{&ReturnStatement{ReturnValue: ident("a")}}},
+							Alternative: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: ident("b")}}},
+						}},
+					}},
+				}},
+				&ExpressionStatement{Expression: &CallExpression{Function: ident("max"), Arguments: []Expression{integer(1)}}},
+			}},
+			"let max = func(a, b = 0) {\n" +
+				"    if (a > b) {\n" +
+				"        return a;\n" +
+				"    } else {\n" +
+				"        b;\n" +
+				"    }\n" +
+				"};\n" +
+				"max(1);\n",
+		},
+		{
+			&MatchExpression{Subject: ident("x"), Arms: []*MatchArm{
+				{
+					Pattern: &ArrayPattern{Elements: []Expression{ident("first")}, Rest: ident("rest")},
+					Guard:   infix(ident("first"), ">", integer(0)),
+					Body:    ident("rest"),
+				},
+				{Pattern: ident("_"), Body: &ArrayLiteral{}},
+			}},
+			"match (x) {\n    [first, ...rest] if first > 0 => rest,\n    _ => []\n}",
+		},
+		{
+			&TryExpression{
+				Body:           &BlockStatement{Statements: []Statement{&ThrowStatement{Value: &StringLiteral{Value: "oops"}}}},
+				CatchParameter: ident("e"),
+				Catch:          &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: ident("e")}}},
+				Finally:        &BlockStatement{},
+			},
+			"try {\n    throw \"oops\";\n} catch (e) {\n    e;\n} finally { }",
+		},
+	}
+
+	for _, tt := range tests {
+		formatted := Format(tt.node)
+		if formatted != tt.expected {
+			t.Errorf("wrong format.\nwant=%q\ngot=%q", tt.expected, formatted)
+		}
+	}
+}
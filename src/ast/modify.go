@@ -1,67 +1,322 @@
 package ast
 
+import "fmt"
+
 type ModifierFunc func(Node) Node
 
-func Modify(node Node, modifier ModifierFunc) Node {
+// ModifyError reports a modifier that replaced a node with one that can't take its place,
+// such as a statement where the tree holds an expression.
+type ModifyError struct {
+	Original    Node   // the node the modifier was called with
+	Replacement Node   // what the modifier returned, possibly nil
+	Expected    string // the kind of node the tree holds there, e.g. "an expression"
+}
+
+func (e *ModifyError) Error() string {
+	if e.Replacement == nil {
+		return fmt.Sprintf("cannot replace %s with nothing: expected %s", e.Original.String(), e.Expected)
+	}
+
+	return fmt.Sprintf("cannot replace %s with %s: %T is not %s",
+		e.Original.String(), e.Replacement.String(), e.Replacement, e.Expected)
+}
+
+// Modify walks node depth first and replaces every node in it with what modifier
+// returns for that node, children before their parents. It stops at the first
+// replacement that doesn't fit where the original node was and returns a *ModifyError.
+func Modify(node Node, modifier ModifierFunc) (Node, error) {
+	var err error
+
 	switch node := node.(type) {
 
 	case *Program:
 		for i, statement := range node.Statements {
-			node.Statements[i], _ = Modify(statement, modifier).(Statement)
+			if node.Statements[i], err = modifyStatement(statement, modifier); err != nil {
+				return nil, err
+			}
 		}
 
 	case *ExpressionStatement:
-		node.Expression, _ = Modify(node.Expression, modifier).(Expression)
+		if node.Expression, err = modifyExpression(node.Expression, modifier); err != nil {
+			return nil, err
+		}
 
 	case *InfixExpression:
-		node.Left, _ = Modify(node.Left, modifier).(Expression)
-		node.Right, _ = Modify(node.Right, modifier).(Expression)
+		if node.Left, err = modifyExpression(node.Left, modifier); err != nil {
+			return nil, err
+		}
+		if node.Right, err = modifyExpression(node.Right, modifier); err != nil {
+			return nil, err
+		}
 
 	case *PrefixExpression:
-		node.Right, _ = Modify(node.Right, modifier).(Expression)
+		if node.Right, err = modifyExpression(node.Right, modifier); err != nil {
+			return nil, err
+		}
 
 	case *IndexExpression:
-		node.Left, _ = Modify(node.Left, modifier).(Expression)
-		node.Index, _ = Modify(node.Index, modifier).(Expression)
+		if node.Left, err = modifyExpression(node.Left, modifier); err != nil {
+			return nil, err
+		}
+		if node.Index, err = modifyExpression(node.Index, modifier); err != nil {
+			return nil, err
+		}
 
 	case *IfExpression:
-		node.Condition, _ = Modify(node.Condition, modifier).(Expression)
-		node.Consequence, _ = Modify(node.Consequence, modifier).(*BlockStatement)
-		if node.Alternative != nil {
-			node.Alternative, _ = Modify(node.Alternative, modifier).(*BlockStatement)
+		if node.Condition, err = modifyExpression(node.Condition, modifier); err != nil {
+			return nil, err
+		}
+		if node.Consequence, err = modifyBlock(node.Consequence, modifier); err != nil {
+			return nil, err
+		}
+		if node.Alternative, err = modifyBlock(node.Alternative, modifier); err != nil {
+			return nil, err
 		}
 
 	case *BlockStatement:
-		for i, _ := range node.Statements {
-			node.Statements[i], _ = Modify(node.Statements[i], modifier).(Statement)
+		for i, statement := range node.Statements {
+			if node.Statements[i], err = modifyStatement(statement, modifier); err != nil {
+				return nil, err
+			}
 		}
 
 	case *ReturnStatement:
-		node.ReturnValue, _ = Modify(node.ReturnValue, modifier).(Expression)
+		if node.ReturnValue, err = modifyExpression(node.ReturnValue, modifier); err != nil {
+			return nil, err
+		}
+
+	case *ThrowStatement:
+		if node.Value, err = modifyExpression(node.Value, modifier); err != nil {
+			return nil, err
+		}
 
 	case *LetStatement:
-		node.Value, _ = Modify(node.Value, modifier).(Expression)
+		if node.Name, err = modifyIdentifier(node.Name, modifier); err != nil {
+			return nil, err
+		}
+		if node.Value, err = modifyExpression(node.Value, modifier); err != nil {
+			return nil, err
+		}
+
+	case *DestructuringLetStatement:
+		if node.Pattern, err = modifyExpression(node.Pattern, modifier); err != nil {
+			return nil, err
+		}
+		if node.Value, err = modifyExpression(node.Value, modifier); err != nil {
+			return nil, err
+		}
 
 	case *FunctionLiteral:
-		for i, _ := range node.Parameters {
-			node.Parameters[i], _ = Modify(node.Parameters[i], modifier).(*Identifier)
+		if err = modifyParameters(node.Parameters, modifier); err != nil {
+			return nil, err
+		}
+		for i, def := range node.Defaults {
+			if node.Defaults[i], err = modifyExpression(def, modifier); err != nil {
+				return nil, err
+			}
+		}
+		if node.Rest, err = modifyIdentifier(node.Rest, modifier); err != nil {
+			return nil, err
+		}
+		if node.Body, err = modifyBlock(node.Body, modifier); err != nil {
+			return nil, err
+		}
+
+	case *CallExpression:
+		if node.Function, err = modifyExpression(node.Function, modifier); err != nil {
+			return nil, err
+		}
+		for i, arg := range node.Arguments {
+			if node.Arguments[i], err = modifyExpression(arg, modifier); err != nil {
+				return nil, err
+			}
 		}
-		node.Body, _ = Modify(node.Body, modifier).(*BlockStatement)
 
 	case *ArrayLiteral:
-		for i, _ := range node.Elements {
-			node.Elements[i], _ = Modify(node.Elements[i], modifier).(Expression)
+		for i, element := range node.Elements {
+			if node.Elements[i], err = modifyExpression(element, modifier); err != nil {
+				return nil, err
+			}
 		}
 
 	case *HashLiteral:
 		newPairs := make(map[Expression]Expression)
 		for key, val := range node.Pairs {
-			newKey, _ := Modify(key, modifier).(Expression)
-			newVal, _ := Modify(val, modifier).(Expression)
+			newKey, err := modifyExpression(key, modifier)
+			if err != nil {
+				return nil, err
+			}
+			newVal, err := modifyExpression(val, modifier)
+			if err != nil {
+				return nil, err
+			}
 			newPairs[newKey] = newVal
 		}
 		node.Pairs = newPairs
+
+	case *MacroLiteral:
+		if err = modifyParameters(node.Parameters, modifier); err != nil {
+			return nil, err
+		}
+		if node.Rest, err = modifyIdentifier(node.Rest, modifier); err != nil {
+			return nil, err
+		}
+		if node.Body, err = modifyBlock(node.Body, modifier); err != nil {
+			return nil, err
+		}
+
+	case *MatchExpression:
+		if node.Subject, err = modifyExpression(node.Subject, modifier); err != nil {
+			return nil, err
+		}
+		for i, arm := range node.Arms {
+			modified, err := Modify(arm, modifier)
+			if err != nil {
+				return nil, err
+			}
+			newArm, ok := modified.(*MatchArm)
+			if !ok || newArm == nil {
+				return nil, &ModifyError{Original: arm, Replacement: modified, Expected: "a match arm"}
+			}
+			node.Arms[i] = newArm
+		}
+
+	case *MatchArm:
+		if node.Pattern, err = modifyExpression(node.Pattern, modifier); err != nil {
+			return nil, err
+		}
+		if node.Guard, err = modifyExpression(node.Guard, modifier); err != nil {
+			return nil, err
+		}
+		if node.Body, err = modifyExpression(node.Body, modifier); err != nil {
+			return nil, err
+		}
+
+	case *ArrayPattern:
+		for i, element := range node.Elements {
+			if node.Elements[i], err = modifyExpression(element, modifier); err != nil {
+				return nil, err
+			}
+		}
+		if node.Rest, err = modifyIdentifier(node.Rest, modifier); err != nil {
+			return nil, err
+		}
+
+	case *HashPattern:
+		for i, pair := range node.Pairs {
+			if node.Pairs[i].Key, err = modifyExpression(pair.Key, modifier); err != nil {
+				return nil, err
+			}
+			if node.Pairs[i].Value, err = modifyExpression(pair.Value, modifier); err != nil {
+				return nil, err
+			}
+		}
+
+	case *DefaultPattern:
+		if node.Target, err = modifyExpression(node.Target, modifier); err != nil {
+			return nil, err
+		}
+		if node.Default, err = modifyExpression(node.Default, modifier); err != nil {
+			return nil, err
+		}
+
+	case *TryExpression:
+		if node.Body, err = modifyBlock(node.Body, modifier); err != nil {
+			return nil, err
+		}
+		if node.CatchParameter, err = modifyIdentifier(node.CatchParameter, modifier); err != nil {
+			return nil, err
+		}
+		if node.Catch, err = modifyBlock(node.Catch, modifier); err != nil {
+			return nil, err
+		}
+		if node.Finally, err = modifyBlock(node.Finally, modifier); err != nil {
+			return nil, err
+		}
+	}
+
+	return modifier(node), nil
+}
+
+// modifyExpression modifies an optional child expression, leaving a nil one as is.
+func modifyExpression(exp Expression, modifier ModifierFunc) (Expression, error) {
+	if exp == nil {
+		return nil, nil
+	}
+
+	modified, err := Modify(exp, modifier)
+	if err != nil {
+		return nil, err
+	}
+
+	newExp, ok := modified.(Expression)
+	if !ok {
+		return nil, &ModifyError{Original: exp, Replacement: modified, Expected: "an expression"}
+	}
+
+	return newExp, nil
+}
+
+func modifyStatement(stmt Statement, modifier ModifierFunc) (Statement, error) {
+	modified, err := Modify(stmt, modifier)
+	if err != nil {
+		return nil, err
+	}
+
+	newStmt, ok := modified.(Statement)
+	if !ok {
+		return nil, &ModifyError{Original: stmt, Replacement: modified, Expected: "a statement"}
+	}
+
+	return newStmt, nil
+}
+
+// modifyBlock modifies an optional child block, such as an else branch, leaving a nil one as is.
+func modifyBlock(block *BlockStatement, modifier ModifierFunc) (*BlockStatement, error) {
+	if block == nil {
+		return nil, nil
+	}
+
+	modified, err := Modify(block, modifier)
+	if err != nil {
+		return nil, err
+	}
+
+	newBlock, ok := modified.(*BlockStatement)
+	if !ok || newBlock == nil {
+		return nil, &ModifyError{Original: block, Replacement: modified, Expected: "a block statement"}
+	}
+
+	return newBlock, nil
+}
+
+// modifyIdentifier modifies an optional identifier that binds a name, leaving a nil one as is.
+func modifyIdentifier(ident *Identifier, modifier ModifierFunc) (*Identifier, error) {
+	if ident == nil {
+		return nil, nil
+	}
+
+	modified, err := Modify(ident, modifier)
+	if err != nil {
+		return nil, err
+	}
+
+	newIdent, ok := modified.(*Identifier)
+	if !ok || newIdent == nil {
+		return nil, &ModifyError{Original: ident, Replacement: modified, Expected: "an identifier"}
+	}
+
+	return newIdent, nil
+}
+
+func modifyParameters(params []*Identifier, modifier ModifierFunc) error {
+	for i, param := range params {
+		newParam, err := modifyIdentifier(param, modifier)
+		if err != nil {
+			return err
+		}
+		params[i] = newParam
 	}
 
-	return modifier(node)
+	return nil
 }
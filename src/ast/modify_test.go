@@ -1,6 +1,8 @@
 package ast
 
 import (
+	"chui/token"
+	"fmt"
 	"reflect"
 	"testing"
 )
@@ -117,6 +119,106 @@ func TestModify(t *testing.T) {
 			&ArrayLiteral{Elements: []Expression{one(), one()}},
 			&ArrayLiteral{Elements: []Expression{two(), two()}},
 		},
+		{
+			&MatchExpression{
+				Subject: one(),
+				Arms: []*MatchArm{
+					{Pattern: &Identifier{Value: "x"}, Guard: one(), Body: one()},
+				},
+			},
+			&MatchExpression{
+				Subject: two(),
+				Arms: []*MatchArm{
+					{Pattern: &Identifier{Value: "x"}, Guard: two(), Body: two()},
+				},
+			},
+		},
+		{
+			&CallExpression{Function: one(), Arguments: []Expression{one(), one()}},
+			&CallExpression{Function: two(), Arguments: []Expression{two(), two()}},
+		},
+		{
+			&MacroLiteral{
+				Parameters: []*Identifier{},
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: one()},
+					},
+				},
+			},
+			&MacroLiteral{
+				Parameters: []*Identifier{},
+				Body: &BlockStatement{
+					Statements: []Statement{
+						&ExpressionStatement{Expression: two()},
+					},
+				},
+			},
+		},
+		{
+			&FunctionLiteral{
+				Parameters: []*Identifier{{Value: "a"}, {Value: "b"}},
+				Defaults:   []Expression{nil, one()},
+				Body:       &BlockStatement{},
+			},
+			&FunctionLiteral{
+				Parameters: []*Identifier{{Value: "a"}, {Value: "b"}},
+				Defaults:   []Expression{nil, two()},
+				Body:       &BlockStatement{},
+			},
+		},
+		{
+			&ThrowStatement{Value: one()},
+			&ThrowStatement{Value: two()},
+		},
+		{
+			&DestructuringLetStatement{
+				Pattern: &ArrayPattern{
+					Elements: []Expression{
+						one(),
+						&DefaultPattern{Target: &Identifier{Value: "x"}, Default: one()},
+						&HashPattern{Pairs: []HashPatternPair{{Key: one(), Value: one()}}},
+					},
+				},
+				Value: one(),
+			},
+			&DestructuringLetStatement{
+				Pattern: &ArrayPattern{
+					Elements: []Expression{
+						two(),
+						&DefaultPattern{Target: &Identifier{Value: "x"}, Default: two()},
+						&HashPattern{Pairs: []HashPatternPair{{Key: two(), Value: two()}}},
+					},
+				},
+				Value: two(),
+			},
+		},
+		{
+			&MatchExpression{
+				Subject: one(),
+				Arms: []*MatchArm{
+					{Pattern: &ArrayPattern{Elements: []Expression{one()}}, Body: one()},
+				},
+			},
+			&MatchExpression{
+				Subject: two(),
+				Arms: []*MatchArm{
+					{Pattern: &ArrayPattern{Elements: []Expression{two()}}, Body: two()},
+				},
+			},
+		},
+		{
+			&TryExpression{
+				Body:    &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+				Catch:   &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+				Finally: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+			},
+			&TryExpression{
+				Body:    &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+				Catch:   &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+				Finally: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+			},
+		},
 	}
 
 	hashLiteral := &HashLiteral{
@@ -140,7 +242,10 @@ func TestModify(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		modified := Modify(tt.input, turnOneIntoTwo)
+		modified, err := Modify(tt.input, turnOneIntoTwo)
+		if err != nil {
+			t.Fatalf("Modify returned an error: %s", err)
+		}
 
 		equal := reflect.DeepEqual(modified, tt.expected)
 		if !equal {
@@ -149,3 +254,91 @@ func TestModify(t *testing.T) {
 		}
 	}
 }
+
+func TestModifyErrors(t *testing.T) {
+	ident := func(name string) *Identifier {
+		return &Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}
+	}
+	integer := func(value int64) *IntegerLiteral {
+		literal := fmt.Sprintf("%d", value)
+		return &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: literal}, Value: value}
+	}
+
+	tests := []struct {
+		input    Node
+		modifier ModifierFunc
+		expected string
+	}{
+		{
+			&InfixExpression{Left: ident("x"), Operator: "+", Right: integer(1)},
+			func(node Node) Node {
+				if node, ok := node.(*Identifier); ok {
+					return &ExpressionStatement{Expression: node}
+				}
+				return node
+			},
+			"cannot replace x with x: *ast.ExpressionStatement is not an expression",
+		},
+		{
+			&CallExpression{Function: ident("f"), Arguments: []Expression{integer(1)}},
+			func(node Node) Node {
+				if _, ok := node.(*IntegerLiteral); ok {
+					return nil
+				}
+				return node
+			},
+			"cannot replace 1 with nothing: expected an expression",
+		},
+		{
+			&FunctionLiteral{
+				Token:      token.Token{Type: token.FUNCTION, Literal: "func"},
+				Parameters: []*Identifier{ident("a")},
+				Body:       &BlockStatement{},
+			},
+			func(node Node) Node {
+				if _, ok := node.(*Identifier); ok {
+					return integer(1)
+				}
+				return node
+			},
+			"cannot replace a with 1: *ast.IntegerLiteral is not an identifier",
+		},
+		{
+			&IfExpression{
+				Condition: ident("x"),
+				Consequence: &BlockStatement{
+					Statements: []Statement{&ExpressionStatement{Expression: integer(1)}},
+				},
+			},
+			func(node Node) Node {
+				if node, ok := node.(*BlockStatement); ok {
+					return node.Statements[0]
+				}
+				return node
+			},
+			"cannot replace 1 with 1: *ast.ExpressionStatement is not a block statement",
+		},
+		{
+			&Program{Statements: []Statement{&ExpressionStatement{Expression: ident("x")}}},
+			func(node Node) Node {
+				if node, ok := node.(*ExpressionStatement); ok {
+					return node.Expression
+				}
+				return node
+			},
+			"cannot replace x with x: *ast.Identifier is not a statement",
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := Modify(tt.input, tt.modifier)
+		if err == nil {
+			t.Errorf("expected an error for %s", tt.input.String())
+			continue
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package ast
+
+import "chui/token"
+
+// Position returns the line and column of the token a node was parsed from, the
+// operator for infix expressions and the opening parenthesis for calls. Nodes built
+// outside the parser, such as those produced by macros, report 0, 0.
+func Position(node Node) (int, int) {
+	var tok token.Token
+
+	switch node := node.(type) {
+	case *Program:
+		if len(node.Statements) == 0 {
+			return 0, 0
+		}
+		return Position(node.Statements[0])
+	case *LetStatement:
+		tok = node.Token
+	case *DestructuringLetStatement:
+		tok = node.Token
+	case *ReturnStatement:
+		tok = node.Token
+	case *ThrowStatement:
+		tok = node.Token
+	case *ExpressionStatement:
+		tok = node.Token
+	case *BlockStatement:
+		tok = node.Token
+	case *Identifier:
+		tok = node.Token
+	case *Boolean:
+		tok = node.Token
+	case *IntegerLiteral:
+		tok = node.Token
+	case *PrefixExpression:
+		tok = node.Token
+	case *InfixExpression:
+		tok = node.Token
+	case *IfExpression:
+		tok = node.Token
+	case *FunctionLiteral:
+		tok = node.Token
+	case *CallExpression:
+		tok = node.Token
+	case *StringLiteral:
+		tok = node.Token
+	case *NullLiteral:
+		tok = node.Token
+	case *ArrayLiteral:
+		tok = node.Token
+	case *IndexExpression:
+		tok = node.Token
+	case *HashLiteral:
+		tok = node.Token
+	case *MacroLiteral:
+		tok = node.Token
+	case *MatchExpression:
+		tok = node.Token
+	case *MatchArm:
+		tok = node.Token
+	case *ArrayPattern:
+		tok = node.Token
+	case *HashPattern:
+		tok = node.Token
+	case *DefaultPattern:
+		tok = node.Token
+	case *TryExpression:
+		tok = node.Token
+	}
+
+	return tok.Line, tok.Column
+}
@@ -0,0 +1,211 @@
+package ast
+
+import "sort"
+
+// Visitor holds the callbacks Walk calls for every node, along with the node's parent,
+// which is nil for the node the walk started at. Either callback may be nil.
+type Visitor struct {
+	// Enter is called before a node's children. Returning false skips them.
+	Enter func(node, parent Node) bool
+	// Leave is called after a node's children, or right after Enter when they were skipped.
+	Leave func(node, parent Node)
+}
+
+// Walk traverses node depth first in source order without changing it, calling the
+// visitor's callbacks for node and every node below it.
+func Walk(node Node, visitor Visitor) {
+	walk(node, nil, visitor)
+}
+
+// Inspect traverses node depth first in source order and calls f for every node.
+// When f returns false the children of that node are skipped.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(node, Visitor{
+		Enter: func(node, parent Node) bool { return f(node) },
+	})
+}
+
+func walk(node, parent Node, visitor Visitor) {
+	enter := true
+	if visitor.Enter != nil {
+		enter = visitor.Enter(node, parent)
+	}
+
+	if enter {
+		for _, child := range Children(node) {
+			walk(child, node, visitor)
+		}
+	}
+
+	if visitor.Leave != nil {
+		visitor.Leave(node, parent)
+	}
+}
+
+// Children returns the nodes directly below node in source order, leaving out
+// optional parts that are missing, such as an if expression without an else branch.
+// The pairs of a hash literal are ordered by their position in the source.
+func Children(node Node) []Node {
+	var c children
+
+	switch node := node.(type) {
+	case *Program:
+		for _, statement := range node.Statements {
+			c.add(statement)
+		}
+
+	case *ExpressionStatement:
+		c.add(node.Expression)
+
+	case *InfixExpression:
+		c.add(node.Left)
+		c.add(node.Right)
+
+	case *PrefixExpression:
+		c.add(node.Right)
+
+	case *IndexExpression:
+		c.add(node.Left)
+		c.add(node.Index)
+
+	case *IfExpression:
+		c.add(node.Condition)
+		c.addBlock(node.Consequence)
+		c.addBlock(node.Alternative)
+
+	case *BlockStatement:
+		for _, statement := range node.Statements {
+			c.add(statement)
+		}
+
+	case *ReturnStatement:
+		c.add(node.ReturnValue)
+
+	case *ThrowStatement:
+		c.add(node.Value)
+
+	case *LetStatement:
+		c.addIdentifier(node.Name)
+		c.add(node.Value)
+
+	case *DestructuringLetStatement:
+		c.add(node.Pattern)
+		c.add(node.Value)
+
+	case *FunctionLiteral:
+		for i, param := range node.Parameters {
+			c.addIdentifier(param)
+			if i < len(node.Defaults) {
+				c.add(node.Defaults[i])
+			}
+		}
+		c.addIdentifier(node.Rest)
+		c.addBlock(node.Body)
+
+	case *CallExpression:
+		c.add(node.Function)
+		for _, arg := range node.Arguments {
+			c.add(arg)
+		}
+
+	case *ArrayLiteral:
+		for _, element := range node.Elements {
+			c.add(element)
+		}
+
+	case *HashLiteral:
+		for _, key := range sortedKeys(node.Pairs) {
+			c.add(key)
+			c.add(node.Pairs[key])
+		}
+
+	case *MacroLiteral:
+		for _, param := range node.Parameters {
+			c.addIdentifier(param)
+		}
+		c.addIdentifier(node.Rest)
+		c.addBlock(node.Body)
+
+	case *MatchExpression:
+		c.add(node.Subject)
+		for _, arm := range node.Arms {
+			if arm != nil {
+				c.add(arm)
+			}
+		}
+
+	case *MatchArm:
+		c.add(node.Pattern)
+		c.add(node.Guard)
+		c.add(node.Body)
+
+	case *ArrayPattern:
+		for _, element := range node.Elements {
+			c.add(element)
+		}
+		c.addIdentifier(node.Rest)
+
+	case *HashPattern:
+		for _, pair := range node.Pairs {
+			c.add(pair.Key)
+			c.add(pair.Value)
+		}
+
+	case *DefaultPattern:
+		c.add(node.Target)
+		c.add(node.Default)
+
+	case *TryExpression:
+		c.addBlock(node.Body)
+		c.addIdentifier(node.CatchParameter)
+		c.addBlock(node.Catch)
+		c.addBlock(node.Finally)
+	}
+
+	return c
+}
+
+// children collects the child nodes of a node. Its add methods take the field types
+// of the nodes, so a nil pointer never ends up in the list as a non-nil Node.
+type children []Node
+
+func (c *children) add(node Node) {
+	if node != nil {
+		*c = append(*c, node)
+	}
+}
+
+func (c *children) addBlock(block *BlockStatement) {
+	if block != nil {
+		*c = append(*c, block)
+	}
+}
+
+func (c *children) addIdentifier(ident *Identifier) {
+	if ident != nil {
+		*c = append(*c, ident)
+	}
+}
+
+// sortedKeys returns the keys of a hash literal in source order. Keys built outside
+// the parser all report 0, 0 and are ordered by how they print.
+func sortedKeys(pairs map[Expression]Expression) []Expression {
+	keys := make([]Expression, 0, len(pairs))
+	for key := range pairs {
+		keys = append(keys, key)
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		iLine, iColumn := Position(keys[i])
+		jLine, jColumn := Position(keys[j])
+		if iLine != jLine {
+			return iLine < jLine
+		}
+		if iColumn != jColumn {
+			return iColumn < jColumn
+		}
+		return keys[i].String() < keys[j].String()
+	})
+
+	return keys
+}
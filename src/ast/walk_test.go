@@ -0,0 +1,192 @@
+package ast
+
+import (
+	"chui/token"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestWalkOrderAndParents(t *testing.T) {
+	x := &Identifier{Token: token.Token{Type: token.IDENT, Literal: "x"}, Value: "x"}
+	one := &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "1"}, Value: 1}
+	sum := &InfixExpression{Token: token.Token{Type: token.PLUS, Literal: "+"}, Left: x, Operator: "+", Right: one}
+	let := &LetStatement{Token: token.Token{Type: token.LET, Literal: "let"}, Name: x, Value: sum}
+	program := &Program{Statements: []Statement{let}}
+
+	events := []string{}
+	Walk(program, Visitor{
+		Enter: func(node, parent Node) bool {
+			events = append(events, fmt.Sprintf("enter %T (parent %T)", node, parent))
+			return true
+		},
+		Leave: func(node, parent Node) {
+			events = append(events, fmt.Sprintf("leave %T", node))
+		},
+	})
+
+	expected := []string{
+		"enter *ast.Program (parent <nil>)",
+		"enter *ast.LetStatement (parent *ast.Program)",
+		"enter *ast.Identifier (parent *ast.LetStatement)",
+		"leave *ast.Identifier",
+		"enter *ast.InfixExpression (parent *ast.LetStatement)",
+		"enter *ast.Identifier (parent *ast.InfixExpression)",
+		"leave *ast.Identifier",
+		"enter *ast.IntegerLiteral (parent *ast.InfixExpression)",
+		"leave *ast.IntegerLiteral",
+		"leave *ast.InfixExpression",
+		"leave *ast.LetStatement",
+		"leave *ast.Program",
+	}
+
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("wrong events.\nwant=%q\ngot=%q", expected, events)
+	}
+}
+
+func TestWalkPrunesSubtrees(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&ExpressionStatement{Expression: &FunctionLiteral{
+				Parameters: []*Identifier{{Value: "a"}},
+				Body: &BlockStatement{Statements: []Statement{
+					&ExpressionStatement{Expression: &Identifier{Value: "inner"}},
+				}},
+			}},
+			&ExpressionStatement{Expression: &Identifier{Value: "outer"}},
+		},
+	}
+
+	entered := []string{}
+	left := 0
+	Walk(program, Visitor{
+		Enter: func(node, parent Node) bool {
+			if ident, ok := node.(*Identifier); ok {
+				entered = append(entered, ident.Value)
+			}
+			_, isFunction := node.(*FunctionLiteral)
+			return !isFunction
+		},
+		Leave: func(node, parent Node) { left++ },
+	})
+
+	if !reflect.DeepEqual(entered, []string{"outer"}) {
+		t.Errorf("pruned function was walked. entered=%q", entered)
+	}
+
+	// Program, both expression statements, the function and the outer identifier
+	if left != 5 {
+		t.Errorf("wrong number of nodes left. want=5, got=%d", left)
+	}
+}
+
+func TestInspectCoversEveryNode(t *testing.T) {
+	program := everyNodeProgram()
+
+	before := program.String()
+
+	seen := map[string]bool{}
+	count := 0
+	Inspect(program, func(node Node) bool {
+		seen[fmt.Sprintf("%T", node)] = true
+		count++
+		return true
+	})
+
+	expected := []string{
+		"*ast.Program", "*ast.LetStatement", "*ast.DestructuringLetStatement", "*ast.ReturnStatement",
+		"*ast.ThrowStatement", "*ast.ExpressionStatement", "*ast.BlockStatement", "*ast.Identifier",
+		"*ast.Boolean", "*ast.IntegerLiteral", "*ast.PrefixExpression", "*ast.InfixExpression",
+		"*ast.IfExpression", "*ast.FunctionLiteral", "*ast.CallExpression", "*ast.StringLiteral",
+		"*ast.ArrayLiteral", "*ast.IndexExpression", "*ast.HashLiteral", "*ast.MacroLiteral",
+		"*ast.MatchExpression", "*ast.MatchArm", "*ast.ArrayPattern", "*ast.HashPattern",
+		"*ast.DefaultPattern", "*ast.TryExpression", "*ast.NullLiteral",
+	}
+	for _, typ := range expected {
+		if !seen[typ] {
+			t.Errorf("Inspect did not visit a %s", typ)
+		}
+	}
+
+	if count != 64 {
+		t.Errorf("wrong number of nodes visited. want=64, got=%d", count)
+	}
+
+	if program.String() != before {
+		t.Errorf("Inspect changed the program. before=%q, after=%q", before, program.String())
+	}
+}
+
+func TestChildrenOrdersHashPairsBySource(t *testing.T) {
+	key := func(name string, column int) *StringLiteral {
+		return &StringLiteral{Token: token.Token{Type: token.STRING, Literal: name, Line: 1, Column: column}, Value: name}
+	}
+
+	hash := &HashLiteral{Pairs: map[Expression]Expression{
+		key("c", 20): &IntegerLiteral{Value: 3},
+		key("a", 2):  &IntegerLiteral{Value: 1},
+		key("b", 11): &IntegerLiteral{Value: 2},
+	}}
+
+	keys := []string{}
+	for _, child := range Children(hash) {
+		if str, ok := child.(*StringLiteral); ok {
+			keys = append(keys, str.Value)
+		}
+	}
+
+	if !reflect.DeepEqual(keys, []string{"a", "b", "c"}) {
+		t.Errorf("keys out of source order. got=%q", keys)
+	}
+}
+
+// everyNodeProgram builds a program, without tokens, that contains every kind of node.
+func everyNodeProgram() *Program {
+	ident := func(name string) *Identifier { return &Identifier{Value: name} }
+	integer := func(value int64) *IntegerLiteral { return &IntegerLiteral{Value: value} }
+	block := func(exp Expression) *BlockStatement {
+		return &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: exp}}}
+	}
+
+	return &Program{Statements: []Statement{
+		&LetStatement{Name: ident("f"), Value: &FunctionLiteral{
+			Parameters: []*Identifier{ident("a")},
+			Defaults:   []Expression{integer(1)},
+			Rest:       ident("rest"),
+			Body: &BlockStatement{Statements: []Statement{
+				&ReturnStatement{ReturnValue: &PrefixExpression{Operator: "-", Right: ident("a")}},
+			}},
+		}},
+		&DestructuringLetStatement{
+			Pattern: &ArrayPattern{
+				Elements: []Expression{
+					&DefaultPattern{Target: ident("b"), Default: integer(2)},
+					&HashPattern{Pairs: []HashPatternPair{{Key: &StringLiteral{Value: "k"}, Value: ident("c")}}},
+				},
+				Rest: ident("others"),
+			},
+			Value: &ArrayLiteral{Elements: []Expression{integer(3), &NullLiteral{}}},
+		},
+		&LetStatement{Name: ident("m"), Value: &MacroLiteral{
+			Parameters: []*Identifier{ident("q")},
+			Body:       block(ident("q")),
+		}},
+		&ThrowStatement{Value: &HashLiteral{Pairs: map[Expression]Expression{&StringLiteral{Value: "k"}: &Boolean{Value: true}}}},
+		&ExpressionStatement{Expression: &TryExpression{
+			Body: block(&CallExpression{Function: ident("f"), Arguments: []Expression{
+				&IndexExpression{Left: ident("x"), Index: &InfixExpression{Left: integer(1), Operator: "+", Right: integer(2)}},
+			}}),
+			CatchParameter: ident("e"),
+			Catch: block(&IfExpression{
+				Condition:   ident("e"),
+				Consequence: block(integer(1)),
+				Alternative: block(&MatchExpression{
+					Subject: ident("e"),
+					Arms:    []*MatchArm{{Pattern: ident("y"), Guard: ident("y"), Body: ident("y")}},
+				}),
+			}),
+			Finally: block(integer(4)),
+		}},
+	}}
+}
@@ -32,6 +32,29 @@ const (
 	OpNull
 	OpGetGlobal
 	OpSetGlobal
+	OpArray
+	OpHash
+	OpIndex
+	OpMatchEqual
+	OpMatchArray
+	OpMatchArrayRest
+	OpMatchHash
+	OpHasKey
+	OpArraySlice
+	OpNoMatch
+	OpCall
+	OpReturnValue
+	OpReturn
+	OpGetLocal
+	OpSetLocal
+	OpGetBuiltin
+	OpClosure
+	OpGetFree
+	OpCurrentClosure
+	OpJumpIfArgPassed
+	OpTry
+	OpThrow
+	OpWide
 )
 
 // Definition represents the definition of an opcode, including its name and the widths of its operands, which is used to determine how many bytes to read to extract the operands.
@@ -60,6 +83,81 @@ var definitions = map[Opcode]*Definition{
 	OpNull:          {"OpNull", []int{}},
 	OpGetGlobal:     {"OpGetGlobal", []int{2}},
 	OpSetGlobal:     {"OpSetGlobal", []int{2}},
+	OpArray:         {"OpArray", []int{2}},
+	OpHash:          {"OpHash", []int{2}},
+	OpIndex:         {"OpIndex", []int{}},
+
+	// Pattern matching. The OpMatch* tests pop their operands and push a
+	// boolean; OpNoMatch pops the unmatched subject and aborts execution.
+	OpMatchEqual:     {"OpMatchEqual", []int{}},
+	OpMatchArray:     {"OpMatchArray", []int{2}},
+	OpMatchArrayRest: {"OpMatchArrayRest", []int{2}},
+	OpMatchHash:      {"OpMatchHash", []int{}},
+	OpHasKey:         {"OpHasKey", []int{}},
+	OpArraySlice:     {"OpArraySlice", []int{2}},
+	OpNoMatch:        {"OpNoMatch", []int{}},
+
+	// Functions. OpCall's operand is the number of arguments on the stack, OpClosure's are
+	// the constant index of the *object.CompiledFunction and the number of free variables.
+	OpCall:           {"OpCall", []int{1}},
+	OpReturnValue:    {"OpReturnValue", []int{}},
+	OpReturn:         {"OpReturn", []int{}},
+	OpGetLocal:       {"OpGetLocal", []int{1}},
+	OpSetLocal:       {"OpSetLocal", []int{1}},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
+	OpClosure:        {"OpClosure", []int{2, 1}},
+	OpGetFree:        {"OpGetFree", []int{1}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+
+	// OpJumpIfArgPassed jumps to its second operand when the caller passed the parameter at the
+	// first operand's index, skipping the code that computes the parameter's default value.
+	OpJumpIfArgPassed: {"OpJumpIfArgPassed", []int{1, 2}},
+
+	// Exceptions. OpTry saves the stack depth in the frame's try slot named by its operand,
+	// the function's handler table says where errors raised afterwards resume. OpThrow pops
+	// a value and raises it as an error.
+	OpTry:   {"OpTry", []int{2}},
+	OpThrow: {"OpThrow", []int{}},
+
+	// OpWide prefixes an instruction whose operands don't fit their widths, the operands of
+	// the instruction after it are twice as wide: 2 bytes instead of 1, 4 instead of 2.
+	OpWide: {"OpWide", []int{}},
+}
+
+// wideDefinitions holds the definitions of instructions prefixed by OpWide.
+var wideDefinitions = map[Opcode]*Definition{}
+
+func init() {
+	for op, def := range definitions {
+		widths := make([]int, len(def.OperandWidths))
+		for i, w := range def.OperandWidths {
+			widths[i] = WideOperandWidth(w)
+		}
+		wideDefinitions[op] = &Definition{Name: def.Name, OperandWidths: widths}
+	}
+}
+
+// WideOperandWidth() returns the width an operand defined width bytes wide has after OpWide.
+// 4 bytes is the widest an operand gets.
+func WideOperandWidth(width int) int {
+	if width >= 4 {
+		return 4
+	}
+	return width * 2
+}
+
+// jumpOperands maps the opcodes that jump to the index of the operand holding the target offset.
+var jumpOperands = map[Opcode]int{
+	OpJump:            0,
+	OpJumpNotTruthy:   0,
+	OpJumpIfArgPassed: 1,
+}
+
+// JumpOperand() returns the index of the operand of op that holds the offset it jumps to,
+// false if op doesn't jump.
+func JumpOperand(op Opcode) (int, bool) {
+	index, ok := jumpOperands[op]
+	return index, ok
 }
 
 // Lookup() retrieves the definition of an opcode.
@@ -72,7 +170,19 @@ func Lookup(op byte) (*Definition, error) {
 	return def, nil
 }
 
-// Make() creates a bytecode instruction from an opcode and its operands.
+// LookupName() returns the opcode with the given name, such as "OpConstant".
+func LookupName(name string) (Opcode, bool) {
+	for op, def := range definitions {
+		if def.Name == name {
+			return op, true
+		}
+	}
+
+	return 0, false
+}
+
+// Make() creates a bytecode instruction from an opcode and its operands. When an operand doesn't fit its width, the
+// instruction is prefixed with OpWide and written with all its operands twice as wide.
 func Make(op Opcode, operands ...int) []byte {
 	def, ok := definitions[op]
 
@@ -80,12 +190,44 @@ func Make(op Opcode, operands ...int) []byte {
 		return []byte{}
 	}
 
-	instructionLen := 1
+	if !operandsFit(def, operands) {
+		return append([]byte{byte(OpWide)}, makeInstruction(op, wideDefinitions[op], operands)...)
+	}
 
-	for _, w := range def.OperandWidths {
-		instructionLen += w
+	return makeInstruction(op, def, operands)
+}
+
+// MakeWide() creates a bytecode instruction prefixed with OpWide, even if its operands would fit their normal widths.
+func MakeWide(op Opcode, operands ...int) []byte {
+	def, ok := wideDefinitions[op]
+
+	if !ok || len(def.OperandWidths) == 0 {
+		return []byte{}
 	}
 
+	return append([]byte{byte(OpWide)}, makeInstruction(op, def, operands)...)
+}
+
+// operandsFit() reports whether every operand fits the width the definition gives it.
+func operandsFit(def *Definition, operands []int) bool {
+	for i, o := range operands {
+		if i < len(def.OperandWidths) && !OperandFits(o, def.OperandWidths[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// OperandFits() reports whether n can be written as an operand width bytes wide.
+func OperandFits(n int, width int) bool {
+	return n >= 0 && n < 1<<(8*width)
+}
+
+// makeInstruction() writes an instruction with its operands as wide as def says.
+func makeInstruction(op Opcode, def *Definition, operands []int) []byte {
+	instructionLen := 1 + OperandsWidth(def)
+
 	instruction := make([]byte, instructionLen)
 	instruction[0] = byte(op)
 	offset := 1
@@ -95,8 +237,14 @@ func Make(op Opcode, operands ...int) []byte {
 
 		switch width {
 
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(o))
+
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+
+		case 1:
+			instruction[offset] = byte(o)
 		}
 
 		offset += width
@@ -106,31 +254,26 @@ func Make(op Opcode, operands ...int) []byte {
 }
 
 // String() returns a string representation of the bytecode instructions, including the offset of each instruction in the bytecode.
+// An undefined opcode is reported and skipped, an instruction cut short ends the listing.
 func (ins Instructions) String() string {
 	var out bytes.Buffer
 
-	i := 0
-
-	for i < len(ins) {
-		def, err := Lookup(ins[i])
+	decoded, errors := decodeInstructions(ins)
 
-		if err != nil {
-			fmt.Fprintf(&out, "ERROR: %s\n", err)
+	for _, in := range decoded {
+		if msg, ok := errors[in.Offset]; ok {
+			fmt.Fprintf(&out, "%04d ERROR: %s\n", in.Offset, msg)
 			continue
 		}
 
-		operands, read := ReadOperands(def, ins[i+1:])
-
-		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
-
-		i += 1 + read
+		fmt.Fprintf(&out, "%04d %s\n", in.Offset, formatInstruction(in))
 	}
 
 	return out.String()
 }
 
-// fmtInstruction() formats an instruction for printing.
-func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+// FormatInstruction() formats an instruction as its opcode name followed by its operands.
+func FormatInstruction(def *Definition, operands []int) string {
 	operandCount := len(def.OperandWidths)
 
 	if len(operands) != operandCount {
@@ -138,16 +281,23 @@ func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
 			len(operands), operandCount)
 	}
 
-	switch operandCount {
+	var out bytes.Buffer
+	out.WriteString(def.Name)
+	for _, o := range operands {
+		fmt.Fprintf(&out, " %d", o)
+	}
 
-	case 0:
-		return def.Name
+	return out.String()
+}
 
-	case 1:
-		return fmt.Sprintf("%s %d", def.Name, operands[0])
+// OperandsWidth() returns the number of bytes the operands of an instruction take up.
+func OperandsWidth(def *Definition) int {
+	width := 0
+	for _, w := range def.OperandWidths {
+		width += w
 	}
 
-	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+	return width
 }
 
 // ReadOperands() reads the operands of an instruction.
@@ -156,19 +306,125 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 	offset := 0
 
 	for i, width := range def.OperandWidths {
-		switch width {
+		operands[i] = ReadOperand(ins[offset:], width)
+		offset += width
+	}
 
-		case 2:
-			operands[i] = int(ReadUint16(ins[offset:]))
+	return operands, offset
+}
+
+// ReadOperand() reads an operand width bytes wide from the start of ins.
+func ReadOperand(ins Instructions, width int) int {
+	switch width {
+	case 4:
+		return int(ReadUint32(ins))
+	case 2:
+		return int(ReadUint16(ins))
+	case 1:
+		return int(ReadUint8(ins))
+	}
+
+	return 0
+}
+
+// ReadInstruction() decodes the instruction at the start of ins, along with the OpWide prefix in front of it if there
+// is one. It returns the opcode, the definition its operands were read with, the operands and the length in bytes.
+// After an error the length is the number of bytes to skip before decoding can go on, all of them for an instruction
+// that is cut short.
+func ReadInstruction(ins Instructions) (Opcode, *Definition, []int, int, error) {
+	prefix := 0
+	definitions := definitions
+
+	if Opcode(ins[0]) == OpWide {
+		if len(ins) == 1 {
+			return OpWide, nil, nil, 1, fmt.Errorf("OpWide is missing the instruction it prefixes")
 		}
 
-		offset += width
+		def, err := Lookup(ins[1])
+		if err != nil {
+			return OpWide, nil, nil, 1, err
+		}
+		if len(def.OperandWidths) == 0 {
+			return OpWide, nil, nil, 1, fmt.Errorf("OpWide prefixes %s, which has no operands", def.Name)
+		}
+
+		prefix = 1
+		definitions = wideDefinitions
 	}
 
-	return operands, offset
+	op := Opcode(ins[prefix])
+	def, ok := definitions[op]
+	if !ok {
+		return op, nil, nil, 1, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	if prefix+1+OperandsWidth(def) > len(ins) {
+		return op, nil, nil, len(ins), fmt.Errorf("%s is missing operand bytes", def.Name)
+	}
+
+	operands, read := ReadOperands(def, ins[prefix+1:])
+	return op, def, operands, prefix + 1 + read, nil
+}
+
+// ReadUint32() reads a uint32 from a byte slice.
+func ReadUint32(ins Instructions) uint32 {
+	return binary.BigEndian.Uint32(ins)
 }
 
 // ReadUint16() reads a uint16 from a byte slice.
 func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
+
+// ReadUint8() reads a uint8 from a byte slice.
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
+
+// Handler is an entry of a function's exception handler table: an error raised by an
+// instruction in [Start, End) resumes execution at Target, with the stack reset to the
+// depth the OpTry for Slot saved.
+type Handler struct {
+	Start  int
+	End    int
+	Target int
+	Slot   int
+}
+
+// FindHandler() returns the innermost handler covering the instruction at offset.
+// Inner handlers always come after the handlers enclosing them in the table.
+func FindHandler(handlers []Handler, offset int) (Handler, bool) {
+	for i := len(handlers) - 1; i >= 0; i-- {
+		h := handlers[i]
+		if h.Start <= offset && offset < h.End {
+			return h, true
+		}
+	}
+
+	return Handler{}, false
+}
+
+// SourcePosition records that the instructions starting at Offset were compiled from
+// the source at Line and Column.
+type SourcePosition struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+// SourceMap maps instruction offsets back to source positions, sorted by offset.
+type SourceMap []SourcePosition
+
+// Lookup() returns the source position of the instruction at offset, 0, 0 if unknown.
+func (sm SourceMap) Lookup(offset int) (int, int) {
+	line, column := 0, 0
+
+	for _, p := range sm {
+		if p.Offset > offset {
+			break
+		}
+		line, column = p.Line, p.Column
+	}
+
+	return line, column
+}
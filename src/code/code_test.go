@@ -21,6 +21,15 @@ func TestMake(t *testing.T) {
 		{OpGreaterThan, []int{}, []byte{byte(OpGreaterThan)}},
 		{OpMinus, []int{}, []byte{byte(OpMinus)}},
 		{OpBang, []int{}, []byte{byte(OpBang)}},
+		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
+		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpJumpIfArgPassed, []int{1, 65534}, []byte{byte(OpJumpIfArgPassed), 1, 255, 254}},
+		{OpTry, []int{2}, []byte{byte(OpTry), 0, 2}},
+		{OpThrow, []int{}, []byte{byte(OpThrow)}},
+		{OpConstant, []int{65536}, []byte{byte(OpWide), byte(OpConstant), 0, 1, 0, 0}},
+		{OpGetLocal, []int{256}, []byte{byte(OpWide), byte(OpGetLocal), 1, 0}},
+		{OpClosure, []int{1, 300}, []byte{byte(OpWide), byte(OpClosure), 0, 0, 0, 1, 1, 44}},
+		{OpJumpIfArgPassed, []int{1, 70000}, []byte{byte(OpWide), byte(OpJumpIfArgPassed), 0, 1, 0, 1, 17, 112}},
 	}
 
 	for _, tt := range tests {
@@ -40,16 +49,37 @@ func TestMake(t *testing.T) {
 	}
 }
 
+func TestMakeWide(t *testing.T) {
+	instruction := MakeWide(OpConstant, 1)
+	expected := []byte{byte(OpWide), byte(OpConstant), 0, 0, 0, 1}
+
+	if string(instruction) != string(expected) {
+		t.Errorf("wrong instruction. want=%v, got=%v", expected, instruction)
+	}
+
+	if instruction := MakeWide(OpPop); len(instruction) != 0 {
+		t.Errorf("made a wide instruction without operands: %v", instruction)
+	}
+}
+
 func TestInstructionsString(t *testing.T) {
 	instructions := []Instructions{
 		Make(OpAdd),
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
+		Make(OpGetLocal, 1),
+		Make(OpClosure, 65535, 255),
+		Make(OpConstant, 65536),
+		MakeWide(OpGetLocal, 1),
 	}
 
 	expected := `0000 OpAdd
 0001 OpConstant 2
 0004 OpConstant 65535
+0007 OpGetLocal 1
+0009 OpClosure 65535 255
+0013 OpWide OpConstant 65536
+0019 OpWide OpGetLocal 1
 `
 	concatted := Instructions{}
 
@@ -63,6 +93,80 @@ func TestInstructionsString(t *testing.T) {
 	}
 }
 
+func TestInstructionsStringErrors(t *testing.T) {
+	tests := []struct {
+		instructions Instructions
+		expected     string
+	}{
+		{
+			Instructions{255, byte(OpAdd)},
+			"0000 ERROR: opcode 255 undefined\n0001 OpAdd\n",
+		},
+		{
+			append(Make(OpPop), byte(OpConstant), 1),
+			"0000 OpPop\n0001 ERROR: OpConstant is missing operand bytes\n",
+		},
+		{
+			Make(OpJumpIfArgPassed, 2, 300),
+			"0000 OpJumpIfArgPassed 2 300\n",
+		},
+		{
+			Instructions{byte(OpWide), byte(OpPop), byte(OpWide), 255},
+			"0000 ERROR: OpWide prefixes OpPop, which has no operands\n0001 OpPop\n" +
+				"0002 ERROR: opcode 255 undefined\n0003 ERROR: opcode 255 undefined\n",
+		},
+		{
+			append(Make(OpPop), byte(OpWide), byte(OpConstant), 0, 1),
+			"0000 OpPop\n0001 ERROR: OpConstant is missing operand bytes\n",
+		},
+		{
+			Instructions{byte(OpWide)},
+			"0000 ERROR: OpWide is missing the instruction it prefixes\n",
+		},
+	}
+
+	for _, tt := range tests {
+		if tt.instructions.String() != tt.expected {
+			t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q",
+				tt.expected, tt.instructions.String())
+		}
+	}
+}
+
+func TestJumpOperand(t *testing.T) {
+	tests := []struct {
+		op            Opcode
+		expectedIndex int
+		expectedOk    bool
+	}{
+		{OpJump, 0, true},
+		{OpJumpNotTruthy, 0, true},
+		{OpJumpIfArgPassed, 1, true},
+		{OpConstant, 0, false},
+	}
+
+	for _, tt := range tests {
+		index, ok := JumpOperand(tt.op)
+		if index != tt.expectedIndex || ok != tt.expectedOk {
+			t.Errorf("wrong jump operand for %d. want=%d, %t, got=%d, %t",
+				tt.op, tt.expectedIndex, tt.expectedOk, index, ok)
+		}
+	}
+}
+
+func TestLookupName(t *testing.T) {
+	for op, def := range definitions {
+		found, ok := LookupName(def.Name)
+		if !ok || found != op {
+			t.Errorf("wrong opcode for %s. want=%d, got=%d (found=%t)", def.Name, op, found, ok)
+		}
+	}
+
+	if _, ok := LookupName("OpNothing"); ok {
+		t.Errorf("found an opcode for OpNothing")
+	}
+}
+
 func TestReadOperands(t *testing.T) {
 	tests := []struct {
 		op        Opcode
@@ -70,7 +174,10 @@ func TestReadOperands(t *testing.T) {
 		bytesRead int
 	}{
 		{OpConstant, []int{65535}, 2},
+		{OpGetLocal, []int{255}, 1},
+		{OpClosure, []int{65535, 255}, 3},
 	}
+
 	for _, tt := range tests {
 		instruction := Make(tt.op, tt.operands...)
 
@@ -93,3 +200,96 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestFindHandler(t *testing.T) {
+	handlers := []Handler{
+		{Start: 3, End: 20, Target: 30, Slot: 0},
+		{Start: 6, End: 10, Target: 40, Slot: 1},
+	}
+
+	tests := []struct {
+		offset         int
+		expectedTarget int
+		expectedFound  bool
+	}{
+		{0, 0, false},
+		{3, 30, true},
+		{6, 40, true},
+		{9, 40, true},
+		{10, 30, true},
+		{20, 0, false},
+	}
+
+	for _, tt := range tests {
+		h, ok := FindHandler(handlers, tt.offset)
+		if ok != tt.expectedFound {
+			t.Errorf("offset %d: wrong found. want=%t, got=%t", tt.offset, tt.expectedFound, ok)
+			continue
+		}
+
+		if ok && h.Target != tt.expectedTarget {
+			t.Errorf("offset %d: wrong handler. want target=%d, got=%d", tt.offset, tt.expectedTarget, h.Target)
+		}
+	}
+}
+
+func TestSourceMapLookup(t *testing.T) {
+	sm := SourceMap{
+		{Offset: 0, Line: 1, Column: 1},
+		{Offset: 4, Line: 1, Column: 9},
+		{Offset: 9, Line: 3, Column: 2},
+	}
+
+	tests := []struct {
+		offset         int
+		expectedLine   int
+		expectedColumn int
+	}{
+		{0, 1, 1},
+		{3, 1, 1},
+		{4, 1, 9},
+		{8, 1, 9},
+		{100, 3, 2},
+	}
+
+	for _, tt := range tests {
+		line, column := sm.Lookup(tt.offset)
+		if line != tt.expectedLine || column != tt.expectedColumn {
+			t.Errorf("offset %d: wrong position. want=%d:%d, got=%d:%d",
+				tt.offset, tt.expectedLine, tt.expectedColumn, line, column)
+		}
+	}
+}
+
+func TestReadInstruction(t *testing.T) {
+	tests := []struct {
+		instruction Instructions
+		op          Opcode
+		operands    []int
+		length      int
+	}{
+		{Make(OpConstant, 65535), OpConstant, []int{65535}, 3},
+		{Make(OpConstant, 65536), OpConstant, []int{65536}, 6},
+		{Make(OpClosure, 70000, 2), OpClosure, []int{70000, 2}, 8},
+		{MakeWide(OpCall, 3), OpCall, []int{3}, 4},
+		{Make(OpPop), OpPop, []int{}, 1},
+	}
+
+	for _, tt := range tests {
+		op, _, operands, length, err := ReadInstruction(tt.instruction)
+		if err != nil {
+			t.Fatalf("unexpected error reading %v: %s", tt.instruction, err)
+		}
+
+		if op != tt.op || length != tt.length {
+			t.Errorf("wrong instruction read from %v. want=%d (%d bytes), got=%d (%d bytes)",
+				tt.instruction, tt.op, tt.length, op, length)
+		}
+
+		for i, want := range tt.operands {
+			if operands[i] != want {
+				t.Errorf("operand wrong. want=%d, got=%d", want, operands[i])
+			}
+		}
+	}
+}
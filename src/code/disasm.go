@@ -0,0 +1,130 @@
+package code
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Disassemble() lists ins one instruction per line like String, but names the offsets that
+// jumps and handlers refer to with labels (L0, L1, ...) placed before the instructions they
+// mark, and lists the handlers after the instructions as `.handler start end target slot`.
+// annotate, unless nil, returns a comment for an instruction, such as the value of the
+// constant it loads, which is added after a semicolon.
+func Disassemble(ins Instructions, handlers []Handler, annotate func(op Opcode, operands []int) string) string {
+	decoded, errors := decodeInstructions(ins)
+	labels := makeLabels(ins, decoded, errors, handlers)
+
+	var out bytes.Buffer
+
+	for _, in := range decoded {
+		if label, ok := labels[in.Offset]; ok {
+			fmt.Fprintf(&out, "%s:\n", label)
+		}
+		if msg, ok := errors[in.Offset]; ok {
+			fmt.Fprintf(&out, "    %04d ERROR: %s\n", in.Offset, msg)
+			continue
+		}
+
+		text := []string{definitions[in.Op].Name}
+		if in.Wide {
+			text = []string{definitions[OpWide].Name, definitions[in.Op].Name}
+		}
+		comments := []string{}
+
+		jumpIndex, jumps := JumpOperand(in.Op)
+		for i, operand := range in.Operands {
+			label, ok := labels[operand]
+			switch {
+			case jumps && i == jumpIndex && ok:
+				text = append(text, label)
+			case jumps && i == jumpIndex:
+				text = append(text, fmt.Sprint(operand))
+				comments = append(comments, "jump target is not an instruction")
+			default:
+				text = append(text, fmt.Sprint(operand))
+			}
+		}
+
+		if annotate != nil {
+			if comment := annotate(in.Op, in.Operands); comment != "" {
+				comments = append(comments, comment)
+			}
+		}
+
+		line := strings.Join(text, " ")
+		if len(comments) > 0 {
+			line = fmt.Sprintf("%-24s ; %s", line, strings.Join(comments, "; "))
+		}
+		fmt.Fprintf(&out, "    %04d %s\n", in.Offset, line)
+	}
+
+	if label, ok := labels[len(ins)]; ok {
+		fmt.Fprintf(&out, "%s:\n", label)
+	}
+
+	for _, h := range handlers {
+		offsets := []string{}
+		for _, offset := range []int{h.Start, h.End, h.Target} {
+			if label, ok := labels[offset]; ok {
+				offsets = append(offsets, label)
+			} else {
+				offsets = append(offsets, fmt.Sprint(offset))
+			}
+		}
+		fmt.Fprintf(&out, "    .handler %s %d\n", strings.Join(offsets, " "), h.Slot)
+	}
+
+	return out.String()
+}
+
+// formatInstruction() returns a decoded instruction as its opcode name, after OpWide if it has the prefix, followed by
+// its operands.
+func formatInstruction(in Instruction) string {
+	text := FormatInstruction(definitions[in.Op], in.Operands)
+	if in.Wide {
+		return definitions[OpWide].Name + " " + text
+	}
+	return text
+}
+
+// makeLabels() names the offsets jumps and handlers refer to, in the order they appear in
+// ins. Only offsets where an instruction starts, or the end of ins, get a label.
+func makeLabels(ins Instructions, decoded []Instruction, errors map[int]string, handlers []Handler) map[int]string {
+	valid := map[int]bool{len(ins): true}
+	for _, in := range decoded {
+		if _, ok := errors[in.Offset]; !ok {
+			valid[in.Offset] = true
+		}
+	}
+
+	targets := []int{}
+	addTarget := func(offset int) {
+		if valid[offset] {
+			targets = append(targets, offset)
+		}
+	}
+
+	for _, in := range decoded {
+		if jumpIndex, ok := JumpOperand(in.Op); ok && valid[in.Offset] {
+			addTarget(in.Operands[jumpIndex])
+		}
+	}
+	for _, h := range handlers {
+		addTarget(h.Start)
+		addTarget(h.End)
+		addTarget(h.Target)
+	}
+
+	sort.Ints(targets)
+
+	labels := map[int]string{}
+	for _, offset := range targets {
+		if _, ok := labels[offset]; !ok {
+			labels[offset] = fmt.Sprintf("L%d", len(labels))
+		}
+	}
+
+	return labels
+}
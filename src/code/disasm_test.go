@@ -0,0 +1,70 @@
+package code
+
+import "testing"
+
+func TestDisassemble(t *testing.T) {
+	ins := concat(
+		Make(OpTry, 0),                 // 0000
+		Make(OpConstant, 0),            // 0003
+		Make(OpJumpNotTruthy, 13),      // 0006
+		Make(OpConstant, 1),            // 0009
+		Make(OpPop),                    // 0012
+		Make(OpJump, 17),               // 0013
+		Make(OpThrow),                  // 0016
+		Make(OpJumpIfArgPassed, 0, 17), // 0017
+	)
+	handlers := []Handler{{Start: 3, End: 16, Target: 16, Slot: 0}}
+
+	annotate := func(op Opcode, operands []int) string {
+		if op == OpConstant {
+			return "constant"
+		}
+		return ""
+	}
+
+	expected := `    0000 OpTry 0
+L0:
+    0003 OpConstant 0             ; constant
+    0006 OpJumpNotTruthy L1
+    0009 OpConstant 1             ; constant
+    0012 OpPop
+L1:
+    0013 OpJump L3
+L2:
+    0016 OpThrow
+L3:
+    0017 OpJumpIfArgPassed 0 L3
+    .handler L0 L2 L2 0
+`
+
+	if actual := Disassemble(ins, handlers, annotate); actual != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+}
+
+func TestDisassembleBadInstructions(t *testing.T) {
+	ins := concat(
+		Make(OpJump, 2),
+		Instructions{255},
+		Make(OpJump, 8),
+		Instructions{byte(OpConstant), 0},
+	)
+
+	expected := `    0000 OpJump 2                 ; jump target is not an instruction
+    0003 ERROR: opcode 255 undefined
+    0004 OpJump 8                 ; jump target is not an instruction
+    0007 ERROR: OpConstant is missing operand bytes
+`
+
+	if actual := Disassemble(ins, nil, nil); actual != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+}
+
+func concat(instructions ...Instructions) Instructions {
+	out := Instructions{}
+	for _, ins := range instructions {
+		out = append(out, ins...)
+	}
+	return out
+}
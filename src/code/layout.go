@@ -0,0 +1,161 @@
+package code
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Instruction is a decoded instruction that can be changed, moved or dropped before the
+// instructions are laid out again with EncodeInstructions.
+type Instruction struct {
+	Offset   int // where the instruction starts in the instructions it was decoded from
+	Op       Opcode
+	Operands []int
+	Wide     bool // prefixed by OpWide where it was decoded from, EncodeInstructions picks the width again
+}
+
+// DecodeInstructions() splits ins into instructions, failing on bytes that aren't one.
+func DecodeInstructions(ins Instructions) ([]Instruction, error) {
+	decoded, errors := decodeInstructions(ins)
+
+	for _, in := range decoded {
+		if msg, ok := errors[in.Offset]; ok {
+			return nil, fmt.Errorf("%04d: %s", in.Offset, msg)
+		}
+	}
+
+	return decoded, nil
+}
+
+// decodeInstructions() splits ins into instructions. Bytes that don't start a valid instruction are returned
+// as entries holding the byte as their opcode and no operands, with an error message each, so listings can
+// report them and go on.
+func decodeInstructions(ins Instructions) ([]Instruction, map[int]string) {
+	decoded := []Instruction{}
+	errors := map[int]string{}
+
+	for i := 0; i < len(ins); {
+		op, _, operands, read, err := ReadInstruction(ins[i:])
+		if err != nil {
+			decoded = append(decoded, Instruction{Offset: i, Op: Opcode(ins[i])})
+			errors[i] = err.Error()
+			i += read
+			continue
+		}
+
+		decoded = append(decoded, Instruction{Offset: i, Op: op, Operands: operands, Wide: Opcode(ins[i]) == OpWide})
+		i += read
+	}
+
+	return decoded, errors
+}
+
+// Relocation maps offsets in the instructions a list was decoded from to offsets in the
+// instructions EncodeInstructions laid it out as.
+type Relocation struct {
+	from []int // the original offsets of the instructions laid out, in order
+	to   []int // their new offsets
+	end  int   // the length of the new instructions
+}
+
+// Offset() returns where the instruction that was at offset ended up. An offset whose
+// instruction was dropped moves to the instruction after it, or to the end.
+func (r Relocation) Offset(offset int) int {
+	i := sort.SearchInts(r.from, offset)
+	if i == len(r.from) {
+		return r.end
+	}
+	return r.to[i]
+}
+
+// Handlers() returns the handler table with its offsets relocated.
+func (r Relocation) Handlers(handlers []Handler) []Handler {
+	var relocated []Handler
+	for _, h := range handlers {
+		relocated = append(relocated, Handler{
+			Start:  r.Offset(h.Start),
+			End:    r.Offset(h.End),
+			Target: r.Offset(h.Target),
+			Slot:   h.Slot,
+		})
+	}
+
+	return relocated
+}
+
+// SourceMap() returns the source map with its offsets relocated. When several positions
+// land on the same instruction, the last one is kept, as Lookup would have used it.
+func (r Relocation) SourceMap(sourceMap SourceMap) SourceMap {
+	var relocated SourceMap
+	for _, p := range sourceMap {
+		p.Offset = r.Offset(p.Offset)
+		if n := len(relocated); n > 0 && relocated[n-1].Offset == p.Offset {
+			relocated = relocated[:n-1]
+		}
+		relocated = append(relocated, p)
+	}
+
+	return relocated
+}
+
+// EncodeInstructions() lays out instructions from DecodeInstructions, after they were
+// changed, in the narrowest form each fits: an instruction only gets the OpWide prefix
+// when one of its operands needs it. Jump operands hold offsets in the original
+// instructions and are relocated, the instructions must stay in their original order.
+func EncodeInstructions(instructions []Instruction) (Instructions, Relocation) {
+	// Jump operands are left to the layout below, where they may turn out to fit once relocated
+	wide := make([]bool, len(instructions))
+	for i, in := range instructions {
+		operands := in.Operands
+		if jumpIndex, ok := JumpOperand(in.Op); ok {
+			operands = append([]int{}, operands...)
+			operands[jumpIndex] = 0
+		}
+		wide[i] = !operandsFit(definitions[in.Op], operands)
+	}
+
+	r := Relocation{from: make([]int, len(instructions)), to: make([]int, len(instructions))}
+	for i, in := range instructions {
+		r.from[i] = in.Offset
+	}
+
+	// Widening a jump moves the instructions after it, which can push other jump targets
+	// out of range, so the layout is repeated until no more jumps need widening
+	for {
+		r.end = 0
+		for i, in := range instructions {
+			r.to[i] = r.end
+
+			def := definitions[in.Op]
+			if wide[i] {
+				def = wideDefinitions[in.Op]
+				r.end++
+			}
+			r.end += 1 + OperandsWidth(def)
+		}
+
+		widened := false
+		for i, in := range instructions {
+			jumpIndex, ok := JumpOperand(in.Op)
+			if ok && !wide[i] && !OperandFits(r.Offset(in.Operands[jumpIndex]), definitions[in.Op].OperandWidths[jumpIndex]) {
+				wide[i] = true
+				widened = true
+			}
+		}
+
+		if !widened {
+			break
+		}
+	}
+
+	ins := make(Instructions, 0, r.end)
+	for _, in := range instructions {
+		operands := append([]int{}, in.Operands...)
+		if jumpIndex, ok := JumpOperand(in.Op); ok {
+			operands[jumpIndex] = r.Offset(operands[jumpIndex])
+		}
+		ins = append(ins, Make(in.Op, operands...)...)
+	}
+
+	return ins, r
+}
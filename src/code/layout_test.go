@@ -0,0 +1,113 @@
+package code
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncodeInstructionsRelocates(t *testing.T) {
+	ins := concat(
+		Make(OpTry, 0),            // 0000
+		Make(OpTrue),              // 0003
+		Make(OpJumpNotTruthy, 11), // 0004
+		Make(OpNull),              // 0007
+		Make(OpPop),               // 0008
+		Make(OpJump, 12),          // 0009, dropped
+		Make(OpNull),              // 0012
+		Make(OpPop),               // 0013
+	)
+
+	instructions, err := DecodeInstructions(ins)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Drop the OpJump, the jump into its middle has to be fixed by hand
+	instructions = append(instructions[:5], instructions[6:]...)
+	instructions[2].Operands[0] = 12
+
+	encoded, relocation := EncodeInstructions(instructions)
+
+	expected := concat(
+		Make(OpTry, 0),
+		Make(OpTrue),
+		Make(OpJumpNotTruthy, 9),
+		Make(OpNull),
+		Make(OpPop),
+		Make(OpNull),
+		Make(OpPop),
+	)
+	if !reflect.DeepEqual(encoded, expected) {
+		t.Fatalf("wrong instructions.\nwant=\n%s\ngot=\n%s", expected, encoded)
+	}
+
+	handlers := relocation.Handlers([]Handler{{Start: 3, End: 9, Target: 14, Slot: 0}})
+	if want := []Handler{{Start: 3, End: 9, Target: 11, Slot: 0}}; !reflect.DeepEqual(handlers, want) {
+		t.Errorf("wrong handlers. want=%v, got=%v", want, handlers)
+	}
+
+	sourceMap := relocation.SourceMap(SourceMap{{0, 1, 1}, {9, 2, 1}, {12, 3, 1}, {13, 3, 5}})
+	if want := (SourceMap{{0, 1, 1}, {9, 3, 1}, {10, 3, 5}}); !reflect.DeepEqual(sourceMap, want) {
+		t.Errorf("wrong source map. want=%v, got=%v", want, sourceMap)
+	}
+}
+
+func TestEncodeInstructionsWidensFarJumps(t *testing.T) {
+	// The first jump needs OpWide, which moves the target of the second one out of its reach
+	ins := concat(
+		Make(OpJumpNotTruthy, 0), // 0000, to the end
+		Make(OpJump, 65533),      // 0003
+	)
+	for len(ins) < 65533 {
+		ins = append(ins, byte(OpPop))
+	}
+	ins = append(ins, Make(OpNull)...) // 65533
+	for len(ins) < 70000 {
+		ins = append(ins, byte(OpPop))
+	}
+
+	instructions, err := DecodeInstructions(ins)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	instructions[0].Operands[0] = len(ins)
+
+	encoded, relocation := EncodeInstructions(instructions)
+
+	if len(encoded) != len(ins)+6 {
+		t.Fatalf("wrong length. want=%d, got=%d", len(ins)+6, len(encoded))
+	}
+	if target := relocation.Offset(65533); target != 65539 {
+		t.Fatalf("wrong relocated offset. want=65539, got=%d", target)
+	}
+
+	expected := concat(Make(OpJumpNotTruthy, len(encoded)), Make(OpJump, 65539))
+	if !reflect.DeepEqual(encoded[:len(expected)], expected) {
+		t.Errorf("wrong jumps.\nwant=\n%s\ngot=\n%s", expected, encoded[:len(expected)])
+	}
+	if Opcode(encoded[65539]) != OpNull {
+		t.Errorf("jump target is %d, not OpNull", encoded[65539])
+	}
+}
+
+func TestEncodeInstructionsNarrowsNearJumps(t *testing.T) {
+	// A jump that was far in the original instructions is laid out narrow once what it jumped over is dropped
+	instructions := []Instruction{
+		{Offset: 0, Op: OpJump, Operands: []int{70000}},
+		{Offset: 70000, Op: OpNull},
+	}
+
+	encoded, _ := EncodeInstructions(instructions)
+
+	expected := concat(Make(OpJump, 3), Make(OpNull))
+	if !reflect.DeepEqual(encoded, expected) {
+		t.Errorf("wrong instructions.\nwant=\n%s\ngot=\n%s", expected, encoded)
+	}
+}
+
+func TestDecodeInstructionsErrors(t *testing.T) {
+	_, err := DecodeInstructions(concat(Make(OpPop), Instructions{byte(OpWide), byte(OpAdd)}))
+	if err == nil || err.Error() != "0001: OpWide prefixes OpAdd, which has no operands" {
+		t.Errorf("wrong error: %v", err)
+	}
+}
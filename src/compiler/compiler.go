@@ -6,27 +6,83 @@ package compiler
 import (
 	"chui/ast"
 	"chui/code"
+	"chui/ir"
 	"chui/object"
 	"fmt"
+	"sort"
 )
 
-// Compiler is the compiler struct - it holds the bytecode instructions and the constant pool.
+// maxArguments is the most arguments a call can pass, OpCall's operand is a single byte, two after OpWide.
+const maxArguments = 1<<16 - 1
+
+// Compiler is the compiler struct - it holds the constant pool and a stack of compilation scopes, one per function being compiled.
 type Compiler struct {
-	instructions        code.Instructions
-	constants           []object.Object
-	lastInstruction     EmittedInstruction
-	previousInstruction EmittedInstruction
-	symbolTable         *SymbolTable
+	constants   []object.Object
+	symbolTable *SymbolTable
+
+	// interned maps the key of each interned constant, see constantKey(), to its index in the pool
+	interned map[interface{}]int
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	// line and column of the node being compiled, recorded in the source map of emitted instructions
+	line   int
+	column int
+
+	optimizations Optimizations
+	warnings      []Warning
+
+	// bytecode is the laid out program, set by the first call to Bytecode(), which ends compilation
+	bytecode *Bytecode
+}
+
+// Optimizations selects the optimization passes the compiler runs. New turns them all on,
+// turning them off helps when debugging the compiler or reading its output.
+type Optimizations struct {
+	Fold     bool // evaluate constant expressions at compile time, see fold()
+	Peephole bool // rewrite short instruction sequences into shorter ones, see peephole()
+	DeadCode bool // drop code that can't run or whose value is unused, with warnings, see eliminateDeadCode()
+}
+
+// CompilationScope holds the code of a single function body, the main program being the outermost one. The compiler
+// emits into the blocks of a control flow graph, with explicit edges for jumps, and finishScope lays them out as
+// instructions once they are complete.
+type CompilationScope struct {
+	fn      *ir.Function // the blocks placed so far, in layout order, with the handlers
+	current *ir.Block    // the block being emitted into, nil after a jump until the next one is placed
+
+	numTrySlots int
+	tries       []*tryContext // the try expressions being compiled, innermost last
+
+	// the code laid out by finishScope
+	instructions code.Instructions
+	handlers     []code.Handler
+	sourceMap    code.SourceMap
+}
+
+// newCompilationScope() returns a scope whose first block is placed and ready to be emitted into.
+func newCompilationScope() CompilationScope {
+	entry := &ir.Block{}
+	return CompilationScope{fn: &ir.Function{Blocks: []*ir.Block{entry}}, current: entry}
 }
 
 // New() - Create a pointer to a new compiler instance and returns a reference/address where Compiler is stored in memory.
 func New() *Compiler {
+	mainScope := newCompilationScope()
+
+	symbolTable := NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
 	return &Compiler{
-		instructions:        code.Instructions{},
-		constants:           []object.Object{},
-		lastInstruction:     EmittedInstruction{},
-		previousInstruction: EmittedInstruction{},
-		symbolTable:         NewSymbolTable(),
+		constants:     []object.Object{},
+		interned:      map[interface{}]int{},
+		symbolTable:   symbolTable,
+		scopes:        []CompilationScope{mainScope},
+		scopeIndex:    0,
+		optimizations: Optimizations{Fold: true, Peephole: true, DeadCode: true},
 	}
 }
 
@@ -34,16 +90,50 @@ func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 	compiler := New()
 	compiler.symbolTable = s
 	compiler.constants = constants
+	for i, constant := range constants {
+		if key, ok := constantKey(constant); ok {
+			compiler.interned[key] = i
+		}
+	}
 
 	return compiler
 }
 
+// SetOptimizations() replaces the optimization passes the compiler runs.
+func (c *Compiler) SetOptimizations(optimizations Optimizations) {
+	c.optimizations = optimizations
+}
+
+// Warnings() returns the warnings about dead code in the programs compiled so far.
+func (c *Compiler) Warnings() []Warning {
+	return c.warnings
+}
+
 // Compile() - A struct that holds the state of the compiler including generated instructions, alist of constants and the last two instructions emitted.
 // It compiles an AST node into bytecode instructions.
 func (c *Compiler) Compile(node ast.Node) error {
+	if c.bytecode != nil {
+		return fmt.Errorf("cannot compile after the bytecode has been laid out")
+	}
+
+	if line, column := ast.Position(node); line > 0 {
+		outerLine, outerColumn := c.line, c.column
+		c.line, c.column = line, column
+		defer func() { c.line, c.column = outerLine, outerColumn }()
+	}
+
 	switch node := node.(type) {
 
 	case *ast.Program:
+		if c.optimizations.DeadCode {
+			var warnings []Warning
+			node, warnings = eliminateDeadCode(node)
+			c.warnings = append(c.warnings, warnings...)
+		}
+		if c.optimizations.Fold {
+			node = fold(node)
+		}
+
 		for _, s := range node.Statements {
 			err := c.Compile(s)
 
@@ -151,44 +241,36 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 	case *ast.IfExpression:
+		if truthy, ok := constantTruthiness(node.Condition); ok && (c.optimizations.Fold || c.optimizations.DeadCode) {
+			return c.compileConstantIf(node, truthy)
+		}
+
 		err := c.Compile(node.Condition)
 		if err != nil {
 			return err
 		}
 
-		// Emit an `OpJumpNotTruthy` with a bogus value
-		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+		alternative, end := c.newBlock(), c.newBlock()
+		c.branch(alternative, code.OpJumpNotTruthy)
 
-		err = c.Compile(node.Consequence)
+		err = c.compileBlockValue(node.Consequence)
 		if err != nil {
 			return err
 		}
 
-		if c.lastInstructionIsPop() {
-			c.removeLastPop()
-		}
-
-		// Emit an `OpJump` with a bogus value
-		jumpPos := c.emit(code.OpJump, 9999)
-
-		afterConsequencePos := len(c.instructions)
-		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
+		c.jump(end)
+		c.placeBlock(alternative)
 
 		if node.Alternative == nil {
 			c.emit(code.OpNull)
 		} else {
-			err := c.Compile(node.Alternative)
+			err := c.compileBlockValue(node.Alternative)
 			if err != nil {
 				return err
 			}
-
-			if c.lastInstructionIsPop() {
-				c.removeLastPop()
-			}
 		}
 
-		afterAlternativePos := len(c.instructions)
-		c.changeOperand(jumpPos, afterAlternativePos)
+		c.placeBlock(end)
 
 	case *ast.LetStatement:
 		err := c.Compile(node.Value)
@@ -197,7 +279,10 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 		symbol := c.symbolTable.Define(node.Name.Value)
-		c.emit(code.OpSetGlobal, symbol.Index)
+		c.storeSymbol(symbol)
+
+	case *ast.DestructuringLetStatement:
+		return c.compileDestructuringLet(node)
 
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
@@ -205,88 +290,366 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return fmt.Errorf("undefined variable %s", node.Value)
 		}
 
-		c.emit(code.OpGetGlobal, symbol.Index)
+		c.loadSymbol(symbol)
+
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+
+	case *ast.NullLiteral:
+		c.emit(code.OpNull)
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			err := c.Compile(el)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		keys := []ast.Expression{}
+		for k := range node.Pairs {
+			keys = append(keys, k)
+		}
+
+		// Go map iteration order is random, sort the keys so the emitted instructions are stable.
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+
+		for _, k := range keys {
+			err := c.Compile(k)
+			if err != nil {
+				return err
+			}
+			err = c.Compile(node.Pairs[k])
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpHash, len(node.Pairs)*2)
+
+	case *ast.IndexExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+
+		err = c.Compile(node.Index)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpIndex)
+
+	case *ast.MatchExpression:
+		return c.compileMatchExpression(node)
+
+	case *ast.FunctionLiteral:
+		return c.compileFunctionLiteral(node)
+
+	case *ast.MacroLiteral:
+		// Macros only exist at expansion time, evaluator.DefineMacros removes their definitions from the program
+		return fmt.Errorf("cannot compile macro literal %s: macros are defined by let statements and expanded before compilation",
+			node.String())
+
+	case *ast.ReturnStatement:
+		err := c.Compile(node.ReturnValue)
+		if err != nil {
+			return err
+		}
+
+		closed, err := c.exitTries()
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpReturnValue)
+		c.reopenGuards(closed)
+
+	case *ast.ThrowStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpThrow)
+
+	case *ast.TryExpression:
+		return c.compileTryExpression(node)
+
+	case *ast.CallExpression:
+		err := c.Compile(node.Function)
+		if err != nil {
+			return err
+		}
+
+		if len(node.Arguments) > maxArguments {
+			return fmt.Errorf("too many arguments in call to %s: %d, the limit is %d",
+				node.Function.String(), len(node.Arguments), maxArguments)
+		}
+
+		for _, a := range node.Arguments {
+			err := c.Compile(a)
+			if err != nil {
+				return err
+			}
+		}
+
+		c.emit(code.OpCall, len(node.Arguments))
 	}
 
 	return nil
 }
 
-// addConstant() adds a constant to the constant pool and returns its index.
+// loadSymbol() emits the instruction that pushes the value bound to a symbol.
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+// compileConstantIf() compiles an if whose condition is a constant, written so or computed by fold(): only the branch
+// that is taken, without jumps.
+func (c *Compiler) compileConstantIf(node *ast.IfExpression, truthy bool) error {
+	taken := node.Alternative
+	if truthy {
+		taken = node.Consequence
+	}
+
+	if taken == nil {
+		c.emit(code.OpNull)
+		return nil
+	}
+
+	return c.compileBlockValue(taken)
+}
+
+// storeSymbol() emits the instruction that pops the top of the stack into a symbol.
+// Only globals and locals are ever defined by the compiler, so those are the only scopes that can be stored to.
+func (c *Compiler) storeSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+// addConstant() adds a constant to the constant pool and returns its index. Integers and strings are interned by
+// value and functions by identity, so a constant already in the pool gets the index it has there.
 func (c *Compiler) addConstant(obj object.Object) int {
+	key, ok := constantKey(obj)
+	if index, found := c.interned[key]; ok && found {
+		return index
+	}
+
 	c.constants = append(c.constants, obj)
+	index := len(c.constants) - 1
+	if ok {
+		c.interned[key] = index
+	}
+
+	return index
+}
 
-	return len(c.constants) - 1
+// integerConstant and stringConstant are the keys integers and strings are interned by,
+// distinct types so the integer 1 and the string "1" get different keys.
+type integerConstant int64
+type stringConstant string
+
+// constantKey() returns the key a constant is interned by, false for constants that aren't interned.
+func constantKey(obj object.Object) (interface{}, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return integerConstant(obj.Value), true
+	case *object.String:
+		return stringConstant(obj.Value), true
+	case *object.CompiledFunction:
+		return obj, true
+	}
+	return nil, false
 }
 
-// emit() appends an instruction to the bytecode.
-func (c *Compiler) emit(op code.Opcode, operands ...int) int {
-	ins := code.Make(op, operands...)
-	pos := c.addInstruction(ins)
+// emit() appends an instruction to the block being emitted into, at the source position of the node being compiled.
+// After a jump, a return or a throw the instruction can't run, it starts a new block.
+func (c *Compiler) emit(op code.Opcode, operands ...int) {
+	scope := &c.scopes[c.scopeIndex]
+	if scope.current == nil || scope.current.Terminated() {
+		c.placeBlock(c.newBlock())
+	}
 
-	c.setLastInstruction(op, pos)
+	scope.current.Instructions = append(scope.current.Instructions,
+		ir.Instruction{Op: op, Operands: operands, Line: c.line, Column: c.column})
+}
 
-	return pos
+// newBlock() returns a block for code to be emitted into once placeBlock lays it out. Jumps can go to it before.
+func (c *Compiler) newBlock() *ir.Block {
+	return &ir.Block{}
 }
 
-// setLastInstruction() sets the last instruction emitted by the compiler.
-func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
-	previous := c.lastInstruction
-	last := EmittedInstruction{Opcode: op, Position: pos}
+// placeBlock() lays b out after the blocks placed so far and emits into it from now on. The block being emitted into
+// goes on to b, unless it ends with a jump, a return or a throw.
+func (c *Compiler) placeBlock(b *ir.Block) *ir.Block {
+	scope := &c.scopes[c.scopeIndex]
+	if current := scope.current; current != nil && current.Next == nil && !current.Terminated() {
+		current.Next = b
+	}
+
+	b.ID = len(scope.fn.Blocks)
+	scope.fn.Blocks = append(scope.fn.Blocks, b)
+	scope.current = b
 
-	c.previousInstruction = previous
-	c.lastInstruction = last
+	return b
 }
 
-// addInstruction() adds an instruction to the bytecode.
-func (c *Compiler) addInstruction(ins []byte) int {
-	posNewInstruction := len(c.instructions)
-	c.instructions = append(c.instructions, ins...)
+// branch() emits a conditional jump to target, op's jump operand being left to the layout, and goes on in a new block
+// for when it doesn't jump.
+func (c *Compiler) branch(target *ir.Block, op code.Opcode, operands ...int) {
+	jumpIndex, _ := code.JumpOperand(op)
+	operands = append(operands[:jumpIndex:jumpIndex], append([]int{0}, operands[jumpIndex:]...)...)
 
-	return posNewInstruction
+	c.emit(op, operands...)
+	c.scopes[c.scopeIndex].current.Branch = target
+
+	c.placeBlock(c.newBlock())
 }
 
-// lastInstructionIsPop() checks if the last emitted instruction is a pop instruction.
-func (c *Compiler) lastInstructionIsPop() bool {
-	return c.lastInstruction.Opcode == code.OpPop
+// jump() ends the block being emitted into with a jump to target. The layout only emits an OpJump for it when the
+// target isn't laid out next.
+func (c *Compiler) jump(target *ir.Block) {
+	scope := &c.scopes[c.scopeIndex]
+	if scope.current == nil || scope.current.Terminated() {
+		return
+	}
+
+	scope.current.Next = target
+	scope.current = nil
 }
 
-// replaceInstruction() replaces an instruction at a given position with a new instruction.
-func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
-	for i := 0; i < len(newInstruction); i++ {
-		c.instructions[pos+i] = newInstruction[i]
+// boundary() returns a block starting at the next instruction emitted, for a handler range to start or end at.
+func (c *Compiler) boundary() *ir.Block {
+	current := c.scopes[c.scopeIndex].current
+	if current != nil && len(current.Instructions) == 0 {
+		return current
 	}
+
+	return c.placeBlock(c.newBlock())
+}
+
+// lastInstruction() returns the last instruction emitted into the current block, nil if there is none.
+func (c *Compiler) lastInstruction() *ir.Instruction {
+	current := c.scopes[c.scopeIndex].current
+	if current == nil || len(current.Instructions) == 0 {
+		return nil
+	}
+
+	return &current.Instructions[len(current.Instructions)-1]
 }
 
-// changeOperand() replaces the operand of an instruction.
-func (c *Compiler) changeOperand(opPos int, operand int) {
-	op := code.Opcode(c.instructions[opPos])
-	newInstruction := code.Make(op, operand)
+// lastInstructionIs() checks if the last instruction emitted into the current block has the given opcode.
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	last := c.lastInstruction()
+	return last != nil && last.Op == op
+}
 
-	c.replaceInstruction(opPos, newInstruction)
+// lastInstructionIsPop() checks if the last emitted instruction is a pop instruction.
+func (c *Compiler) lastInstructionIsPop() bool {
+	return c.lastInstructionIs(code.OpPop)
 }
 
-// removeLastPop() shortens `c.instructions` and removes the last emitted pop instruction, then sets the c.lastInstruction to the c.previousInstruction.
+// removeLastPop() removes the last emitted instruction, a pop.
 func (c *Compiler) removeLastPop() {
-	c.instructions = c.instructions[:c.lastInstruction.Position]
-	c.lastInstruction = c.previousInstruction
+	current := c.scopes[c.scopeIndex].current
+	current.Instructions = current.Instructions[:len(current.Instructions)-1]
+}
+
+// replaceLastPopWithReturn() turns the implicit result of a function body's last expression statement into its return value.
+func (c *Compiler) replaceLastPopWithReturn() {
+	c.lastInstruction().Op = code.OpReturnValue
 }
 
-// Bytecode() returns the compiled bytecode.
+// enterScope() starts compiling a new function body with its own instructions and symbol table.
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, newCompilationScope())
+	c.scopeIndex++
+
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+// leaveScope() finishes the current function body and returns its scope.
+func (c *Compiler) leaveScope() CompilationScope {
+	c.finishScope()
+	scope := c.scopes[c.scopeIndex]
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return scope
+}
+
+// Bytecode() returns the compiled bytecode. The first call finishes the main program's scope, later ones return the
+// same bytecode, and nothing can be compiled after it.
 func (c *Compiler) Bytecode() *Bytecode {
-	return &Bytecode{
-		Instructions: c.instructions,
+	if c.bytecode != nil {
+		return c.bytecode
+	}
+
+	c.finishScope()
+	scope := c.scopes[c.scopeIndex]
+
+	c.bytecode = &Bytecode{
+		Instructions: scope.instructions,
 		Constants:    c.constants,
+		Handlers:     scope.handlers,
+		NumTrySlots:  scope.numTrySlots,
+		SourceMap:    scope.sourceMap,
+	}
+
+	return c.bytecode
+}
+
+// finishScope() ends the current scope's code with an empty exit block, runs the optimization passes on its blocks and
+// lays them out as instructions, with their handler table and source map.
+func (c *Compiler) finishScope() {
+	scope := &c.scopes[c.scopeIndex]
+	c.placeBlock(c.newBlock())
+
+	if c.optimizations.Peephole {
+		peephole(scope.fn, c.scopeIndex > 0)
+	}
+	if c.optimizations.DeadCode {
+		scope.fn.RemoveUnreachable()
+		scope.numTrySlots = scope.fn.CompactTrySlots()
 	}
+
+	scope.instructions, scope.handlers, scope.sourceMap = scope.fn.Linearize()
 }
 
-// Bytecode is the Bytecode struct - it holds the bytecode instructions and the constant pool.
+// Bytecode is the Bytecode struct - it holds the bytecode instructions and the constant pool,
+// along with the exception handler table and source map of the main program.
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
-}
-
-// EmittedInstruction is a struct that holds the opcode and the position of the instruction in the bytecode.
-type EmittedInstruction struct {
-	Opcode   code.Opcode
-	Position int
+	Handlers     []code.Handler
+	NumTrySlots  int
+	SourceMap    code.SourceMap
 }
@@ -7,6 +7,7 @@ import (
 	"chui/object"
 	"chui/parser"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -239,6 +240,31 @@ func TestConditionals(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			// A branch that doesn't end in an expression statement is worth null
+			input: `
+			if (true) { let z = 1; };
+			`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 14),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpSetGlobal, 0),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpJump, 15),
+				// 0014
+				code.Make(code.OpNull),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
 	}
 	runCompilerTests(t, tests)
 }
@@ -291,6 +317,825 @@ func TestGlobalLetStatements(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestStringExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"chui"`,
+			expectedConstants: []interface{}{"chui"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"ch" + "ui"`,
+			expectedConstants: []interface{}{"ch", "ui"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestArrayLiterals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[]",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "[1, 2 + 3]",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestHashLiterals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "{}",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "{2: 3, 1: 2}",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpHash, 4),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestIndexExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[1, 2][1]",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "{1: 2}[1]",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpIndex),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "match (1) { 0 => 10, x if x > 5 => x, _ => 20 }",
+			expectedConstants: []interface{}{1, 0, 10, 5, 20},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpConstant, 1),
+				// 0012
+				code.Make(code.OpMatchEqual),
+				// 0013
+				code.Make(code.OpJumpNotTruthy, 22),
+				// 0016
+				code.Make(code.OpConstant, 2),
+				// 0019
+				code.Make(code.OpJump, 54),
+				// 0022
+				code.Make(code.OpGetGlobal, 0),
+				// 0025
+				code.Make(code.OpSetGlobal, 1),
+				// 0028
+				code.Make(code.OpGetGlobal, 1),
+				// 0031
+				code.Make(code.OpConstant, 3),
+				// 0034
+				code.Make(code.OpGreaterThan),
+				// 0035
+				code.Make(code.OpJumpNotTruthy, 44),
+				// 0038
+				code.Make(code.OpGetGlobal, 1),
+				// 0041
+				code.Make(code.OpJump, 54),
+				// 0044
+				code.Make(code.OpConstant, 4),
+				// 0047
+				code.Make(code.OpJump, 54),
+				// 0050
+				code.Make(code.OpGetGlobal, 0),
+				// 0053
+				code.Make(code.OpNoMatch),
+				// 0054
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestDestructuringLetStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let [a, ...b] = [1, 2];",
+			expectedConstants: []interface{}{1, 2, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpArraySlice, 1),
+				code.Make(code.OpSetGlobal, 2),
+			},
+		},
+		{
+			input:             `let {a = 5} = {};`,
+			expectedConstants: []interface{}{"a", 5},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpHash, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpConstant, 0),
+				// 0012
+				code.Make(code.OpIndex),
+				// 0013
+				code.Make(code.OpSetGlobal, 1),
+				// 0016
+				code.Make(code.OpGetGlobal, 1),
+				// 0019
+				code.Make(code.OpNull),
+				// 0020
+				code.Make(code.OpEqual),
+				// 0021
+				code.Make(code.OpJumpNotTruthy, 30),
+				// 0024
+				code.Make(code.OpConstant, 1),
+				// 0027
+				code.Make(code.OpJump, 33),
+				// 0030
+				code.Make(code.OpGetGlobal, 1),
+				// 0033
+				code.Make(code.OpSetGlobal, 2),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `func() { return 5 + 10 }`,
+			expectedConstants: []interface{}{
+				5,
+				10,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `func() { 5 + 10 }`,
+			expectedConstants: []interface{}{
+				5,
+				10,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `func() { }`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestFunctionCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `func() { 24 }();`,
+			expectedConstants: []interface{}{
+				24,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			let manyArg = func(a, b, c) { a; b; c };
+			manyArg(24, 25, 26);
+			`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 2),
+					code.Make(code.OpReturnValue),
+				},
+				24,
+				25,
+				26,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpCall, 3),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestDefaultParameters(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `func(a, b = 10, ...rest) { b }`,
+			expectedConstants: []interface{}{
+				10,
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpJumpIfArgPassed, 1, 9),
+					// 0004
+					code.Make(code.OpConstant, 0),
+					// 0007
+					code.Make(code.OpSetLocal, 1),
+					// 0009
+					code.Make(code.OpGetLocal, 1),
+					// 0011
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	program := parse(`func(a, b = 10, ...rest) { a }`)
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	fn, ok := compiler.Bytecode().Constants[1].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 1 is not a function. got=%T", compiler.Bytecode().Constants[1])
+	}
+
+	if fn.NumParameters != 2 || fn.NumDefaults != 1 || !fn.Variadic || fn.NumLocals != 3 {
+		t.Errorf("wrong function layout. got=%+v", fn)
+	}
+}
+
+func TestLetStatementScopes(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			let num = 55;
+			func() { num }
+			`,
+			expectedConstants: []interface{}{
+				55,
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+			func() {
+				let num = 55;
+				num
+			}
+			`,
+			expectedConstants: []interface{}{
+				55,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestBuiltins(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			len([]);
+			push([], 1);
+			`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetBuiltin, 5),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			func(a) {
+				func(b) {
+					a + b
+				}
+			}
+			`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			let countDown = func(x) { countDown(x - 1); };
+			countDown(1);
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `try { 1 } catch (e) { e }`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTry, 0),
+				// 0003
+				code.Make(code.OpConstant, 0),
+				// 0006
+				code.Make(code.OpJump, 15),
+				// 0009
+				code.Make(code.OpSetGlobal, 0),
+				// 0012
+				code.Make(code.OpGetGlobal, 0),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `try { 1 } finally { 2 }`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTry, 0),
+				// 0003
+				code.Make(code.OpConstant, 0),
+				// 0006
+				code.Make(code.OpConstant, 1),
+				// 0009
+				code.Make(code.OpPop),
+				// 0010
+				code.Make(code.OpJump, 24),
+				// 0013
+				code.Make(code.OpSetGlobal, 0),
+				// 0016, the finally block's copy shares its constant
+				code.Make(code.OpConstant, 1),
+				// 0019
+				code.Make(code.OpPop),
+				// 0020
+				code.Make(code.OpGetGlobal, 0),
+				// 0023
+				code.Make(code.OpThrow),
+				// 0024
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `throw "boom"`,
+			expectedConstants: []interface{}{"boom"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpThrow),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	handlerTests := []struct {
+		input            string
+		expectedHandlers []code.Handler
+	}{
+		{`try { 1 } catch (e) { e }`, []code.Handler{{Start: 3, End: 6, Target: 9, Slot: 0}}},
+		{`try { 1 } finally { 2 }`, []code.Handler{{Start: 3, End: 6, Target: 13, Slot: 0}}},
+		{
+			`try { try { 1 } catch { 2 } } catch { 3 }`,
+			[]code.Handler{
+				{Start: 3, End: 16, Target: 19, Slot: 0},
+				{Start: 6, End: 9, Target: 12, Slot: 1},
+			},
+		},
+	}
+
+	for _, tt := range handlerTests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		handlers := compiler.Bytecode().Handlers
+		if len(handlers) != len(tt.expectedHandlers) {
+			t.Fatalf("wrong number of handlers for %q. want=%d, got=%d",
+				tt.input, len(tt.expectedHandlers), len(handlers))
+		}
+
+		for i, h := range tt.expectedHandlers {
+			if handlers[i] != h {
+				t.Errorf("wrong handler %d for %q. want=%+v, got=%+v",
+					i, tt.input, h, handlers[i])
+			}
+		}
+	}
+}
+
+func TestReturnRunsFinallyBlocks(t *testing.T) {
+	program := parse(`func() { try { return 1 } catch (e) { 2 } finally { 3 } }`)
+
+	compiler := New()
+	compiler.SetOptimizations(Optimizations{})
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	fn, ok := compiler.Bytecode().Constants[len(compiler.Bytecode().Constants)-1].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("last constant is not a function")
+	}
+
+	expected := []code.Instructions{
+		// 0000
+		code.Make(code.OpTry, 0),
+		// 0003, the return closes both guards and runs the finally block first
+		code.Make(code.OpConstant, 0),
+		// 0006
+		code.Make(code.OpConstant, 1),
+		// 0009
+		code.Make(code.OpPop),
+		// 0010
+		code.Make(code.OpReturnValue),
+	}
+
+	concatted := concatInstructions(expected)
+	if len(fn.Instructions) < len(concatted) {
+		t.Fatalf("function instructions too short.\n%s", fn.Instructions)
+	}
+
+	err = testInstructions(expected, fn.Instructions[:len(concatted)])
+	if err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	// The guards are closed around the inlined finally block and reopened after the return
+	want := []code.Handler{
+		{Start: 3, End: 6, Target: 27, Slot: 0},
+		{Start: 3, End: 6, Target: 15, Slot: 0},
+		{Start: 11, End: 20, Target: 27, Slot: 0},
+		{Start: 11, End: 12, Target: 15, Slot: 0},
+	}
+	if len(fn.Handlers) != len(want) {
+		t.Fatalf("wrong number of handlers. want=%d, got=%d (%+v)", len(want), len(fn.Handlers), fn.Handlers)
+	}
+	for i, h := range want {
+		if fn.Handlers[i] != h {
+			t.Errorf("wrong handler %d. want=%+v, got=%+v", i, h, fn.Handlers[i])
+		}
+	}
+}
+
+func TestConstantDeduplication(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `1; "1"; 1; "1"`,
+			expectedConstants: []interface{}{1, "1"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "func() { 5 }; func() { 5 }",
+			expectedConstants: []interface{}{
+				5,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	// Like the REPL, a compiler carrying on with another one's constants reuses them
+	first := New()
+	if err := first.Compile(parse(`let a = 1; let f = func() { "s" };`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	constants := first.Bytecode().Constants
+
+	second := NewWithState(first.symbolTable, constants)
+	if err := second.Compile(parse(`1; "s"; 2`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	reused := second.Bytecode().Constants
+	if len(reused) != 4 || reused[2] != constants[2] {
+		t.Fatalf("wrong constants. want the first compiler's 3 constants and the new 2, got=%v", reused)
+	}
+	if err := testConstants([]interface{}{1, "s"}, reused[:2]); err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+	if err := testIntegerObject(2, reused[3]); err != nil {
+		t.Fatalf("constant 3 - testIntegerObject failed: %s", err)
+	}
+
+	if index := second.addConstant(constants[2]); index != 2 {
+		t.Errorf("function added again at %d, want 2", index)
+	}
+}
+
+func TestMacroLiteralsAreNotCompiled(t *testing.T) {
+	program := parse(`let m = macro(x) { x };`)
+
+	compiler := New()
+	err := compiler.Compile(program)
+	if err == nil {
+		t.Fatalf("expected compiler error but resulted in none.")
+	}
+
+	expected := "cannot compile macro literal macro(x) x: macros are defined by let statements and expanded before compilation"
+	if err.Error() != expected {
+		t.Fatalf("wrong compiler error. want=%q, got=%q", expected, err)
+	}
+}
+
+func TestBytecodeIsLaidOutOnce(t *testing.T) {
+	compiler := New()
+	err := compiler.Compile(parse("let f = func(x) { try { x } catch (e) { 0 } }; if (f(1)) { 2 }"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	first := compiler.Bytecode()
+	instructions := append(code.Instructions{}, first.Instructions...)
+
+	if second := compiler.Bytecode(); second != first {
+		t.Errorf("second call laid the bytecode out again")
+	}
+	if err := testInstructions([]code.Instructions{instructions}, first.Instructions); err != nil {
+		t.Errorf("second call changed the instructions: %s", err)
+	}
+
+	err = compiler.Compile(parse("3"))
+	if err == nil {
+		t.Fatalf("expected compiler error but resulted in none.")
+	}
+
+	expected := "cannot compile after the bytecode has been laid out"
+	if err.Error() != expected {
+		t.Fatalf("wrong compiler error. want=%q, got=%q", expected, err)
+	}
+}
+
+func TestWideOperands(t *testing.T) {
+	// 66000 elements take more than 64KB of OpTrue, so the jumps over them need OpWide
+	elements := strings.Repeat("true, ", 65999) + "true"
+	consequence := []code.Instructions{}
+	for i := 0; i < 66000; i++ {
+		consequence = append(consequence, code.Make(code.OpTrue))
+	}
+
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { [" + elements + "] };\n5",
+			expectedConstants: []interface{}{5},
+			expectedInstructions: append(append([]code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 66019),
+			}, consequence...),
+				// 66007
+				code.Make(code.OpArray, 66000),
+				// 66013
+				code.Make(code.OpJump, 66020),
+				// 66019
+				code.Make(code.OpNull),
+				// 66020
+				code.Make(code.OpPop),
+				// 66021
+				code.Make(code.OpConstant, 0),
+				// 66024
+				code.Make(code.OpPop),
+			),
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	compiler := New()
+	compiler.SetOptimizations(Optimizations{})
+	if err := compiler.Compile(parse(tests[0].input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	// The source map follows the instructions to where they moved
+	if line, _ := compiler.Bytecode().SourceMap.Lookup(66021); line != 2 {
+		t.Errorf("wrong line for the last constant. want=2, got=%d", line)
+	}
+}
+
 func parse(input string) *ast.Program {
 	l := lexer.New(input)
 	p := parser.New(l)
@@ -302,6 +1147,8 @@ func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 	for _, tt := range tests {
 		program := parse(tt.input)
 		compiler := New()
+		// The tests show what each construct compiles to, which folding would hide
+		compiler.SetOptimizations(Optimizations{})
 		err := compiler.Compile(program)
 
 		if err != nil {
@@ -372,6 +1219,26 @@ func testConstants(
 				return fmt.Errorf("constant %d - testIntegerObject failed: %s",
 					i, err)
 			}
+
+		case string:
+			err := testStringObject(constant, actual[i])
+			if err != nil {
+				return fmt.Errorf("constant %d - testStringObject failed: %s",
+					i, err)
+			}
+
+		case []code.Instructions:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				return fmt.Errorf("constant %d - not a function: %T",
+					i, actual[i])
+			}
+
+			err := testInstructions(constant, fn.Instructions)
+			if err != nil {
+				return fmt.Errorf("constant %d - testInstructions failed: %s",
+					i, err)
+			}
 		}
 	}
 	return nil
@@ -392,3 +1259,19 @@ func testIntegerObject(expected int64, actual object.Object) error {
 
 	return nil
 }
+
+func testStringObject(expected string, actual object.Object) error {
+	result, ok := actual.(*object.String)
+
+	if !ok {
+		return fmt.Errorf("object is not String. got=%T (%+v)",
+			actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%q, want=%q",
+			result.Value, expected)
+	}
+
+	return nil
+}
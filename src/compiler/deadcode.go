@@ -0,0 +1,166 @@
+package compiler
+
+import (
+	"chui/ast"
+	"fmt"
+)
+
+// Warning describes dead code the compiler dropped from a program.
+type Warning struct {
+	Line    int // position of the dead code, 0 if unknown
+	Column  int
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("line %d, column %d: %s", w.Line, w.Column, w.Message)
+}
+
+// eliminateDeadCode() returns a copy of program without the code that can't run or whose value is thrown away,
+// along with a warning for each piece of it:
+//
+//   - statements after a return or throw statement in the same block. Let statements in a block of an if, match
+//     or try are kept, the names they bind are visible after the block
+//   - expression statements that aren't the last of their block and whose value can be computed without side
+//     effects or errors, such as literals
+//   - the branch an if on a literal condition never takes, which the compiler leaves out, see compileConstantIf()
+func eliminateDeadCode(program *ast.Program) (*ast.Program, []Warning) {
+	eliminated := ast.Clone(program).(*ast.Program)
+
+	d := &deadCodeEliminator{}
+	eliminated.Statements = d.statements(eliminated.Statements, true)
+
+	return eliminated, d.warnings
+}
+
+type deadCodeEliminator struct {
+	warnings []Warning
+}
+
+func (d *deadCodeEliminator) warn(node ast.Node, format string, args ...interface{}) {
+	line, column := ast.Position(node)
+	d.warnings = append(d.warnings, Warning{Line: line, Column: column, Message: fmt.Sprintf(format, args...)})
+}
+
+// statements() returns the live statements of a block, body being true for the program and function bodies.
+func (d *deadCodeEliminator) statements(statements []ast.Statement, body bool) []ast.Statement {
+	live := make([]ast.Statement, 0, len(statements))
+	exit, warned := "", false
+
+	for i, stmt := range statements {
+		if exit != "" {
+			if !warned {
+				d.warn(stmt, "unreachable code after %s", exit)
+				warned = true
+			}
+			if body || !bindsNames(stmt) {
+				continue
+			}
+		}
+
+		if exp, ok := stmt.(*ast.ExpressionStatement); ok && i < len(statements)-1 && isPure(exp.Expression) {
+			d.warn(stmt, "unused value %s", ast.Format(exp.Expression))
+			continue
+		}
+
+		d.inspect(stmt)
+		live = append(live, stmt)
+
+		switch stmt.(type) {
+		case *ast.ReturnStatement:
+			exit = "return"
+		case *ast.ThrowStatement:
+			exit = "throw"
+		}
+	}
+
+	return live
+}
+
+// inspect() eliminates the dead code in the blocks below node.
+func (d *deadCodeEliminator) inspect(node ast.Node) {
+	ast.Inspect(node, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.FunctionLiteral:
+			for _, def := range node.Defaults {
+				if def != nil {
+					d.inspect(def)
+				}
+			}
+			node.Body.Statements = d.statements(node.Body.Statements, true)
+			return false
+
+		case *ast.BlockStatement:
+			node.Statements = d.statements(node.Statements, false)
+			return false
+
+		case *ast.IfExpression:
+			truthy, ok := constantTruthiness(node.Condition)
+			if !ok {
+				return true
+			}
+
+			taken, dead := node.Consequence, node.Alternative
+			if !truthy {
+				taken, dead = dead, taken
+			}
+			if dead != nil && len(dead.Statements) > 0 {
+				d.warn(dead.Statements[0], "unreachable branch, the condition is always %t", truthy)
+			}
+			if taken != nil {
+				d.inspect(taken)
+			}
+			return false
+		}
+
+		return true
+	})
+}
+
+// bindsNames() reports whether stmt defines names.
+func bindsNames(stmt ast.Statement) bool {
+	switch stmt.(type) {
+	case *ast.LetStatement, *ast.DestructuringLetStatement:
+		return true
+	}
+	return false
+}
+
+// isPure() reports whether evaluating exp has no effect and can't fail, so it can be dropped when its value isn't
+// used. Identifiers aren't, dropping them would hide the error the compiler reports for undefined names.
+func isPure(exp ast.Expression) bool {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean, *ast.NullLiteral:
+		return true
+
+	case *ast.PrefixExpression:
+		if exp.Operator == "-" {
+			_, ok := exp.Right.(*ast.IntegerLiteral)
+			return ok
+		}
+		return exp.Operator == "!" && isPure(exp.Right)
+
+	case *ast.ArrayLiteral:
+		for _, element := range exp.Elements {
+			if !isPure(element) {
+				return false
+			}
+		}
+		return true
+
+	case *ast.HashLiteral:
+		for key, value := range exp.Pairs {
+			switch key.(type) {
+			case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean:
+			default:
+				return false
+			}
+			if !isPure(value) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,96 @@
+package compiler
+
+import (
+	"chui/ast"
+	"chui/code"
+	"reflect"
+	"testing"
+)
+
+func TestEliminateDeadCode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		warnings []string
+	}{
+		{"1; x; 2;", "x;\n2;", []string{"line 1, column 1: unused value 1"}},
+		{`[1, "a", !true]; {1: -2}; 3;`, "3;", []string{
+			`line 1, column 1: unused value [1, "a", !true]`,
+			"line 1, column 18: unused value {1: -2}",
+		}},
+		{"[x]; {[1]: 2}; 3;", "[x];\n{[1]: 2};\n3;", nil},
+		{
+			"let f = func() { return 1; x; 2 };",
+			"let f = func() {\n    return 1;\n};",
+			[]string{"line 1, column 28: unreachable code after return"},
+		},
+		{
+			"func() { if (x) { throw 1; let a = 2; a } };",
+			"func() {\n    if (x) {\n        throw 1;\n        let a = 2;\n    }\n};",
+			[]string{"line 1, column 28: unreachable code after throw"},
+		},
+		{
+			"if (false) { x } else { 1; y };",
+			"if (false) {\n    x;\n} else {\n    y;\n}",
+			[]string{
+				"line 1, column 14: unreachable branch, the condition is always false",
+				"line 1, column 25: unused value 1",
+			},
+		},
+		{"if (true) { x };", "if (true) {\n    x;\n}", nil},
+		{"func(a = func() { return 1; 2 }) { a };", "func(a = func() {\n    return 1;\n}) {\n    a;\n};", []string{
+			"line 1, column 29: unreachable code after return",
+		}},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		before := ast.Format(program)
+
+		eliminated, warnings := eliminateDeadCode(program)
+		if actual := ast.Format(eliminated); actual != tt.expected+"\n" {
+			t.Errorf("wrong dead code elimination of %q.\nwant=\n%s\ngot=\n%s", tt.input, tt.expected, actual)
+		}
+
+		var messages []string
+		for _, w := range warnings {
+			messages = append(messages, w.String())
+		}
+		if !reflect.DeepEqual(messages, tt.warnings) {
+			t.Errorf("wrong warnings for %q.\nwant=%q\ngot =%q", tt.input, tt.warnings, messages)
+		}
+
+		if after := ast.Format(program); after != before {
+			t.Errorf("dead code elimination changed the original program %q to\n%s", tt.input, after)
+		}
+	}
+}
+
+func TestCompileDeadCode(t *testing.T) {
+	compiler := New()
+	compiler.SetOptimizations(Optimizations{DeadCode: true})
+	if err := compiler.Compile(parse("1;\nif (false) { 2 } else { 3 }")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	// Only the else branch is left, even without folding
+	expected := []code.Instructions{
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpPop),
+	}
+	bytecode := compiler.Bytecode()
+	if err := testInstructions(expected, bytecode.Instructions); err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+	if err := testConstants([]interface{}{3}, bytecode.Constants); err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+
+	want := []Warning{
+		{Line: 1, Column: 1, Message: "unused value 1"},
+		{Line: 2, Column: 14, Message: "unreachable branch, the condition is always false"},
+	}
+	if !reflect.DeepEqual(compiler.Warnings(), want) {
+		t.Errorf("wrong warnings.\nwant=%v\ngot =%v", want, compiler.Warnings())
+	}
+}
@@ -0,0 +1,106 @@
+package compiler
+
+import (
+	"chui/ast"
+	"chui/code"
+	"chui/object"
+	"fmt"
+)
+
+// destructureTempName is the hidden symbol a value is kept in while its parts are being bound.
+const destructureTempName = "let#value"
+
+// compileDestructuringLet() compiles the value once, then binds its parts with index operations.
+func (c *Compiler) compileDestructuringLet(node *ast.DestructuringLetStatement) error {
+	err := c.Compile(node.Value)
+	if err != nil {
+		return err
+	}
+
+	return c.bindPattern(node.Pattern)
+}
+
+// bindPattern() pops the value on top of the stack and binds it to the identifiers in the pattern.
+// Missing elements come out of OpIndex as null, which is what a DefaultPattern checks for.
+func (c *Compiler) bindPattern(pattern ast.Expression) error {
+	switch pattern := pattern.(type) {
+
+	case *ast.Identifier:
+		if pattern.Value == "_" {
+			c.storeSymbol(c.symbolTable.Define(destructureTempName))
+			return nil
+		}
+
+		c.storeSymbol(c.symbolTable.Define(pattern.Value))
+
+	case *ast.DefaultPattern:
+		value := c.symbolTable.Define(destructureTempName)
+		c.storeSymbol(value)
+
+		c.loadSymbol(value)
+		c.emit(code.OpNull)
+		c.emit(code.OpEqual)
+
+		notNull, end := c.newBlock(), c.newBlock()
+		c.branch(notNull, code.OpJumpNotTruthy)
+
+		err := c.Compile(pattern.Default)
+		if err != nil {
+			return err
+		}
+
+		c.jump(end)
+		c.placeBlock(notNull)
+		c.loadSymbol(value)
+		c.placeBlock(end)
+
+		return c.bindPattern(pattern.Target)
+
+	case *ast.ArrayPattern:
+		array := c.symbolTable.Define(destructureTempName)
+		c.storeSymbol(array)
+
+		for i, element := range pattern.Elements {
+			c.loadSymbol(array)
+			c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: int64(i)}))
+			c.emit(code.OpIndex)
+
+			err := c.bindPattern(element)
+			if err != nil {
+				return err
+			}
+		}
+
+		if pattern.Rest != nil {
+			c.loadSymbol(array)
+			c.emit(code.OpArraySlice, len(pattern.Elements))
+
+			return c.bindPattern(pattern.Rest)
+		}
+
+	case *ast.HashPattern:
+		hash := c.symbolTable.Define(destructureTempName)
+		c.storeSymbol(hash)
+
+		for _, pair := range pattern.Pairs {
+			c.loadSymbol(hash)
+
+			err := c.Compile(pair.Key)
+			if err != nil {
+				return err
+			}
+
+			c.emit(code.OpIndex)
+
+			err = c.bindPattern(pair.Value)
+			if err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("unsupported pattern in let %s", pattern.String())
+	}
+
+	return nil
+}
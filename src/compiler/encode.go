@@ -0,0 +1,321 @@
+package compiler
+
+import (
+	"bytes"
+	"chui/code"
+	"chui/object"
+	"encoding/binary"
+	"fmt"
+)
+
+// The binary bytecode format, all numbers big endian:
+//
+//	magic       "CHUI"
+//	version     uint16
+//	constants   uint32 count, then per constant a tag byte and its value:
+//	            integer  int64
+//	            string   uint32 length, bytes
+//	            function name (a string), uint32 locals, parameters and defaults,
+//	                     a variadic byte, then a code block
+//	main        a code block
+//
+// A code block is the instructions (uint32 length, bytes) followed by their debug info:
+// the number of try slots as a uint32, the handler table (uint32 count, then start, end,
+// target and slot as uint32s) and the source map (uint32 count, then offset, line and
+// column as uint32s).
+const (
+	bytecodeMagic = "CHUI"
+
+	// BytecodeVersion is the version of the format Encode writes. It changes whenever
+	// the format or the meaning of the instructions does, Decode rejects other versions.
+	BytecodeVersion = 2
+)
+
+// Constant tags.
+const (
+	tagInteger  byte = 1
+	tagString   byte = 2
+	tagFunction byte = 3
+)
+
+// Encode() serializes the bytecode into the binary format Decode reads.
+// It fails on constants the format has no tag for.
+func (b *Bytecode) Encode() ([]byte, error) {
+	w := &bytecodeWriter{}
+
+	w.buf.WriteString(bytecodeMagic)
+	w.uint16(BytecodeVersion)
+
+	w.uint32(len(b.Constants))
+	for i, constant := range b.Constants {
+		if err := w.constant(constant); err != nil {
+			return nil, fmt.Errorf("constant %d: %s", i, err)
+		}
+	}
+
+	w.codeBlock(b.Instructions, b.NumTrySlots, b.Handlers, b.SourceMap)
+
+	return w.buf.Bytes(), nil
+}
+
+// IsBytecode() reports whether data starts like bytecode serialized by Encode.
+func IsBytecode(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(bytecodeMagic))
+}
+
+// Decode() reads bytecode serialized by Encode, checking the magic and version first.
+func Decode(data []byte) (*Bytecode, error) {
+	r := &bytecodeReader{data: data}
+
+	if !IsBytecode(data) {
+		return nil, fmt.Errorf("not a chui bytecode file")
+	}
+	r.pos = len(bytecodeMagic)
+
+	version := r.uint16()
+	if r.err == nil && version != BytecodeVersion {
+		return nil, fmt.Errorf("unsupported bytecode version %d, want %d", version, BytecodeVersion)
+	}
+
+	numConstants := r.count(1)
+	constants := make([]object.Object, 0, numConstants)
+	for i := 0; i < numConstants && r.err == nil; i++ {
+		constants = append(constants, r.constant())
+	}
+
+	bytecode := &Bytecode{Constants: constants}
+	bytecode.Instructions, bytecode.NumTrySlots, bytecode.Handlers, bytecode.SourceMap = r.codeBlock()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.pos != len(data) {
+		return nil, fmt.Errorf("malformed bytecode: %d unexpected bytes at offset %d", len(data)-r.pos, r.pos)
+	}
+
+	return bytecode, nil
+}
+
+// bytecodeWriter appends the parts of the format to a buffer.
+type bytecodeWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *bytecodeWriter) uint16(n int) {
+	w.buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+}
+
+func (w *bytecodeWriter) uint32(n int) {
+	w.buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+}
+
+func (w *bytecodeWriter) string(s string) {
+	w.uint32(len(s))
+	w.buf.WriteString(s)
+}
+
+func (w *bytecodeWriter) constant(constant object.Object) error {
+	switch constant := constant.(type) {
+	case *object.Integer:
+		w.buf.WriteByte(tagInteger)
+		w.buf.Write(binary.BigEndian.AppendUint64(nil, uint64(constant.Value)))
+
+	case *object.String:
+		w.buf.WriteByte(tagString)
+		w.string(constant.Value)
+
+	case *object.CompiledFunction:
+		w.buf.WriteByte(tagFunction)
+		w.string(constant.Name)
+		w.uint32(constant.NumLocals)
+		w.uint32(constant.NumParameters)
+		w.uint32(constant.NumDefaults)
+		if constant.Variadic {
+			w.buf.WriteByte(1)
+		} else {
+			w.buf.WriteByte(0)
+		}
+		w.codeBlock(constant.Instructions, constant.NumTrySlots, constant.Handlers, constant.SourceMap)
+
+	default:
+		return fmt.Errorf("cannot encode a constant of type %s", constant.Type())
+	}
+
+	return nil
+}
+
+func (w *bytecodeWriter) codeBlock(ins code.Instructions, numTrySlots int, handlers []code.Handler, sourceMap code.SourceMap) {
+	w.uint32(len(ins))
+	w.buf.Write(ins)
+
+	w.uint32(numTrySlots)
+
+	w.uint32(len(handlers))
+	for _, h := range handlers {
+		w.uint32(h.Start)
+		w.uint32(h.End)
+		w.uint32(h.Target)
+		w.uint32(h.Slot)
+	}
+
+	w.uint32(len(sourceMap))
+	for _, p := range sourceMap {
+		w.uint32(p.Offset)
+		w.uint32(p.Line)
+		w.uint32(p.Column)
+	}
+}
+
+// bytecodeReader reads the parts of the format from data. The first error is kept in err,
+// after which every read returns zero values, so callers check it once at the end.
+type bytecodeReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+// next returns the next n bytes, or nil once the data runs out.
+func (r *bytecodeReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.data)-r.pos {
+		r.err = fmt.Errorf("malformed bytecode: unexpected end of data at offset %d", r.pos)
+		return nil
+	}
+
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *bytecodeReader) byte() byte {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *bytecodeReader) uint16() int {
+	b := r.next(2)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(b))
+}
+
+func (r *bytecodeReader) uint32() int {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(b))
+}
+
+// count reads the number of entries of a list whose entries take at least size bytes each,
+// rejecting counts the remaining data can't hold before anything is allocated for them.
+func (r *bytecodeReader) count(size int) int {
+	n := r.uint32()
+	if r.err == nil && n > (len(r.data)-r.pos)/size {
+		r.err = fmt.Errorf("malformed bytecode: count %d at offset %d exceeds the remaining data", n, r.pos-4)
+		return 0
+	}
+	return n
+}
+
+func (r *bytecodeReader) string() string {
+	return string(r.next(r.count(1)))
+}
+
+func (r *bytecodeReader) constant() object.Object {
+	offset := r.pos
+
+	switch tag := r.byte(); tag {
+	case tagInteger:
+		b := r.next(8)
+		if b == nil {
+			return nil
+		}
+		return &object.Integer{Value: int64(binary.BigEndian.Uint64(b))}
+
+	case tagString:
+		return &object.String{Value: r.string()}
+
+	case tagFunction:
+		fn := &object.CompiledFunction{
+			Name:          r.string(),
+			NumLocals:     r.uint32(),
+			NumParameters: r.uint32(),
+			NumDefaults:   r.uint32(),
+		}
+
+		switch variadic := r.byte(); variadic {
+		case 0:
+		case 1:
+			fn.Variadic = true
+		default:
+			if r.err == nil {
+				r.err = fmt.Errorf("malformed bytecode: invalid variadic flag %d at offset %d", variadic, r.pos-1)
+			}
+		}
+
+		fn.Instructions, fn.NumTrySlots, fn.Handlers, fn.SourceMap = r.codeBlock()
+		return fn
+
+	default:
+		if r.err == nil {
+			r.err = fmt.Errorf("malformed bytecode: unknown constant tag %d at offset %d", tag, offset)
+		}
+		return nil
+	}
+}
+
+func (r *bytecodeReader) codeBlock() (code.Instructions, int, []code.Handler, code.SourceMap) {
+	ins := code.Instructions(append([]byte{}, r.next(r.count(1))...))
+
+	offset := r.pos
+	numTrySlots := r.uint32()
+	if numTries := countTries(ins); r.err == nil && numTrySlots > numTries {
+		r.err = fmt.Errorf("malformed bytecode: %d try slots at offset %d, the code has %d OpTry instructions",
+			numTrySlots, offset, numTries)
+	}
+
+	var handlers []code.Handler
+	for i, n := 0, r.count(16); i < n; i++ {
+		handlers = append(handlers, code.Handler{
+			Start:  r.uint32(),
+			End:    r.uint32(),
+			Target: r.uint32(),
+			Slot:   r.uint32(),
+		})
+	}
+
+	var sourceMap code.SourceMap
+	for i, n := 0, r.count(12); i < n; i++ {
+		sourceMap = append(sourceMap, code.SourcePosition{
+			Offset: r.uint32(),
+			Line:   r.uint32(),
+			Column: r.uint32(),
+		})
+	}
+
+	return ins, numTrySlots, handlers, sourceMap
+}
+
+// countTries() returns the number of OpTry instructions in ins, the most try slots the code can use. Undecodable
+// code, which the VM rejects before running it, counts as many as would fit in it.
+func countTries(ins code.Instructions) int {
+	decoded, err := code.DecodeInstructions(ins)
+	if err != nil {
+		return len(ins) / len(code.Make(code.OpTry, 0))
+	}
+
+	n := 0
+	for _, in := range decoded {
+		if in.Op == code.OpTry {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,90 @@
+package compiler
+
+import (
+	"chui/object"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBytecodeEncodeDecode(t *testing.T) {
+	inputs := []string{
+		"1 + 2; \"chui\"",
+		`let max = func(a, b = 0, ...rest) { if (a > b) { a } else { b } }; max(-1, 2);`,
+		`let f = func() { try { throw "x" } catch (e) { e } finally { 1 } }; try { f() } catch { 2 };`,
+	}
+
+	for _, input := range inputs {
+		comp := New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+
+		data, err := bytecode.Encode()
+		if err != nil {
+			t.Fatalf("encode error for %q: %s", input, err)
+		}
+
+		decoded, err := Decode(data)
+		if err != nil {
+			t.Fatalf("decode error for %q: %s", input, err)
+		}
+
+		if !reflect.DeepEqual(decoded, bytecode) {
+			t.Errorf("decoded bytecode differs for %q.\nwant=%+v\ngot=%+v", input, bytecode, decoded)
+		}
+	}
+}
+
+func TestBytecodeEncodeErrors(t *testing.T) {
+	bytecode := &Bytecode{Constants: []object.Object{&object.Integer{Value: 1}, &object.Boolean{Value: true}}}
+
+	_, err := bytecode.Encode()
+	if err == nil {
+		t.Fatalf("expected an error encoding a boolean constant")
+	}
+
+	expected := "constant 1: cannot encode a constant of type BOOLEAN"
+	if err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%q", expected, err.Error())
+	}
+}
+
+func TestBytecodeDecodeErrors(t *testing.T) {
+	comp := New()
+	if err := comp.Compile(parse(`let s = "hello"; func(x) { x }(s)`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	valid, err := comp.Bytecode().Encode()
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+
+	tests := []struct {
+		data          []byte
+		expectedError string
+	}{
+		{[]byte("hello"), "not a chui bytecode file"},
+		{[]byte("CHUI\x00\x09"), "unsupported bytecode version 9, want 2"},
+		{[]byte("CHUI\x00"), "malformed bytecode: unexpected end of data at offset 4"},
+		{[]byte("CHUI\x00\x02\x00\x00\x00\x01\x07"), "malformed bytecode: unknown constant tag 7 at offset 10"},
+		{[]byte("CHUI\x00\x02\xff\xff\xff\xff"), "malformed bytecode: count 4294967295 at offset 6 exceeds the remaining data"},
+		{valid[:len(valid)-3], "malformed bytecode: count 6 at offset 97 exceeds the remaining data"},
+		{append(append([]byte{}, valid...), 0), "malformed bytecode: 1 unexpected bytes"},
+		{[]byte("CHUI\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x3b\x9a\xca\x00"),
+			"malformed bytecode: 1000000000 try slots at offset 14, the code has 0 OpTry instructions"},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(tt.data)
+		if err == nil {
+			t.Errorf("expected an error decoding %q", tt.data)
+			continue
+		}
+
+		if !strings.HasPrefix(err.Error(), tt.expectedError) {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expectedError, err.Error())
+		}
+	}
+}
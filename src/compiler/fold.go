@@ -0,0 +1,370 @@
+package compiler
+
+import (
+	"chui/ast"
+	"chui/token"
+	"strconv"
+)
+
+// fold() returns a copy of program with its constant expressions evaluated: arithmetic and comparisons on integer
+// literals, `==` and `!=` on booleans, `!` and `-` prefixes, string concatenation, and ifs on constant conditions
+// whose taken branch is a single expression. Names bound to a constant by a let statement are replaced by the
+// constant where they are used. Anything the VM could fail on at runtime is left alone: a division by zero is compiled
+// to an OpDiv that raises its runtime error when it runs, which a try expression can catch.
+func fold(program *ast.Program) *ast.Program {
+	folded := ast.Clone(program).(*ast.Program)
+
+	f := &folder{scope: newFoldScope(nil)}
+	for _, stmt := range folded.Statements {
+		f.statement(stmt)
+	}
+
+	return folded
+}
+
+type folder struct {
+	scope *foldScope
+}
+
+// foldScope holds the constants names are bound to in a function body, or in a block that may not run.
+type foldScope struct {
+	constants map[string]ast.Expression // a nil value means the name is bound to something that isn't constant
+	outer     *foldScope
+}
+
+func newFoldScope(outer *foldScope) *foldScope {
+	return &foldScope{constants: map[string]ast.Expression{}, outer: outer}
+}
+
+// lookup() returns the constant name is bound to, if the innermost binding of it is one.
+func (s *foldScope) lookup(name string) (ast.Expression, bool) {
+	for ; s != nil; s = s.outer {
+		if value, ok := s.constants[name]; ok {
+			return value, value != nil
+		}
+	}
+
+	return nil, false
+}
+
+// bind() binds name to value, which is nil if it isn't a constant.
+func (s *foldScope) bind(name string, value ast.Expression) {
+	if !isConstant(value) {
+		value = nil
+	}
+	s.constants[name] = value
+}
+
+// conditional() folds code that may not run, such as a branch of an if. Its let statements
+// bind names in the enclosing scope too, so afterwards those names are no longer constant there.
+func (f *folder) conditional(fold func()) {
+	f.scope = newFoldScope(f.scope)
+	fold()
+
+	inner := f.scope
+	f.scope = inner.outer
+	for name := range inner.constants {
+		f.scope.bind(name, nil)
+	}
+}
+
+// function() folds a function body, whose bindings don't outlive it.
+func (f *folder) function(fold func()) {
+	f.scope = newFoldScope(f.scope)
+	fold()
+	f.scope = f.scope.outer
+}
+
+func (f *folder) statement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		// The value is compiled before the name is defined, so it still sees the outer binding
+		stmt.Value = f.expression(stmt.Value)
+		f.scope.bind(stmt.Name.Value, stmt.Value)
+
+	case *ast.DestructuringLetStatement:
+		stmt.Value = f.expression(stmt.Value)
+		f.bindPattern(stmt.Pattern)
+
+	case *ast.ReturnStatement:
+		stmt.ReturnValue = f.expression(stmt.ReturnValue)
+
+	case *ast.ThrowStatement:
+		stmt.Value = f.expression(stmt.Value)
+
+	case *ast.ExpressionStatement:
+		stmt.Expression = f.expression(stmt.Expression)
+
+	case *ast.BlockStatement:
+		f.block(stmt)
+	}
+}
+
+func (f *folder) block(block *ast.BlockStatement) {
+	if block == nil {
+		return
+	}
+
+	for _, stmt := range block.Statements {
+		f.statement(stmt)
+	}
+}
+
+// bindPattern() marks the names a pattern binds as not constant. Patterns themselves are
+// not folded, their identifiers are bindings rather than uses.
+func (f *folder) bindPattern(pattern ast.Expression) {
+	ast.Inspect(pattern, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.Identifier:
+			f.scope.bind(node.Value, nil)
+		case *ast.DefaultPattern:
+			f.bindPattern(node.Target)
+			return false
+		case *ast.HashPattern:
+			for _, pair := range node.Pairs {
+				f.bindPattern(pair.Value)
+			}
+			return false
+		}
+		return true
+	})
+}
+
+// expression() folds exp and returns what replaces it.
+func (f *folder) expression(exp ast.Expression) ast.Expression {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		if value, ok := f.scope.lookup(exp.Value); ok {
+			return constantAt(value, exp.Token)
+		}
+
+	case *ast.PrefixExpression:
+		exp.Right = f.expression(exp.Right)
+		if folded := foldPrefix(exp); folded != nil {
+			return folded
+		}
+
+	case *ast.InfixExpression:
+		exp.Left = f.expression(exp.Left)
+		exp.Right = f.expression(exp.Right)
+		if folded := foldInfix(exp); folded != nil {
+			return folded
+		}
+
+	case *ast.IfExpression:
+		exp.Condition = f.expression(exp.Condition)
+
+		truthy, ok := constantTruthiness(exp.Condition)
+		if !ok {
+			f.conditional(func() { f.block(exp.Consequence) })
+			f.conditional(func() { f.block(exp.Alternative) })
+			return exp
+		}
+
+		// Only the taken branch is compiled, and it always runs
+		taken := exp.Alternative
+		if truthy {
+			taken = exp.Consequence
+		}
+		f.block(taken)
+
+		switch {
+		case taken == nil:
+			return &ast.NullLiteral{Token: token.Token{Literal: "null", Line: exp.Token.Line, Column: exp.Token.Column}}
+		case len(taken.Statements) == 1:
+			if stmt, ok := taken.Statements[0].(*ast.ExpressionStatement); ok {
+				return stmt.Expression
+			}
+		}
+
+	case *ast.FunctionLiteral:
+		f.function(func() {
+			if exp.Name != "" {
+				f.scope.bind(exp.Name, nil)
+			}
+			// The parameters are defined before their defaults are compiled
+			for _, param := range exp.Parameters {
+				f.scope.bind(param.Value, nil)
+			}
+			if exp.Rest != nil {
+				f.scope.bind(exp.Rest.Value, nil)
+			}
+			for i, def := range exp.Defaults {
+				if def != nil {
+					exp.Defaults[i] = f.expression(def)
+				}
+			}
+			f.block(exp.Body)
+		})
+
+	case *ast.CallExpression:
+		exp.Function = f.expression(exp.Function)
+		for i, arg := range exp.Arguments {
+			exp.Arguments[i] = f.expression(arg)
+		}
+
+	case *ast.IndexExpression:
+		exp.Left = f.expression(exp.Left)
+		exp.Index = f.expression(exp.Index)
+
+	case *ast.ArrayLiteral:
+		for i, element := range exp.Elements {
+			exp.Elements[i] = f.expression(element)
+		}
+
+	case *ast.HashLiteral:
+		pairs := make(map[ast.Expression]ast.Expression, len(exp.Pairs))
+		for key, value := range exp.Pairs {
+			pairs[f.expression(key)] = f.expression(value)
+		}
+		exp.Pairs = pairs
+
+	case *ast.MatchExpression:
+		exp.Subject = f.expression(exp.Subject)
+		for _, arm := range exp.Arms {
+			f.conditional(func() {
+				f.bindPattern(arm.Pattern)
+				arm.Guard = f.expression(arm.Guard)
+				arm.Body = f.expression(arm.Body)
+			})
+		}
+
+	case *ast.TryExpression:
+		f.conditional(func() { f.block(exp.Body) })
+		f.conditional(func() {
+			if exp.CatchParameter != nil {
+				f.scope.bind(exp.CatchParameter.Value, nil)
+			}
+			f.block(exp.Catch)
+		})
+		f.conditional(func() { f.block(exp.Finally) })
+	}
+
+	return exp
+}
+
+// isConstant() reports whether exp is a literal the folder can compute with and propagate.
+func isConstant(exp ast.Expression) bool {
+	switch exp.(type) {
+	case *ast.IntegerLiteral, *ast.Boolean, *ast.StringLiteral, *ast.NullLiteral:
+		return true
+	}
+	return false
+}
+
+// constantTruthiness() returns whether a constant condition holds, as the VM's OpJumpNotTruthy decides it.
+func constantTruthiness(exp ast.Expression) (bool, bool) {
+	switch exp := exp.(type) {
+	case *ast.Boolean:
+		return exp.Value, true
+	case *ast.NullLiteral:
+		return false, true
+	case *ast.IntegerLiteral, *ast.StringLiteral:
+		return true, true
+	}
+	return false, false
+}
+
+// foldPrefix() returns the constant a prefix expression evaluates to, nil if it isn't one.
+func foldPrefix(exp *ast.PrefixExpression) ast.Expression {
+	switch exp.Operator {
+	case "!":
+		// Like the VM's, `!` is only true for false and null
+		if truthy, ok := constantTruthiness(exp.Right); ok {
+			return booleanAt(!truthy, exp.Token)
+		}
+
+	case "-":
+		if right, ok := exp.Right.(*ast.IntegerLiteral); ok {
+			return integerAt(-right.Value, exp.Token)
+		}
+	}
+
+	return nil
+}
+
+// foldInfix() returns the constant an infix expression evaluates to, nil if it isn't one.
+func foldInfix(exp *ast.InfixExpression) ast.Expression {
+	switch left := exp.Left.(type) {
+	case *ast.IntegerLiteral:
+		right, ok := exp.Right.(*ast.IntegerLiteral)
+		if !ok {
+			return nil
+		}
+
+		switch exp.Operator {
+		case "+":
+			return integerAt(left.Value+right.Value, exp.Token)
+		case "-":
+			return integerAt(left.Value-right.Value, exp.Token)
+		case "*":
+			return integerAt(left.Value*right.Value, exp.Token)
+		case "/":
+			if right.Value == 0 {
+				return nil
+			}
+			return integerAt(left.Value/right.Value, exp.Token)
+		case "<":
+			return booleanAt(left.Value < right.Value, exp.Token)
+		case ">":
+			return booleanAt(left.Value > right.Value, exp.Token)
+		case "==":
+			return booleanAt(left.Value == right.Value, exp.Token)
+		case "!=":
+			return booleanAt(left.Value != right.Value, exp.Token)
+		}
+
+	case *ast.Boolean:
+		right, ok := exp.Right.(*ast.Boolean)
+		if !ok {
+			return nil
+		}
+
+		switch exp.Operator {
+		case "==":
+			return booleanAt(left.Value == right.Value, exp.Token)
+		case "!=":
+			return booleanAt(left.Value != right.Value, exp.Token)
+		}
+
+	case *ast.StringLiteral:
+		right, ok := exp.Right.(*ast.StringLiteral)
+		if ok && exp.Operator == "+" {
+			return stringAt(left.Value+right.Value, exp.Token)
+		}
+	}
+
+	return nil
+}
+
+// constantAt() returns a copy of a constant placed at the source position of at.
+func constantAt(value ast.Expression, at token.Token) ast.Expression {
+	switch value := value.(type) {
+	case *ast.IntegerLiteral:
+		return integerAt(value.Value, at)
+	case *ast.Boolean:
+		return booleanAt(value.Value, at)
+	case *ast.StringLiteral:
+		return stringAt(value.Value, at)
+	default:
+		return &ast.NullLiteral{Token: token.Token{Literal: "null", Line: at.Line, Column: at.Column}}
+	}
+}
+
+func integerAt(value int64, at token.Token) *ast.IntegerLiteral {
+	t := token.Token{Type: token.INT, Literal: strconv.FormatInt(value, 10), Line: at.Line, Column: at.Column}
+	return &ast.IntegerLiteral{Token: t, Value: value}
+}
+
+func booleanAt(value bool, at token.Token) *ast.Boolean {
+	t := token.Token{Type: token.FALSE, Literal: "false", Line: at.Line, Column: at.Column}
+	if value {
+		t.Type, t.Literal = token.TRUE, "true"
+	}
+	return &ast.Boolean{Token: t, Value: value}
+}
+
+func stringAt(value string, at token.Token) *ast.StringLiteral {
+	t := token.Token{Type: token.STRING, Literal: value, Line: at.Line, Column: at.Column}
+	return &ast.StringLiteral{Token: t, Value: value}
+}
@@ -0,0 +1,93 @@
+package compiler
+
+import (
+	"chui/ast"
+	"chui/code"
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2 * 3;", "7;"},
+		{"(10 - 4) / 3 - -1;", "3;"},
+		{"1 / 0;", "1 / 0;"},
+		{"1 < 2 == true;", "true;"},
+		{"3 > 4 != false;", "false;"},
+		{"!true; !false; !0; !\"\";", "false;\ntrue;\nfalse;\nfalse;"},
+		{`"mon" + "key";`, `"monkey";`},
+		{`"a" + 1;`, `"a" + 1;`},
+		{"x + 1 + 2;", "x + 1 + 2;"},
+		{"if (1 > 2) { x } else { 5 + 5 };", "10;"},
+		{"if (false) { x };", "null;"},
+		{"if (true) { let a = 1; a + x };", "if (true) {\n    let a = 1;\n    1 + x;\n}"},
+		{"let a = 2; let b = a * 3; b + x;", "let a = 2;\nlet b = 6;\n6 + x;"},
+		{"let a = 1; let a = a + 1; a;", "let a = 1;\nlet a = 2;\n2;"},
+		{"let a = 1; let a = x; a;", "let a = 1;\nlet a = x;\na;"},
+		{"let a = 1; if (x) { let a = 2; a }; a;", "let a = 1;\nif (x) {\n    let a = 2;\n    2;\n}\na;"},
+		{"let a = 1; func(a, b = a) { a + b };", "let a = 1;\nfunc(a, b = a) {\n    a + b;\n};"},
+		{"let a = 1; let f = func() { a + 1 };", "let a = 1;\nlet f = func() {\n    2;\n};"},
+		// A pattern binding shadows the constant in its arm. The folder treats it like a let in a branch, so the
+		// name isn't folded in the arms after it either, although the binding itself is only visible in its arm
+		{"let a = 1; match (x) { [a] => a, _ => a };", "let a = 1;\nmatch (x) {\n    [a] => a,\n    _ => a\n}"},
+		{"let e = 1; try { x } catch (e) { e } finally { e };", "let e = 1;\ntry {\n    x;\n} catch (e) {\n    e;\n} finally {\n    e;\n}"},
+		{`let k = "key"; {k: k + "s"};`, `let k = "key";` + "\n" + `{"key": "keys"};`},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		before := ast.Format(program)
+
+		folded := ast.Format(fold(program))
+		if folded != tt.expected+"\n" {
+			t.Errorf("wrong folding of %q.\nwant=\n%s\ngot=\n%s", tt.input, tt.expected, folded)
+		}
+
+		if after := ast.Format(program); after != before {
+			t.Errorf("folding changed the original program %q to\n%s", tt.input, after)
+		}
+	}
+}
+
+func TestCompileFolded(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2",
+			expectedConstants: []interface{}{3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = 10; if (x > 5) { let y = x; y * 2 } else { 0 }",
+			expectedConstants: []interface{}{10, 20},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				// The taken branch, without the condition and jumps
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+		if err := testInstructions(tt.expectedInstructions, bytecode.Instructions); err != nil {
+			t.Fatalf("testInstructions failed for %q: %s", tt.input, err)
+		}
+		if err := testConstants(tt.expectedConstants, bytecode.Constants); err != nil {
+			t.Fatalf("testConstants failed for %q: %s", tt.input, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package compiler
+
+import (
+	"chui/ast"
+	"chui/code"
+	"chui/object"
+	"fmt"
+)
+
+// maxLocals is the most local bindings a function can have, OpGetLocal and OpSetLocal take a single byte operand,
+// two after OpWide.
+const maxLocals = 1 << 16
+
+// compileFunctionLiteral() compiles the function body in its own scope, adds the result to the constant pool
+// and emits an OpClosure that captures the function's free variables.
+//
+// The parameters are the first locals, followed by the rest parameter of a variadic function. The caller
+// checks the argument count and fills those locals in, the function itself only computes defaults:
+// for every parameter with a default the body is prefixed with
+//
+//	OpJumpIfArgPassed <param> <after>
+//	<default>
+//	OpSetLocal <param>
+//	<after>
+func (c *Compiler) compileFunctionLiteral(node *ast.FunctionLiteral) error {
+	c.enterScope()
+
+	if node.Name != "" {
+		c.symbolTable.DefineFunctionName(node.Name)
+	}
+
+	for _, p := range node.Parameters {
+		c.symbolTable.Define(p.Value)
+	}
+
+	if node.Rest != nil {
+		c.symbolTable.Define(node.Rest.Value)
+	}
+
+	numDefaults := 0
+
+	for i, d := range node.Defaults {
+		if d == nil {
+			continue
+		}
+		numDefaults++
+
+		after := c.newBlock()
+		c.branch(after, code.OpJumpIfArgPassed, i)
+
+		err := c.Compile(d)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpSetLocal, i)
+		c.placeBlock(after)
+	}
+
+	err := c.Compile(node.Body)
+	if err != nil {
+		return err
+	}
+
+	if c.lastInstructionIs(code.OpPop) {
+		c.replaceLastPopWithReturn()
+	}
+
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpReturn)
+	}
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	scope := c.leaveScope()
+
+	if numLocals > maxLocals {
+		return fmt.Errorf("too many local bindings in function: %d, the limit is %d",
+			numLocals, maxLocals)
+	}
+
+	for _, s := range freeSymbols {
+		c.loadSymbol(s)
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Name:          node.Name,
+		Instructions:  scope.instructions,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+		NumDefaults:   numDefaults,
+		Variadic:      node.Rest != nil,
+		Handlers:      scope.handlers,
+		NumTrySlots:   scope.numTrySlots,
+		SourceMap:     scope.sourceMap,
+	}
+
+	fnIndex := c.addConstant(compiledFn)
+	c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+
+	return nil
+}
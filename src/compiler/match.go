@@ -0,0 +1,205 @@
+package compiler
+
+import (
+	"chui/ast"
+	"chui/code"
+	"chui/ir"
+	"chui/object"
+	"fmt"
+)
+
+// matchSubjectName is the hidden symbol a match expression stores its subject in.
+// It contains a character the lexer never puts in an identifier, so user code can't refer to it.
+const matchSubjectName = "match#subject"
+
+// valueLoader emits the instructions that push the value a pattern is tested against.
+type valueLoader func() error
+
+// compileMatchExpression() compiles each arm into a chain of tests. A failed test jumps to the next arm,
+// a matching arm evaluates its body and jumps past the remaining arms.
+// When no arm matches, OpNoMatch aborts execution with the subject as the culprit.
+func (c *Compiler) compileMatchExpression(node *ast.MatchExpression) error {
+	err := c.Compile(node.Subject)
+	if err != nil {
+		return err
+	}
+
+	outer := c.symbolTable.snapshot()
+	defer c.symbolTable.restore(outer)
+
+	subject := c.symbolTable.Define(matchSubjectName)
+	c.storeSymbol(subject)
+
+	loadSubject := func() error {
+		c.loadSymbol(subject)
+		return nil
+	}
+
+	end := c.newBlock()
+
+	for _, arm := range node.Arms {
+		nextArm := c.newBlock()
+		beforeArm := c.symbolTable.snapshot()
+
+		err := c.compilePattern(arm.Pattern, loadSubject, nextArm)
+		if err != nil {
+			return err
+		}
+
+		if arm.Guard != nil {
+			err := c.Compile(arm.Guard)
+			if err != nil {
+				return err
+			}
+
+			c.branch(nextArm, code.OpJumpNotTruthy)
+		}
+
+		err = c.Compile(arm.Body)
+		if err != nil {
+			return err
+		}
+
+		c.jump(end)
+		c.placeBlock(nextArm)
+
+		// Bindings made by this arm's pattern are only visible inside the arm
+		c.symbolTable.restore(beforeArm)
+	}
+
+	c.loadSymbol(subject)
+	c.emit(code.OpNoMatch)
+
+	c.placeBlock(end)
+
+	return nil
+}
+
+// compilePattern() emits the tests for a single pattern against the value pushed by load.
+// A failed test jumps to fail, the start of the next arm.
+func (c *Compiler) compilePattern(
+	pattern ast.Expression,
+	load valueLoader,
+	fail *ir.Block,
+) error {
+	switch pattern := pattern.(type) {
+
+	case *ast.Identifier:
+		if pattern.Value == "_" {
+			return nil
+		}
+
+		err := load()
+		if err != nil {
+			return err
+		}
+
+		c.storeSymbol(c.symbolTable.Define(pattern.Value))
+
+	case *ast.ArrayPattern:
+		err := load()
+		if err != nil {
+			return err
+		}
+
+		length := len(pattern.Elements)
+		if pattern.Rest == nil {
+			c.emit(code.OpMatchArray, length)
+		} else {
+			c.emit(code.OpMatchArrayRest, length)
+		}
+		c.branch(fail, code.OpJumpNotTruthy)
+
+		for i, element := range pattern.Elements {
+			loadElement := func() error {
+				err := load()
+				if err != nil {
+					return err
+				}
+
+				index := &object.Integer{Value: int64(i)}
+				c.emit(code.OpConstant, c.addConstant(index))
+				c.emit(code.OpIndex)
+
+				return nil
+			}
+
+			err := c.compilePattern(element, loadElement, fail)
+			if err != nil {
+				return err
+			}
+		}
+
+		if pattern.Rest != nil && pattern.Rest.Value != "_" {
+			err := load()
+			if err != nil {
+				return err
+			}
+
+			c.emit(code.OpArraySlice, length)
+			c.storeSymbol(c.symbolTable.Define(pattern.Rest.Value))
+		}
+
+	case *ast.HashPattern:
+		err := load()
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpMatchHash)
+		c.branch(fail, code.OpJumpNotTruthy)
+
+		for _, pair := range pattern.Pairs {
+			loadEntry := func() error {
+				err := load()
+				if err != nil {
+					return err
+				}
+
+				return c.Compile(pair.Key)
+			}
+
+			err := loadEntry()
+			if err != nil {
+				return err
+			}
+
+			c.emit(code.OpHasKey)
+			c.branch(fail, code.OpJumpNotTruthy)
+
+			loadValue := func() error {
+				err := loadEntry()
+				if err != nil {
+					return err
+				}
+
+				c.emit(code.OpIndex)
+				return nil
+			}
+
+			err = c.compilePattern(pair.Value, loadValue, fail)
+			if err != nil {
+				return err
+			}
+		}
+
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean, *ast.PrefixExpression:
+		err := load()
+		if err != nil {
+			return err
+		}
+
+		err = c.Compile(pattern)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpMatchEqual)
+		c.branch(fail, code.OpJumpNotTruthy)
+
+	default:
+		return fmt.Errorf("unsupported pattern %s", pattern.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package compiler
+
+import (
+	"chui/code"
+	"chui/ir"
+)
+
+// purePushes are the instructions that only push a value, so the value can be dropped
+// along with the instruction when it is popped right away.
+var purePushes = map[code.Opcode]bool{
+	code.OpConstant:       true,
+	code.OpTrue:           true,
+	code.OpFalse:          true,
+	code.OpNull:           true,
+	code.OpGetGlobal:      true,
+	code.OpGetLocal:       true,
+	code.OpGetBuiltin:     true,
+	code.OpGetFree:        true,
+	code.OpCurrentClosure: true,
+}
+
+// peephole() rewrites short sequences of instructions in the blocks of a function into shorter ones, until none is
+// left to rewrite:
+//
+//   - jumps to an empty block, which only jumps on, go straight to where it jumps
+//   - OpTrue followed by OpJumpNotTruthy never jumps and is dropped
+//   - OpFalse or OpNull followed by OpJumpNotTruthy always jumps and is dropped, the block goes on to the branch
+//   - a pure push followed by OpPop is dropped, but only in functions: the value the main program
+//     pops last is its result, which the REPL prints
+//
+// Jumps to the instruction after them need no rewriting, Linearize only adds the jumps it needs. As jumps and
+// handlers only land at the start of a block, a sequence in a block always runs as a whole.
+func peephole(fn *ir.Function, inFunction bool) {
+	for threadJumps(fn) || rewriteBlocks(fn, inFunction) {
+	}
+}
+
+// threadJumps() points the edges to empty blocks at the end of the chain of empty blocks. Falling through to the
+// block laid out next costs nothing, so those edges are left alone, along with the block, which may be a handler's end.
+func threadJumps(fn *ir.Function) bool {
+	changed := false
+
+	follow := func(b *ir.Block) *ir.Block {
+		if b == nil {
+			return nil
+		}
+		return b.Destination()
+	}
+
+	for i, b := range fn.Blocks {
+		fallsThrough := i+1 < len(fn.Blocks) && b.Next == fn.Blocks[i+1]
+		if next := follow(b.Next); next != b.Next && !fallsThrough {
+			b.Next = next
+			changed = true
+		}
+		if branch := follow(b.Branch); branch != b.Branch {
+			b.Branch = branch
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// rewriteBlocks() makes one pass of rewrites over the blocks and reports whether there were any.
+func rewriteBlocks(fn *ir.Function, inFunction bool) bool {
+	changed := false
+
+	for _, b := range fn.Blocks {
+		rewritten := make([]ir.Instruction, 0, len(b.Instructions))
+
+		for i := 0; i < len(b.Instructions); i++ {
+			in := b.Instructions[i]
+			if i+1 < len(b.Instructions) {
+				next := b.Instructions[i+1]
+
+				switch {
+				case in.Op == code.OpTrue && next.Op == code.OpJumpNotTruthy:
+					b.Branch = nil
+					i++
+					changed = true
+					continue
+
+				case (in.Op == code.OpFalse || in.Op == code.OpNull) && next.Op == code.OpJumpNotTruthy:
+					b.Next, b.Branch = b.Branch, nil
+					i++
+					changed = true
+					continue
+
+				case inFunction && purePushes[in.Op] && next.Op == code.OpPop:
+					i++
+					changed = true
+					continue
+				}
+			}
+
+			rewritten = append(rewritten, in)
+		}
+
+		b.Instructions = rewritten
+	}
+
+	return changed
+}
@@ -0,0 +1,203 @@
+package compiler
+
+import (
+	"chui/code"
+	"chui/ir"
+	"chui/object"
+	"reflect"
+	"testing"
+)
+
+func TestPeephole(t *testing.T) {
+	tests := []struct {
+		name       string
+		inFunction bool
+		before     []code.Instructions
+		after      []code.Instructions
+	}{
+		{
+			name: "jump chains are threaded",
+			before: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),      // 0000
+				code.Make(code.OpJumpNotTruthy, 10), // 0003, to a jump
+				code.Make(code.OpNull),              // 0006
+				code.Make(code.OpJump, 13),          // 0007
+				code.Make(code.OpJump, 14),          // 0010
+				code.Make(code.OpPop),               // 0013
+				code.Make(code.OpNull),              // 0014
+			},
+			after: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJumpNotTruthy, 14),
+				code.Make(code.OpNull),
+				code.Make(code.OpJump, 13),
+				code.Make(code.OpJump, 14),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+			},
+		},
+		{
+			name: "a jump to the next instruction is dropped",
+			before: []code.Instructions{
+				code.Make(code.OpConstant, 0), // 0000
+				code.Make(code.OpJump, 6),     // 0003
+				code.Make(code.OpPop),         // 0006
+			},
+			after: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			name: "a condition that always holds never jumps",
+			before: []code.Instructions{
+				code.Make(code.OpTrue),              // 0000
+				code.Make(code.OpJumpNotTruthy, 10), // 0001
+				code.Make(code.OpConstant, 0),       // 0004
+				code.Make(code.OpJump, 13),          // 0007
+				code.Make(code.OpConstant, 1),       // 0010
+				code.Make(code.OpPop),               // 0013
+			},
+			after: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJump, 9),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			name: "a condition that never holds always jumps",
+			before: []code.Instructions{
+				code.Make(code.OpFalse),             // 0000
+				code.Make(code.OpJumpNotTruthy, 10), // 0001
+				code.Make(code.OpConstant, 0),       // 0004
+				code.Make(code.OpJump, 13),          // 0007
+				code.Make(code.OpConstant, 1),       // 0010
+				code.Make(code.OpPop),               // 0013
+			},
+			after: []code.Instructions{
+				code.Make(code.OpJump, 9),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJump, 12),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			name: "the main program keeps its pops, the last one is its result",
+			before: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+			after: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			name:       "pure pushes popped right away are dropped in functions",
+			inFunction: true,
+			before: []code.Instructions{
+				code.Make(code.OpConstant, 0), // 0000
+				code.Make(code.OpPop),         // 0003
+				code.Make(code.OpGetLocal, 0), // 0004
+				code.Make(code.OpCall, 0),     // 0006, calls can't be dropped
+				code.Make(code.OpPop),         // 0008
+				code.Make(code.OpGetLocal, 0), // 0009
+				code.Make(code.OpReturnValue), // 0011
+			},
+			after: []code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			name:       "sequences a jump lands in the middle of are kept",
+			inFunction: true,
+			before: []code.Instructions{
+				code.Make(code.OpGetLocal, 0),      // 0000
+				code.Make(code.OpJumpNotTruthy, 9), // 0002
+				code.Make(code.OpTrue),             // 0005
+				code.Make(code.OpJump, 10),         // 0006
+				code.Make(code.OpFalse),            // 0009
+				code.Make(code.OpJumpNotTruthy, 5), // 0010, to the OpTrue
+				code.Make(code.OpNull),             // 0013
+				code.Make(code.OpPop),              // 0014
+			},
+			after: []code.Instructions{
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpJumpNotTruthy, 9),
+				code.Make(code.OpTrue),
+				code.Make(code.OpJump, 10),
+				code.Make(code.OpFalse),
+				code.Make(code.OpJumpNotTruthy, 5),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		before := concatInstructions(tt.before)
+		instructions, err := code.DecodeInstructions(before)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.name, err)
+		}
+
+		fn, err := ir.Build(instructions, len(before), nil, nil)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.name, err)
+		}
+		peephole(fn, tt.inFunction)
+
+		after, _, _ := fn.Linearize()
+
+		if expected := concatInstructions(tt.after); !reflect.DeepEqual(after, expected) {
+			t.Errorf("%s: wrong instructions.\nbefore=\n%s\nwant=\n%s\ngot=\n%s", tt.name, before, expected, after)
+		}
+	}
+}
+
+func TestPeepholeRelocatesHandlers(t *testing.T) {
+	compiler := New()
+	compiler.SetOptimizations(Optimizations{Peephole: true})
+	err := compiler.Compile(parse("func() { try { 1; throw 2 } catch { 3 } }"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	constants := compiler.Bytecode().Constants
+	fn := constants[len(constants)-1].(*object.CompiledFunction)
+
+	expected := []code.Instructions{
+		// 0000
+		code.Make(code.OpTry, 0),
+		// 0003, the `1;` in the try body is gone
+		code.Make(code.OpConstant, 1),
+		// 0006
+		code.Make(code.OpThrow),
+		// 0007
+		code.Make(code.OpNull),
+		// 0008
+		code.Make(code.OpJump, 15),
+		// 0011, the error isn't bound to a name
+		code.Make(code.OpPop),
+		// 0012
+		code.Make(code.OpConstant, 2),
+		// 0015
+		code.Make(code.OpReturnValue),
+	}
+	if err := testInstructions(expected, fn.Instructions); err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	want := []code.Handler{{Start: 3, End: 8, Target: 11, Slot: 0}}
+	if !reflect.DeepEqual(fn.Handlers, want) {
+		t.Errorf("wrong handlers. want=%v, got=%v", want, fn.Handlers)
+	}
+}
@@ -0,0 +1,233 @@
+package compiler
+
+import (
+	"chui/ast"
+	"chui/code"
+	"chui/ir"
+)
+
+// tryErrorName is the hidden binding the finally handler keeps the error in while the finally block runs.
+// The '#' cannot appear in an identifier, so user code can never refer to it.
+const tryErrorName = "try#error"
+
+// tryContext tracks a try expression while its body and catch clause are compiled, so a return
+// from inside them can close the try's handler ranges and run its finally block first.
+type tryContext struct {
+	finally *ast.BlockStatement
+	guards  []*guard // the try's handler ranges that are still being extended, outermost first
+}
+
+// guard is a handler range of a try expression. A return inlines the finally blocks it leaves,
+// and that code must not be covered by the handlers it leaves, so a guard may be split over
+// several handlers.
+type guard struct {
+	slot    int
+	entries []int // indexes of the guard's handlers in the scope's function
+	open    bool
+}
+
+// compileTryExpression() compiles a try expression. With both a catch and a finally clause the
+// layout is
+//
+//	OpTry <slot>
+//	<body>                 covered by the catch and finally guards
+//	OpJump <finally>
+//	<catch>                covered by the finally guard, binds the error pushed by the VM
+//	<finally>              the normal path, the try's value stays on the stack
+//	OpJump <end>
+//	<finally handler>      stores the error, runs the finally block and throws the error again
+//	<end>
+//
+// OpTry saves the stack depth the handlers reset the stack to before they push the error.
+func (c *Compiler) compileTryExpression(node *ast.TryExpression) error {
+	slot := c.scopes[c.scopeIndex].numTrySlots
+	c.scopes[c.scopeIndex].numTrySlots++
+
+	c.emit(code.OpTry, slot)
+
+	try := &tryContext{finally: node.Finally}
+
+	var finallyGuard, catchGuard *guard
+	if node.Finally != nil {
+		finallyGuard = &guard{slot: slot}
+		c.openGuard(finallyGuard)
+		try.guards = append(try.guards, finallyGuard)
+	}
+	if node.Catch != nil {
+		catchGuard = &guard{slot: slot}
+		c.openGuard(catchGuard)
+		try.guards = append(try.guards, catchGuard)
+	}
+
+	c.scopes[c.scopeIndex].tries = append(c.scopes[c.scopeIndex].tries, try)
+
+	err := c.compileBlockValue(node.Body)
+	if err != nil {
+		return err
+	}
+
+	if catchGuard != nil {
+		c.closeGuard(catchGuard)
+		try.guards = try.guards[:len(try.guards)-1]
+
+		afterCatch := c.newBlock()
+		c.jump(afterCatch)
+
+		c.patchGuard(catchGuard, c.placeBlock(c.newBlock()))
+
+		err := c.compileCatchClause(node)
+		if err != nil {
+			return err
+		}
+
+		c.placeBlock(afterCatch)
+	}
+
+	tries := c.scopes[c.scopeIndex].tries
+	c.scopes[c.scopeIndex].tries = tries[:len(tries)-1]
+
+	if finallyGuard == nil {
+		return nil
+	}
+
+	c.closeGuard(finallyGuard)
+
+	err = c.Compile(node.Finally)
+	if err != nil {
+		return err
+	}
+
+	end := c.newBlock()
+	c.jump(end)
+
+	c.patchGuard(finallyGuard, c.placeBlock(c.newBlock()))
+
+	snapshot := c.symbolTable.snapshot()
+
+	errorSymbol := c.symbolTable.Define(tryErrorName)
+	c.storeSymbol(errorSymbol)
+
+	err = c.Compile(node.Finally)
+	if err != nil {
+		return err
+	}
+
+	c.loadSymbol(errorSymbol)
+	c.emit(code.OpThrow)
+
+	c.symbolTable.restore(snapshot)
+
+	c.placeBlock(end)
+
+	return nil
+}
+
+// compileCatchClause() binds the error the VM pushed to the catch parameter, scoped to the catch block, and compiles the block.
+func (c *Compiler) compileCatchClause(node *ast.TryExpression) error {
+	snapshot := c.symbolTable.snapshot()
+
+	if node.CatchParameter != nil {
+		symbol := c.symbolTable.Define(node.CatchParameter.Value)
+		c.storeSymbol(symbol)
+	} else {
+		c.emit(code.OpPop)
+	}
+
+	err := c.compileBlockValue(node.Catch)
+	if err != nil {
+		return err
+	}
+
+	c.symbolTable.restore(snapshot)
+
+	return nil
+}
+
+// compileBlockValue() compiles a block that produces a value: the value of its last expression statement, or null.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	err := c.Compile(block)
+	if err != nil {
+		return err
+	}
+
+	if n := len(block.Statements); n > 0 {
+		_, isExpression := block.Statements[n-1].(*ast.ExpressionStatement)
+		if isExpression && c.lastInstructionIsPop() {
+			c.removeLastPop()
+			return nil
+		}
+	}
+
+	c.emit(code.OpNull)
+
+	return nil
+}
+
+// exitTries() emits the finally blocks of the try expressions a return leaves, innermost first.
+// The handler ranges of each try are closed before its finally block, so an error raised there
+// is handled by the enclosing try and not by the one being left. It returns the closed guards,
+// which reopenGuards() extends again past the return.
+func (c *Compiler) exitTries() ([]*guard, error) {
+	tries := c.scopes[c.scopeIndex].tries
+	closed := []*guard{}
+
+	for i := len(tries) - 1; i >= 0; i-- {
+		for j := len(tries[i].guards) - 1; j >= 0; j-- {
+			g := tries[i].guards[j]
+			if g.open {
+				c.closeGuard(g)
+				closed = append(closed, g)
+			}
+		}
+
+		if tries[i].finally == nil {
+			continue
+		}
+
+		// A return inside the finally block only leaves the tries enclosing this one
+		c.scopes[c.scopeIndex].tries = append([]*tryContext{}, tries[:i]...)
+		err := c.Compile(tries[i].finally)
+		c.scopes[c.scopeIndex].tries = tries
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return closed, nil
+}
+
+// reopenGuards() starts new ranges for guards closed by exitTries(), outermost first so inner handlers stay after outer ones in the table.
+func (c *Compiler) reopenGuards(closed []*guard) {
+	for i := len(closed) - 1; i >= 0; i-- {
+		c.openGuard(closed[i])
+	}
+}
+
+// openGuard() starts a new handler for g at the next instruction.
+func (c *Compiler) openGuard(g *guard) {
+	start := c.boundary()
+
+	fn := c.scopes[c.scopeIndex].fn
+	fn.Handlers = append(fn.Handlers, ir.Handler{Start: start, End: start, Slot: g.slot})
+
+	g.entries = append(g.entries, len(fn.Handlers)-1)
+	g.open = true
+}
+
+// closeGuard() ends g's current handler at the next instruction.
+func (c *Compiler) closeGuard(g *guard) {
+	if !g.open {
+		return
+	}
+
+	last := g.entries[len(g.entries)-1]
+	c.scopes[c.scopeIndex].fn.Handlers[last].End = c.boundary()
+	g.open = false
+}
+
+// patchGuard() points all of g's handlers at target.
+func (c *Compiler) patchGuard(g *guard, target *ir.Block) {
+	for _, i := range g.entries {
+		c.scopes[c.scopeIndex].fn.Handlers[i].Target = target
+	}
+}
@@ -12,7 +12,18 @@ var (
 	FALSE = &object.Boolean{Value: false}
 )
 
+// Eval() evaluates node in env. An error is tagged with the position of the innermost node it was raised in.
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	result := evalNode(node, env)
+
+	if err, ok := result.(*object.Error); ok && err.Line == 0 {
+		err.Line, err.Column = ast.Position(node)
+	}
+
+	return result
+}
+
+func evalNode(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 
 	// Statements
@@ -32,6 +43,13 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return &object.ReturnValue{Value: val}
 
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		return object.NewThrownError(val)
+
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
 		if isError(val) {
@@ -39,6 +57,15 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		env.Set(node.Name.Value, val)
 
+	case *ast.DestructuringLetStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		if err := bindPattern(node.Pattern, val, env); err != nil {
+			return err
+		}
+
 	// Expressions
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
@@ -46,6 +73,9 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 
+	case *ast.NullLiteral:
+		return NULL
+
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 
@@ -76,9 +106,14 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalIdentifier(node, env)
 
 	case *ast.FunctionLiteral:
-		params := node.Parameters
-		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{
+			Name:       node.Name,
+			Parameters: node.Parameters,
+			Defaults:   node.Defaults,
+			Rest:       node.Rest,
+			Env:        env,
+			Body:       node.Body,
+		}
 
 	case *ast.CallExpression:
 		if node.Function.TokenLiteral() == "quote" {
@@ -95,7 +130,16 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		result := applyFunction(function, args)
+
+		// An error coming out of a function's body has the callee's frame in its trace,
+		// record the position the calling frame was at.
+		if err, ok := result.(*object.Error); ok && len(err.Trace) > 0 {
+			line, column := ast.Position(node)
+			err.Trace = append(err.Trace, object.TraceFrame{Line: line, Column: column})
+		}
+
+		return result
 
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
@@ -118,6 +162,15 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
 
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
+
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+
+	case *ast.MacroLiteral:
+		return newError("macro literal outside of a let statement: %s", node.String())
+
 	}
 
 	return nil
@@ -133,6 +186,9 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 		case *object.ReturnValue:
 			return result.Value
 		case *object.Error:
+			if n := len(result.Trace); n > 0 && result.Trace[n-1].Function == "" {
+				result.Trace[n-1].Function = object.MAIN_FUNCTION
+			}
 			return result
 		}
 	}
@@ -237,6 +293,9 @@ func evalIntegerInfixExpression(
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
 		return &object.Integer{Value: leftVal / rightVal}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
@@ -344,29 +403,111 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		if err := checkArity(fn, len(args)); err != nil {
+			return err
+		}
+
+		extendedEnv, err := extendFunctionEnv(fn, args)
+		if err != nil {
+			return traceCall(err, fn)
+		}
+
+		evaluated := unwrapReturnValue(Eval(fn.Body, extendedEnv))
+		if err, ok := evaluated.(*object.Error); ok {
+			return traceCall(err, fn)
+		}
+		return evaluated
 
 	case *object.Builtin:
-		return fn.Fn(args...)
+		if result := fn.Fn(args...); result != nil {
+			return result
+		}
+		return NULL
 
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
+// traceCall() adds the frame of fn to the stack trace of an error raised while fn was running. If the error
+// was raised in a function fn called, the call expression already added fn's frame with its position.
+func traceCall(err *object.Error, fn *object.Function) *object.Error {
+	name := fn.Name
+	if name == "" {
+		name = object.ANONYMOUS_FUNCTION
+	}
+
+	if len(err.Trace) == 0 {
+		err.Trace = append(err.Trace, object.TraceFrame{Function: name, Line: err.Line, Column: err.Column})
+	} else {
+		err.Trace[len(err.Trace)-1].Function = name
+	}
+
+	return err
+}
+
+// extendFunctionEnv binds the arguments of a call to the function's parameters.
+// Missing arguments take their default, which is evaluated in the new environment
+// so it can refer to earlier parameters. Extra arguments go to the rest parameter.
+// The caller has already checked the number of arguments with checkArity.
 func extendFunctionEnv(
 	fn *object.Function,
 	args []object.Object,
-) *object.Environment {
+) (*object.Environment, *object.Error) {
 	env := object.NewEnclosedEnvironment(fn.Env)
 
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		if paramIdx < len(args) {
+			env.Set(param.Value, args[paramIdx])
+			continue
+		}
+
+		value := Eval(fn.Defaults[paramIdx], env)
+		if isError(value) {
+			return nil, value.(*object.Error)
+		}
+		env.Set(param.Value, value)
+	}
+
+	if fn.Rest != nil {
+		rest := []object.Object{}
+		if len(args) > len(fn.Parameters) {
+			rest = append(rest, args[len(fn.Parameters):]...)
+		}
+		env.Set(fn.Rest.Value, &object.Array{Elements: rest})
+	}
+
+	return env, nil
+}
+
+// checkArity returns an error when a function can't be called with numArgs arguments.
+func checkArity(fn *object.Function, numArgs int) *object.Error {
+	required := len(fn.Parameters)
+	for i := range fn.Parameters {
+		if fn.Defaults != nil && fn.Defaults[i] != nil {
+			required = i
+			break
+		}
+	}
+
+	if numArgs >= required && (fn.Rest != nil || numArgs <= len(fn.Parameters)) {
+		return nil
 	}
 
-	return env
+	return newError("wrong number of arguments. got=%d, want=%s",
+		numArgs, arityString(required, len(fn.Parameters), fn.Rest != nil))
+}
+
+// arityString describes how many arguments a function accepts, e.g. "2", "1 to 3" or "at least 1".
+func arityString(required, total int, variadic bool) string {
+	switch {
+	case variadic:
+		return fmt.Sprintf("at least %d", required)
+	case required == total:
+		return fmt.Sprintf("%d", required)
+	default:
+		return fmt.Sprintf("%d to %d", required, total)
+	}
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
@@ -444,3 +585,260 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 
 	return pair.Value
 }
+
+func evalMatchExpression(
+	me *ast.MatchExpression,
+	env *object.Environment,
+) object.Object {
+	subject := Eval(me.Subject, env)
+	if isError(subject) {
+		return subject
+	}
+
+	for _, arm := range me.Arms {
+		armEnv := object.NewEnclosedEnvironment(env)
+
+		matched, err := matchPattern(arm.Pattern, subject, armEnv)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
+
+		if arm.Guard != nil {
+			guard := Eval(arm.Guard, armEnv)
+			if isError(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+
+		return Eval(arm.Body, armEnv)
+	}
+
+	return newError("no match arm matched value: %s", subject.Inspect())
+}
+
+// matchPattern reports whether value matches pattern, binding any identifiers
+// in the pattern into env as it goes.
+func matchPattern(
+	pattern ast.Expression,
+	value object.Object,
+	env *object.Environment,
+) (bool, *object.Error) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			env.Set(pattern.Value, value)
+		}
+		return true, nil
+
+	case *ast.ArrayPattern:
+		array, ok := value.(*object.Array)
+		if !ok {
+			return false, nil
+		}
+
+		length := len(pattern.Elements)
+		if len(array.Elements) < length ||
+			(pattern.Rest == nil && len(array.Elements) != length) {
+			return false, nil
+		}
+
+		for i, element := range pattern.Elements {
+			matched, err := matchPattern(element, array.Elements[i], env)
+			if err != nil || !matched {
+				return matched, err
+			}
+		}
+
+		if pattern.Rest != nil {
+			matchPattern(pattern.Rest, sliceArray(array, length), env)
+		}
+
+		return true, nil
+
+	case *ast.HashPattern:
+		hash, ok := value.(*object.Hash)
+		if !ok {
+			return false, nil
+		}
+
+		for _, pair := range pattern.Pairs {
+			key := Eval(pair.Key, env)
+			if isError(key) {
+				return false, key.(*object.Error)
+			}
+
+			hashKey, ok := key.(object.Hashable)
+			if !ok {
+				return false, newError("unusable as hash key: %s", key.Type())
+			}
+
+			entry, ok := hash.Pairs[hashKey.HashKey()]
+			if !ok {
+				return false, nil
+			}
+
+			matched, err := matchPattern(pair.Value, entry.Value, env)
+			if err != nil || !matched {
+				return matched, err
+			}
+		}
+
+		return true, nil
+
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean, *ast.PrefixExpression:
+		literal := Eval(pattern, env)
+		if isError(literal) {
+			return false, literal.(*object.Error)
+		}
+		return literalsEqual(literal, value), nil
+
+	default:
+		return false, newError("unsupported pattern: %s", pattern.String())
+	}
+}
+
+// bindPattern destructures value into the identifiers of a let pattern. Unlike
+// matchPattern it never fails to match: missing elements are bound to null, or
+// to their default when one is given.
+func bindPattern(
+	pattern ast.Expression,
+	value object.Object,
+	env *object.Environment,
+) *object.Error {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			env.Set(pattern.Value, value)
+		}
+		return nil
+
+	case *ast.DefaultPattern:
+		if value == NULL {
+			value = Eval(pattern.Default, env)
+			if isError(value) {
+				return value.(*object.Error)
+			}
+		}
+		return bindPattern(pattern.Target, value, env)
+
+	case *ast.ArrayPattern:
+		for i, element := range pattern.Elements {
+			entry := evalIndexExpression(value, &object.Integer{Value: int64(i)})
+			if isError(entry) {
+				return entry.(*object.Error)
+			}
+
+			if err := bindPattern(element, entry, env); err != nil {
+				return err
+			}
+		}
+
+		if pattern.Rest != nil {
+			rest := sliceArray(value, len(pattern.Elements))
+			if isError(rest) {
+				return rest.(*object.Error)
+			}
+			return bindPattern(pattern.Rest, rest, env)
+		}
+
+		return nil
+
+	case *ast.HashPattern:
+		for _, pair := range pattern.Pairs {
+			key := Eval(pair.Key, env)
+			if isError(key) {
+				return key.(*object.Error)
+			}
+
+			entry := evalIndexExpression(value, key)
+			if isError(entry) {
+				return entry.(*object.Error)
+			}
+
+			if err := bindPattern(pair.Value, entry, env); err != nil {
+				return err
+			}
+		}
+
+		return nil
+
+	default:
+		return newError("unsupported pattern in let: %s", pattern.String())
+	}
+}
+
+// sliceArray returns a new array holding the elements of array from start on.
+func sliceArray(array object.Object, start int) object.Object {
+	arrayObject, ok := array.(*object.Array)
+	if !ok {
+		return newError("cannot slice %s", array.Type())
+	}
+
+	if start > len(arrayObject.Elements) {
+		start = len(arrayObject.Elements)
+	}
+
+	elements := make([]object.Object, len(arrayObject.Elements)-start)
+	copy(elements, arrayObject.Elements[start:])
+
+	return &object.Array{Elements: elements}
+}
+
+// literalsEqual compares two scalar objects by value. Objects of different
+// types are never equal.
+func literalsEqual(left, right object.Object) bool {
+	switch left := left.(type) {
+	case *object.Integer:
+		right, ok := right.(*object.Integer)
+		return ok && left.Value == right.Value
+	case *object.String:
+		right, ok := right.(*object.String)
+		return ok && left.Value == right.Value
+	case *object.Boolean:
+		right, ok := right.(*object.Boolean)
+		return ok && left.Value == right.Value
+	default:
+		return false
+	}
+}
+
+// evalTryExpression() evaluates the try block, falling back to the catch block with the error bound
+// to the catch parameter if it fails. The finally block runs last whatever happened, an error or a
+// return from it takes precedence over the result of the other blocks.
+func evalTryExpression(
+	te *ast.TryExpression,
+	env *object.Environment,
+) object.Object {
+	result := Eval(te.Body, env)
+
+	if err, ok := result.(*object.Error); ok && te.Catch != nil {
+		catchEnv := object.NewEnclosedEnvironment(env)
+		if te.CatchParameter != nil {
+			catchEnv.Set(te.CatchParameter.Value, err.ToHash())
+		}
+
+		result = Eval(te.Catch, catchEnv)
+	}
+
+	if te.Finally != nil {
+		finallyResult := Eval(te.Finally, env)
+		if finallyResult != nil {
+			rt := finallyResult.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+				return finallyResult
+			}
+		}
+	}
+
+	if result == nil {
+		return NULL
+	}
+
+	return result
+}
@@ -221,6 +221,58 @@ if (10 > 1) {
 			`999[1]`,
 			"index operator not supported: INTEGER",
 		},
+		{
+			`match (5) { 1 => 1, [a] => a }`,
+			"no match arm matched value: 5",
+		},
+		{
+			`match (5) { x if x + true => x }`,
+			"type mismatch: INTEGER + BOOLEAN",
+		},
+		{
+			`let [a, b] = 5;`,
+			"index operator not supported: INTEGER",
+		},
+		{
+			`let [...rest] = 5;`,
+			"cannot slice INTEGER",
+		},
+		{
+			`let [1, a] = [1, 2];`,
+			"unsupported pattern in let: 1",
+		},
+		{
+			`func(a, b) { a + b }(1)`,
+			"wrong number of arguments. got=1, want=2",
+		},
+		{
+			`func(a) { a }(1, 2)`,
+			"wrong number of arguments. got=2, want=1",
+		},
+		{
+			`func(a, b = 2) { a + b }()`,
+			"wrong number of arguments. got=0, want=1 to 2",
+		},
+		{
+			`func(a, ...rest) { a }()`,
+			"wrong number of arguments. got=0, want=at least 1",
+		},
+		{
+			`func(a, b = a + true) { b }(1)`,
+			"type mismatch: INTEGER + BOOLEAN",
+		},
+		{
+			`throw "oops"`,
+			"oops",
+		},
+		{
+			`let m = macro(x) { x };`,
+			"macro literal outside of a let statement: macro(x) x",
+		},
+		{
+			`try { throw "oops" } finally { 1 }`,
+			"oops",
+		},
 	}
 
 	for _, tt := range tests {
@@ -299,6 +351,29 @@ func TestFunctionApplication(t *testing.T) {
 	}
 }
 
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let add = func(a, b = 10) { a + b }; add(1);", 11},
+		{"let add = func(a, b = 10) { a + b }; add(1, 2);", 3},
+		{"let add = func(a = 1, b = a * 2) { a + b }; add();", 3},
+		{"let add = func(a = 1, b = a * 2) { a + b }; add(5);", 15},
+		{"let x = 100; let f = func(a = x) { a }; f();", 100},
+		{"let count = func(...rest) { len(rest) }; count();", 0},
+		{"let count = func(...rest) { len(rest) }; count(1, 2, 3);", 3},
+		{"let f = func(a, ...rest) { a + len(rest) }; f(10);", 10},
+		{"let f = func(a, ...rest) { a + rest[0] + rest[1] }; f(1, 2, 3);", 6},
+		{"let f = func(a, b = 2, ...rest) { a + b + len(rest) }; f(1);", 3},
+		{"let f = func(a, b = 2, ...rest) { a + b + len(rest) }; f(1, 5, 7, 7);", 8},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestEnclosingEnvironments(t *testing.T) {
 	input := `
 let first = 10;
@@ -379,6 +454,8 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`rest([])`, nil},
 		{`push([], 1)`, []int{1}},
 		{`push(1, 1)`, "argument to `push` must be ARRAY, got INTEGER"},
+		{`gensym(1)`, "argument to `gensym` must be STRING, got INTEGER"},
+		{`gensym("a", "b")`, "wrong number of arguments. got=2, want=0 or 1"},
 	}
 
 	for _, tt := range tests {
@@ -582,6 +659,212 @@ func TestHashIndexExpressions(t *testing.T) {
 		}
 	}
 }
+func TestMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`match (0) { 0 => 1, _ => 2 }`, 1},
+		{`match (5) { 0 => 1, _ => 2 }`, 2},
+		{`match (-3) { -3 => 1, _ => 2 }`, 1},
+		{`match ("chui") { "monkey" => 1, "chui" => 2 }`, 2},
+		{`match (true) { false => 1, true => 2 }`, 2},
+		{`match (7) { x => x * 2 }`, 14},
+		{`match ([1, 2, 3]) { [a, b] => 0, [a, b, c] => a + b + c }`, 6},
+		{`match ([1, 2, 3]) { [first, ...rest] => first + len(rest) }`, 3},
+		{`match ([1]) { [first, ...rest] => len(rest) }`, 0},
+		{`match ([]) { [first, ...rest] => 1, [] => 2 }`, 2},
+		{`match ([[1, 2], 3]) { [[a, b], c] => a * b * c }`, 6},
+		{`match ([1, 2]) { [1, x] => x, _ => 0 }`, 2},
+		{`match ([3, 2]) { [1, x] => x, _ => 0 }`, 0},
+		{`match ({"name": 5, "age": 3}) { {"name": n} => n }`, 5},
+		{`match ({"age": 3}) { {"name": n} => n, {"age": a} => a }`, 3},
+		{`match ({1: [4, 5]}) { {1: [x, y]} => x + y }`, 9},
+		{`match (5) { {"name": n} => n, [x] => x, _ => 0 }`, 0},
+		{`match (10) { x if x < 5 => 1, x if x > 5 => 2 }`, 2},
+		{`match (3) { x if x < 5 => 1, x if x > 5 => 2 }`, 1},
+		{`match ([1, 2]) { [a, b] if a > b => 1, [a, b] => 2 }`, 2},
+		{`let x = 1; match (5) { x => x }; x`, 1},
+		{`match (1) { 2 => 3, x if x == 2 => 4, _ => if (false) { 5 } }`, nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestDestructuringLetStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let [a, b] = [1, 2]; a;", 1},
+		{"let [a, b] = [1, 2]; b;", 2},
+		{"let [a, b] = [1]; b;", nil},
+		{"let [a, b = 10] = [1]; b;", 10},
+		{"let [a, b = 10] = [1, 2]; b;", 2},
+		{"let [a, ...rest] = [1, 2, 3]; len(rest);", 2},
+		{"let [a, b, ...rest] = [1]; len(rest);", 0},
+		{"let [[a, b], c] = [[1, 2], 3]; a + b + c;", 6},
+		{"let [_, b] = [1, 2]; b;", 2},
+		{`let {name, age} = {"name": 5, "age": 3}; name * age;`, 15},
+		{`let {name, age} = {"name": 5}; age;`, nil},
+		{`let {name, age = 7} = {"name": 5}; age;`, 7},
+		{`let {"tags": [first, second = first * 2]} = {"tags": [4]}; second;`, 8},
+		{`let {"tags": [t] = [9]} = {}; t;`, 9},
+		{`let x = 2; let [a = x * 3] = []; a;`, 6},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"try { 1 } catch (e) { 2 }", 1},
+		{"try { throw 1; 2 } catch (e) { 3 }", 3},
+		{`try { throw "boom"; 1 } catch (e) { e["message"] }`, "boom"},
+		{`try { throw "boom" } catch (e) { e["kind"] }`, "Error"},
+		{`try { 1 + true } catch (e) { e["kind"] }`, "RuntimeError"},
+		{`try { 1 / 0 } catch (e) { e["message"] }`, "division by zero"},
+		{`let zero = 0; try { 5 / zero } catch (e) { e["kind"] }`, "RuntimeError"},
+		{`try { throw {"message": "bad", "kind": "ValueError"} } catch (e) { e["kind"] }`, "ValueError"},
+		{`try { throw {"message": "bad", "code": 7} } catch (e) { e["code"] }`, 7},
+		{`try { throw "x" } catch { 5 }`, 5},
+		{`try { } catch (e) { 1 }`, nil},
+		{`let f = func() { throw "inner" }; try { f() } catch (e) { e["message"] }`, "inner"},
+		{`try { try { throw "a" } catch (e) { throw e["message"] + "b" } } catch (e) { e["message"] }`, "ab"},
+		{`let g = func(n) { if (n == 0) { throw "deep" } g(n - 1) }; try { g(5) } catch (e) { e["message"] }`, "deep"},
+		{`let f = func() { throw "x" }; 1 + try { 2 + f() } catch (e) { 10 }`, 11},
+		{`let e = 1; try { throw "x" } catch (e) { 2 }; e`, 1},
+		{"try { 1 } finally { 2 }", 1},
+		{`try { try { throw "x" } finally { 1 } } catch (e) { e["message"] }`, "x"},
+		{`try { try { throw "x" } finally { throw "y" } } catch (e) { e["message"] }`, "y"},
+		{`try { try { throw "x" } catch (e) { throw "y" } finally { 1 } } catch (e) { e["message"] }`, "y"},
+		{"let f = func() { try { return 1 } finally { 5 } }; f()", 1},
+		{"let f = func() { try { return 1 } finally { return 2 } }; f()", 2},
+		{`let f = func() { try { throw "x" } catch (e) { return 3 } finally { 4 } }; f()`, 3},
+		{`let f = func() { try { try { return 1 } finally { throw "y" } } catch (e) { return e["message"] } }; f()`, "y"},
+		{"try {\n  1 + true\n} catch (e) { e[\"line\"] * 100 + e[\"column\"] }", 205},
+		{"try {\n  throw \"x\"\n} catch (e) { e[\"line\"] * 100 + e[\"column\"] }", 203},
+		{"try { try {\n throw \"x\" } catch (e) { throw e } } catch (e) { e[\"line\"] }", 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. got=%q, want=%q", str.Value, expected)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedLine   int
+		expectedColumn int
+	}{
+		{"let a = 1;\nlet b = a + true;", 2, 11},
+		{"let f = func() {\n  foo\n};\nf()", 2, 3},
+		{"\n\n   throw \"x\"", 3, 4},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Line != tt.expectedLine || errObj.Column != tt.expectedColumn {
+			t.Errorf("wrong error position for %q. want=%d:%d, got=%d:%d",
+				tt.input, tt.expectedLine, tt.expectedColumn, errObj.Line, errObj.Column)
+		}
+	}
+}
+
+func TestStackTraces(t *testing.T) {
+	tests := []struct {
+		input             string
+		expectedTraceback string
+	}{
+		{
+			"let f = func(x) {\n  throw \"boom\"\n};\nlet g = func() { f(1) };\ng();",
+			`Traceback (most recent call last):
+  File "test", line 5, column 2, in <main>
+  File "test", line 4, column 19, in g
+  File "test", line 2, column 3, in f
+Error: boom`,
+		},
+		{
+			"let f = func(a, b) { a };\nlet g = func() {\n  f(1)\n};\ng()",
+			`Traceback (most recent call last):
+  File "test", line 5, column 2, in <main>
+  File "test", line 3, column 4, in g
+RuntimeError: wrong number of arguments. got=1, want=2`,
+		},
+		{
+			"func() { throw \"x\" }()",
+			`Traceback (most recent call last):
+  File "test", line 1, column 21, in <main>
+  File "test", line 1, column 10, in <anonymous>
+Error: x`,
+		},
+		{
+			"let f = func(a = len(1)) { a };\nf()",
+			`Traceback (most recent call last):
+  File "test", line 2, column 2, in <main>
+  File "test", line 1, column 21, in f
+RuntimeError: argument to ` + "`len`" + ` not supported, got INTEGER`,
+		},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if traceback := errObj.Traceback("test"); traceback != tt.expectedTraceback {
+			t.Errorf("wrong traceback.\nwant=\n%s\ngot=\n%s", tt.expectedTraceback, traceback)
+		}
+	}
+}
+
 func testEval(input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
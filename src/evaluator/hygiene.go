@@ -0,0 +1,177 @@
+package evaluator
+
+import (
+	"chui/ast"
+	"chui/object"
+)
+
+// renameLetBindings gives every name bound by a let statement in quoted code a fresh
+// name made by object.Gensym, and renames its uses in the quoted code to match. Code
+// spliced in by a macro then can't capture names at the call site, nor be captured by
+// them. A binding's uses are the identifiers after the let in the same block, and in
+// the function the let binds when it recurses, unless a parameter, catch parameter or
+// match pattern shadows the name. The arguments of unquote and unquote_splicing calls
+// come from the call site and are left alone.
+func renameLetBindings(quoted ast.Node) {
+	switch quoted := quoted.(type) {
+	case *ast.Program:
+		renameStatements(quoted.Statements, nil)
+	case ast.Statement:
+		renameStatements([]ast.Statement{quoted}, nil)
+	default:
+		renameNode(quoted, nil)
+	}
+}
+
+// renames maps a name in scope to its fresh name, or to "" when a binding that is not
+// renamed shadows it.
+type renames map[string]string
+
+// with returns a copy of r with the names bound to fresh, leaving r alone.
+func (r renames) with(names []string, fresh func(string) string) renames {
+	scope := renames{}
+	for name, renamed := range r {
+		scope[name] = renamed
+	}
+	for _, name := range names {
+		scope[name] = fresh(name)
+	}
+	return scope
+}
+
+// shadowed is the fresh name of a binding that keeps its name.
+func shadowed(string) string { return "" }
+
+// renameStatements renames the statements of a block in order, each let statement
+// renaming the name it binds in the statements after it.
+func renameStatements(statements []ast.Statement, scope renames) {
+	for _, statement := range statements {
+		switch statement := statement.(type) {
+		case *ast.LetStatement:
+			fresh := object.Gensym(statement.Name.Value)
+
+			// A function bound by the let refers to itself by the name
+			if fn, ok := statement.Value.(*ast.FunctionLiteral); ok && fn.Name == statement.Name.Value {
+				fn.Name = fresh
+				renameNode(fn, scope.with([]string{statement.Name.Value}, func(string) string { return fresh }))
+			} else {
+				renameNode(statement.Value, scope)
+			}
+
+			scope = scope.with([]string{statement.Name.Value}, func(string) string { return fresh })
+			renameIdentifier(statement.Name, scope)
+
+		case *ast.DestructuringLetStatement:
+			renameNode(statement.Value, scope)
+
+			scope = scope.with(patternNames(statement.Pattern), object.Gensym)
+			renameNode(statement.Pattern, scope)
+
+		default:
+			renameNode(statement, scope)
+		}
+	}
+}
+
+// renameNode renames the uses of the names in scope below node, and the bindings of the
+// let statements in its blocks.
+func renameNode(node ast.Node, scope renames) {
+	if node == nil || isUnquoteCall(node) || isUnquoteSplicingCall(node) {
+		return
+	}
+
+	switch node := node.(type) {
+	case *ast.Identifier:
+		renameIdentifier(node, scope)
+
+	case *ast.BlockStatement:
+		renameStatements(node.Statements, scope)
+
+	case *ast.FunctionLiteral:
+		inner := scope.with(parameterNames(node.Parameters, node.Rest), shadowed)
+		for _, def := range node.Defaults {
+			renameNode(def, inner)
+		}
+		renameNode(node.Body, inner)
+
+	case *ast.MacroLiteral:
+		renameNode(node.Body, scope.with(parameterNames(node.Parameters, node.Rest), shadowed))
+
+	case *ast.MatchArm:
+		// The names the pattern binds are matched, so only the defaults in it are renamed
+		inner := scope.with(patternNames(node.Pattern), shadowed)
+		renameNode(node.Pattern, inner)
+		renameNode(node.Guard, inner)
+		renameNode(node.Body, inner)
+
+	case *ast.TryExpression:
+		// The blocks are typed pointers, a missing one isn't a nil node
+		if node.Body != nil {
+			renameNode(node.Body, scope)
+		}
+		if node.Catch != nil {
+			var names []string
+			if node.CatchParameter != nil {
+				names = append(names, node.CatchParameter.Value)
+			}
+			renameNode(node.Catch, scope.with(names, shadowed))
+		}
+		if node.Finally != nil {
+			renameNode(node.Finally, scope)
+		}
+
+	default:
+		for _, child := range ast.Children(node) {
+			renameNode(child, scope)
+		}
+	}
+}
+
+func renameIdentifier(ident *ast.Identifier, scope renames) {
+	if fresh := scope[ident.Value]; fresh != "" {
+		ident.Value = fresh
+		ident.Token.Literal = fresh
+	}
+}
+
+// parameterNames returns the names the parameters of a function or macro bind.
+func parameterNames(parameters []*ast.Identifier, rest *ast.Identifier) []string {
+	names := []string{}
+	for _, param := range parameters {
+		names = append(names, param.Value)
+	}
+	if rest != nil {
+		names = append(names, rest.Value)
+	}
+	return names
+}
+
+// patternNames returns the names a destructuring pattern binds.
+func patternNames(pattern ast.Expression) []string {
+	names := []string{}
+
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if pattern.Value != "_" {
+			names = append(names, pattern.Value)
+		}
+
+	case *ast.DefaultPattern:
+		names = append(names, patternNames(pattern.Target)...)
+
+	case *ast.ArrayPattern:
+		for _, element := range pattern.Elements {
+			names = append(names, patternNames(element)...)
+		}
+		if pattern.Rest != nil {
+			names = append(names, patternNames(pattern.Rest)...)
+		}
+
+	case *ast.HashPattern:
+		for _, pair := range pattern.Pairs {
+			names = append(names, patternNames(pair.Value)...)
+		}
+	}
+
+	return names
+}
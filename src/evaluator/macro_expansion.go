@@ -3,25 +3,47 @@ package evaluator
 import (
 	"chui/ast"
 	"chui/object"
+	"fmt"
+	"strings"
 )
 
+// MacroError describes a macro call that could not be expanded.
+type MacroError struct {
+	Macro  string // the name the macro was called by
+	Line   int    // position of the call site, 0 if unknown
+	Column int
+	Reason string
+}
+
+func (e *MacroError) Error() string {
+	return fmt.Sprintf("line %d, column %d: macro %s: %s", e.Line, e.Column, e.Macro, e.Reason)
+}
+
+// DefineMacros adds the macros defined by the top-level let statements of program to env
+// and removes their definitions from program. ExpandMacros handles definitions too, this
+// lets the REPL keep the macros of one line for the lines that follow.
 func DefineMacros(program *ast.Program, env *object.Environment) {
-	definitions := []int{}
+	program.Statements = defineMacrosIn(program.Statements, env)
+}
+
+// defineMacrosIn adds the macros defined by statements to env and returns the other statements.
+func defineMacrosIn(statements []ast.Statement, env *object.Environment) []ast.Statement {
+	remaining := []ast.Statement{}
 
-	for i, statement := range program.Statements {
+	for _, statement := range statements {
 		if isMacroDefinition(statement) {
 			addMacro(statement, env)
-			definitions = append(definitions, i)
+			continue
 		}
+
+		remaining = append(remaining, statement)
 	}
 
-	for i := len(definitions) - 1; i >= 0; i = i - 1 {
-		definitionIndex := definitions[i]
-		program.Statements = append(
-			program.Statements[:definitionIndex],
-			program.Statements[definitionIndex+1:]...,
-		)
+	if len(remaining) == len(statements) {
+		return statements
 	}
+
+	return remaining
 }
 
 func isMacroDefinition(node ast.Statement) bool {
@@ -44,6 +66,7 @@ func addMacro(stmt ast.Statement, env *object.Environment) {
 
 	macro := &object.Macro{
 		Parameters: macroLiteral.Parameters,
+		Rest:       macroLiteral.Rest,
 		Env:        env,
 		Body:       macroLiteral.Body,
 	}
@@ -51,30 +74,197 @@ func addMacro(stmt ast.Statement, env *object.Environment) {
 	env.Set(letStatement.Name.Value, macro)
 }
 
-func ExpandMacros(program ast.Node, env *object.Environment) ast.Node {
-	return ast.Modify(program, func(node ast.Node) ast.Node {
+// maxExpansionDepth limits how deeply macro calls in the expansions of other macro calls
+// are expanded, to catch a macro that keeps expanding to calls of itself.
+const maxExpansionDepth = 100
+
+// ExpandMacros replaces every macro call in program with the AST its macro returns, and
+// expands the macro calls in that AST in turn, until none are left. Macros defined at the
+// top level of program are added to env, macros defined in a block can be called in that
+// block and the blocks nested in it. A call that can't be expanded is left as is and
+// reported in the returned errors.
+func ExpandMacros(program ast.Node, env *object.Environment) (ast.Node, []*MacroError) {
+	return ExpandMacrosWithTrace(program, env, nil)
+}
+
+// ExpansionStep describes a single macro call expanded by ExpandMacrosWithTrace.
+type ExpansionStep struct {
+	Macro     string // the name the macro was called by
+	Line      int    // position of the call site, 0 if unknown
+	Column    int
+	Depth     int                 // 0 for calls in the program, 1 for calls in their expansions, and so on
+	Call      *ast.CallExpression // copy of the call, with the macro calls in its arguments expanded
+	Expansion ast.Node            // copy of what the macro returned, before the calls in it are expanded
+}
+
+// String prints the step as the call site followed by the formatted call and expansion.
+func (s ExpansionStep) String() string {
+	return fmt.Sprintf("line %d, column %d: macro %s, depth %d\n%s\nexpands to\n%s",
+		s.Line, s.Column, s.Macro, s.Depth, indentLines(ast.Format(s.Call)), indentLines(ast.Format(s.Expansion)))
+}
+
+func indentLines(text string) string {
+	return "    " + strings.ReplaceAll(strings.TrimRight(text, "\n"), "\n", "\n    ")
+}
+
+// ExpandMacrosWithTrace works like ExpandMacros and calls trace, unless it is nil, for
+// every macro call it expands, in the order they are expanded: the calls in the
+// arguments of a macro call come before the call, the calls in its expansion after it.
+func ExpandMacrosWithTrace(
+	program ast.Node,
+	env *object.Environment,
+	trace func(ExpansionStep),
+) (ast.Node, []*MacroError) {
+	e := &expander{trace: trace}
+	expanded := e.expand(program, env, 0)
+
+	return expanded, e.errors
+}
+
+// expander collects the errors of an ExpandMacros run.
+type expander struct {
+	errors  []*MacroError
+	tooDeep bool // set once a call exceeds maxExpansionDepth, after which nothing is expanded
+	trace   func(ExpansionStep)
+}
+
+// expand defines the macros in node and expands the macro calls in it, depth being the
+// number of expansions node is nested in.
+func (e *expander) expand(node ast.Node, env *object.Environment, depth int) ast.Node {
+	scopes := defineScopedMacros(node, env)
+
+	expanded, err := ast.Modify(node, func(node ast.Node) ast.Node {
 		callExpression, ok := node.(*ast.CallExpression)
+		if !ok || e.tooDeep {
+			return node
+		}
+
+		// Calls from expansions below this one are not in scopes, they have been expanded already
+		scope, ok := scopes[callExpression]
 		if !ok {
 			return node
 		}
 
-		macro, ok := isMacroCall(callExpression, env)
+		macro, ok := isMacroCall(callExpression, scope)
 		if !ok {
 			return node
 		}
 
-		args := quoteArgs(callExpression)
-		evalEnv := extendMacroEnv(macro, args)
+		if depth >= maxExpansionDepth {
+			e.tooDeep = true
+			e.errors = append(e.errors, newMacroError(callExpression,
+				fmt.Sprintf("expansion nested more than %d levels deep, does the macro expand to a call of itself?",
+					maxExpansionDepth)))
+			return node
+		}
 
-		evaluated := Eval(macro.Body, evalEnv)
+		expansion, err := expandMacroCall(callExpression, macro)
+		if err != nil {
+			e.errors = append(e.errors, err)
+			return node
+		}
 
-		quote, ok := evaluated.(*object.Quote)
-		if !ok {
-			panic("we only support returning AST-nodes from macros")
+		if e.trace != nil {
+			line, column := ast.Position(callExpression.Function)
+			e.trace(ExpansionStep{
+				Macro:     callExpression.Function.String(),
+				Line:      line,
+				Column:    column,
+				Depth:     depth,
+				Call:      ast.Clone(callExpression).(*ast.CallExpression),
+				Expansion: ast.Clone(expansion),
+			})
 		}
 
-		return quote.Node
+		return e.expand(expansion, scope, depth+1)
 	})
+	if err != nil {
+		// Only macro calls are replaced, so the node that didn't fit is one of them
+		if modifyErr, ok := err.(*ast.ModifyError); ok {
+			if call, ok := modifyErr.Original.(*ast.CallExpression); ok {
+				e.errors = append(e.errors, newMacroError(call, err.Error()))
+				return node
+			}
+		}
+		e.errors = append(e.errors, &MacroError{Reason: err.Error()})
+		return node
+	}
+
+	return expanded
+}
+
+// defineScopedMacros adds the macros defined in node to the environment of the block they
+// are defined in, enclosed by the environment of the enclosing block, and removes their
+// definitions. The statements of a program at the root of node share env. It returns the
+// environment of the innermost block around every call in node.
+func defineScopedMacros(node ast.Node, env *object.Environment) map[*ast.CallExpression]*object.Environment {
+	scopes := map[*ast.CallExpression]*object.Environment{}
+	envs := []*object.Environment{env}
+
+	ast.Walk(node, ast.Visitor{
+		Enter: func(node, parent ast.Node) bool {
+			current := envs[len(envs)-1]
+
+			switch node := node.(type) {
+			case *ast.Program:
+				node.Statements = defineMacrosIn(node.Statements, current)
+			case *ast.BlockStatement:
+				scope := object.NewEnclosedEnvironment(current)
+				node.Statements = defineMacrosIn(node.Statements, scope)
+				envs = append(envs, scope)
+			case *ast.CallExpression:
+				scopes[node] = current
+			}
+
+			return true
+		},
+		Leave: func(node, parent ast.Node) {
+			if _, ok := node.(*ast.BlockStatement); ok {
+				envs = envs[:len(envs)-1]
+			}
+		},
+	})
+
+	return scopes
+}
+
+// expandMacroCall evaluates the body of macro with the call's arguments quoted
+// and returns the AST node it quoted.
+func expandMacroCall(call *ast.CallExpression, macro *object.Macro) (ast.Node, *MacroError) {
+	args := quoteArgs(call)
+
+	evalEnv, err := extendMacroEnv(macro, args)
+	if err != nil {
+		return nil, newMacroError(call, err.Message)
+	}
+
+	evaluated := unwrapReturnValue(Eval(macro.Body, evalEnv))
+
+	switch evaluated := evaluated.(type) {
+	case *object.Quote:
+		return evaluated.Node, nil
+
+	case *object.Error:
+		return nil, newMacroError(call, evaluated.Message)
+
+	case nil:
+		return nil, newMacroError(call, "macro must return a quoted AST node, got nothing")
+
+	default:
+		return nil, newMacroError(call,
+			fmt.Sprintf("macro must return a quoted AST node, got %s", evaluated.Type()))
+	}
+}
+
+func newMacroError(call *ast.CallExpression, reason string) *MacroError {
+	line, column := ast.Position(call.Function)
+
+	return &MacroError{
+		Macro:  call.Function.String(),
+		Line:   line,
+		Column: column,
+		Reason: reason,
+	}
 }
 
 func isMacroCall(
@@ -112,12 +302,27 @@ func quoteArgs(exp *ast.CallExpression) []*object.Quote {
 func extendMacroEnv(
 	macro *object.Macro,
 	args []*object.Quote,
-) *object.Environment {
+) (*object.Environment, *object.Error) {
+	numParams := len(macro.Parameters)
+	if len(args) < numParams || (macro.Rest == nil && len(args) > numParams) {
+		return nil, newError("wrong number of arguments. got=%d, want=%s",
+			len(args), arityString(numParams, numParams, macro.Rest != nil))
+	}
+
 	extended := object.NewEnclosedEnvironment(macro.Env)
 
 	for paramIdx, param := range macro.Parameters {
 		extended.Set(param.Value, args[paramIdx])
 	}
 
-	return extended
+	// The remaining arguments are collected, still quoted, for unquote_splicing
+	if macro.Rest != nil {
+		rest := []object.Object{}
+		for _, arg := range args[numParams:] {
+			rest = append(rest, arg)
+		}
+		extended.Set(macro.Rest.Value, &object.Array{Elements: rest})
+	}
+
+	return extended, nil
 }
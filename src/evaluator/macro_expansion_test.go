@@ -5,6 +5,10 @@ import (
 	"chui/lexer"
 	"chui/object"
 	"chui/parser"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -104,6 +108,84 @@ func TestExpandMacros(t *testing.T) {
             `,
 			`if (!(10 > 5)) { puts("not greater") } else { puts("greater") }`,
 		},
+		{
+			`
+            let twice = macro(x) { quote(unquote(x) + unquote(x)); };
+
+            twice(1 * 2);
+            twice(3);
+            `,
+			`(1 * 2) + (1 * 2); 3 + 3`,
+		},
+		{
+			`
+            let double = macro(x) { quote(unquote(x) * 2); };
+
+            puts(double(3), len([double(4)]));
+            `,
+			`puts((3 * 2), len([(4 * 2)]))`,
+		},
+		{
+			`
+            let f = func(x) {
+                let twice = macro(e) { quote(unquote(e) * 2); };
+                twice(x);
+            };
+            f(5);
+            `,
+			`let f = func(x) { (x * 2) }; f(5)`,
+		},
+		// A macro defined in a block isn't visible outside of it, and shadows outer macros inside it
+		{
+			`
+            let m = macro() { quote("outer"); };
+            if (true) { let m = macro() { quote("inner"); }; m(); };
+            if (true) { m() };
+            `,
+			`if (true) { "inner" }; if (true) { "outer" }`,
+		},
+		{
+			`
+            if (true) { let m = macro() { quote(1); }; m(); };
+            m();
+            `,
+			`if (true) { 1 }; m()`,
+		},
+		// Expansions are expanded again
+		{
+			`
+            let inc = macro(x) { quote(unquote(x) + 1); };
+            let inc_twice = macro(x) { quote(inc(inc(unquote(x)))); };
+            inc_twice(1);
+            `,
+			`((1 + 1) + 1)`,
+		},
+		{
+			`
+            let outer = macro() { quote(func() { let inner = macro() { quote(42); }; inner() }); };
+            outer();
+            `,
+			`func() { 42 }`,
+		},
+		{
+			`
+            let list = macro(...xs) { quote([unquote_splicing(xs)]); };
+
+            list(1, 2 + 3, "a");
+            list();
+            `,
+			`[1, 2 + 3, "a"]; []`,
+		},
+		{
+			`
+            let returning = macro(value, ...steps) {
+                quote(if (true) { unquote_splicing(steps); unquote(value) });
+            };
+
+            returning(10, puts(1), 2);
+            `,
+			`if (true) { puts(1); 2; 10 }`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -112,11 +194,298 @@ func TestExpandMacros(t *testing.T) {
 
 		env := object.NewEnvironment()
 		DefineMacros(program, env)
-		expanded := ExpandMacros(program, env)
+		expanded, errors := ExpandMacros(program, env)
+		if len(errors) != 0 {
+			t.Fatalf("unexpected macro errors: %v", errors)
+		}
 
-		if expanded.String() != expected.String() {
+		if !ast.Equal(expanded, expected) {
 			t.Errorf("not equal. want=%q, got=%q",
 				expected.String(), expanded.String())
 		}
 	}
 }
+
+func TestExpandMacrosErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []MacroError
+	}{
+		{
+			`let m = macro(a, b) { quote(unquote(a) + unquote(b)) };
+m(1);`,
+			[]MacroError{{Macro: "m", Line: 2, Column: 1, Reason: "wrong number of arguments. got=1, want=2"}},
+		},
+		{
+			`let m = macro(a, ...rest) { quote(f(unquote(a), unquote_splicing(rest))) };
+m();`,
+			[]MacroError{{Macro: "m", Line: 2, Column: 1, Reason: "wrong number of arguments. got=0, want=at least 1"}},
+		},
+		{
+			`let m = macro() { 1 + 2 };
+let x = m();`,
+			[]MacroError{{Macro: "m", Line: 2, Column: 9, Reason: "macro must return a quoted AST node, got INTEGER"}},
+		},
+		{
+			`let m = macro() { };
+m();`,
+			[]MacroError{{Macro: "m", Line: 2, Column: 1, Reason: "macro must return a quoted AST node, got nothing"}},
+		},
+		{
+			`let m = macro(a) { 1 + true };
+m(1); m(2, 3);`,
+			[]MacroError{
+				{Macro: "m", Line: 2, Column: 1, Reason: "type mismatch: INTEGER + BOOLEAN"},
+				{Macro: "m", Line: 2, Column: 7, Reason: "wrong number of arguments. got=2, want=1"},
+			},
+		},
+		{
+			`let loop = macro() { quote(loop()) };
+loop();`,
+			[]MacroError{{Macro: "loop", Line: 1, Column: 28,
+				Reason: "expansion nested more than 100 levels deep, does the macro expand to a call of itself?"}},
+		},
+		{
+			`let boom = macro() { quote(boom() + boom()) };
+boom();`,
+			[]MacroError{{Macro: "boom", Line: 1, Column: 28,
+				Reason: "expansion nested more than 100 levels deep, does the macro expand to a call of itself?"}},
+		},
+		{
+			`let m = macro() { return quote(1) };
+m() + n();`,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		_, errors := ExpandMacros(program, env)
+
+		if len(errors) != len(tt.expected) {
+			t.Fatalf("wrong number of macro errors for %q. want=%d, got=%d (%v)",
+				tt.input, len(tt.expected), len(errors), errors)
+		}
+
+		for i, expected := range tt.expected {
+			if *errors[i] != expected {
+				t.Errorf("wrong macro error. want=%+v, got=%+v", expected, *errors[i])
+			}
+		}
+	}
+}
+
+func TestExpandMacrosWithTrace(t *testing.T) {
+	input := `
+let double = macro(x) { quote(unquote(x) * 2) };
+let quadruple = macro(x) { quote(double(double(unquote(x)))) };
+puts(quadruple(double(1)));`
+
+	program := testParseProgram(input)
+	env := object.NewEnvironment()
+	DefineMacros(program, env)
+
+	steps := []string{}
+	_, errors := ExpandMacrosWithTrace(program, env, func(step ExpansionStep) {
+		steps = append(steps, fmt.Sprintf("%d:%d depth %d %s: %s => %s",
+			step.Line, step.Column, step.Depth, step.Macro, step.Call, step.Expansion))
+	})
+	if len(errors) != 0 {
+		t.Fatalf("unexpected macro errors: %v", errors)
+	}
+
+	// Arguments are expanded before the call, and the calls an expansion contains after it
+	expected := []string{
+		"4:16 depth 0 double: double(1) => (1 * 2)",
+		"4:6 depth 0 quadruple: quadruple((1 * 2)) => double(double((1 * 2)))",
+		"3:41 depth 1 double: double((1 * 2)) => ((1 * 2) * 2)",
+		"3:34 depth 1 double: double(((1 * 2) * 2)) => (((1 * 2) * 2) * 2)",
+	}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Errorf("wrong steps.\nwant=%q\ngot=%q", expected, steps)
+	}
+}
+
+func TestExpansionStepString(t *testing.T) {
+	program := testParseProgram(`
+let unless = macro(cond, body) { quote(if (!(unquote(cond))) { unquote(body) }) };
+unless(x > 1, puts(x));`)
+	env := object.NewEnvironment()
+	DefineMacros(program, env)
+
+	steps := []ExpansionStep{}
+	ExpandMacrosWithTrace(program, env, func(step ExpansionStep) { steps = append(steps, step) })
+	if len(steps) != 1 {
+		t.Fatalf("wrong number of steps. want=1, got=%d", len(steps))
+	}
+
+	expected := `line 3, column 1: macro unless, depth 0
+    unless(x > 1, puts(x))
+expands to
+    if (!(x > 1)) {
+        puts(x);
+    }`
+	if steps[0].String() != expected {
+		t.Errorf("wrong step.\nwant=%q\ngot=%q", expected, steps[0].String())
+	}
+}
+
+func TestMacroErrorMessage(t *testing.T) {
+	err := &MacroError{Macro: "unless", Line: 3, Column: 5, Reason: "wrong number of arguments. got=1, want=3"}
+
+	expected := "line 3, column 5: macro unless: wrong number of arguments. got=1, want=3"
+	if err.Error() != expected {
+		t.Errorf("wrong error message. want=%q, got=%q", expected, err.Error())
+	}
+}
+
+func TestHygienicMacros(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		// The macro's `x` doesn't capture the `x` in the argument
+		{
+			`
+            let times_two = macro(body) { quote((func() { let x = 2; unquote(body) * x })()); };
+            let x = 10;
+            times_two(x + 1);
+            `,
+			22,
+		},
+		// The macro's `tmp` doesn't overwrite the `tmp` at the call site
+		{
+			`
+            let tmp = 1;
+            let double = macro(e) { quote(if (true) { let tmp = unquote(e); tmp + tmp }); };
+            double(5) + tmp;
+            `,
+			11,
+		},
+		{
+			`
+            let pair = macro(a, b) { quote(if (true) { let [first, second] = [unquote(a), unquote(b)]; first - second }); };
+            let first = 100;
+            let second = 1;
+            pair(second, first) + first;
+            `,
+			1,
+		},
+		{
+			`
+            let with_name = macro(value) {
+                let name = gensym("n");
+                quote(if (true) { let unquote(name) = unquote(value); unquote(name) * 2 });
+            };
+            with_name(21);
+            `,
+			42,
+		},
+	}
+
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		expanded, errors := ExpandMacros(program, env)
+		if len(errors) != 0 {
+			t.Fatalf("unexpected macro errors: %v", errors)
+		}
+
+		testIntegerObject(t, Eval(expanded, object.NewEnvironment()), tt.expected)
+	}
+}
+
+func TestHygienicRenaming(t *testing.T) {
+	program := testParseProgram(`
+        let double = macro(e) { quote(if (true) { let tmp = unquote(e); tmp + tmp }); };
+        double(tmp);
+        `)
+
+	env := object.NewEnvironment()
+	DefineMacros(program, env)
+	expanded, errors := ExpandMacros(program, env)
+	if len(errors) != 0 {
+		t.Fatalf("unexpected macro errors: %v", errors)
+	}
+
+	pattern := regexp.MustCompile(`^iftrue let (tmp#\d+) = tmp;\((tmp#\d+) \+ (tmp#\d+)\)$`)
+	match := pattern.FindStringSubmatch(expanded.String())
+	if match == nil {
+		t.Fatalf("let-bound name not renamed apart from the argument. got=%q", expanded.String())
+	}
+	if match[2] != match[1] || match[3] != match[1] {
+		t.Errorf("uses of %s not renamed with it. got=%q", match[1], expanded.String())
+	}
+
+	// Only the uses after the let in its block are renamed
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let tmp = 5;
+			let m = macro() { quote([tmp, func() { let tmp = 1; tmp }(), func(tmp) { tmp }(2)]) };
+			m();`,
+			"[5, 1, 2]",
+		},
+		{
+			`let m = macro() { quote(func() { let f = func(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(3) }()) };
+			m();`,
+			"0",
+		},
+		{
+			`let m = macro() { quote(try { let tmp = 1; tmp } catch (e) { 2 }) };
+			m();`,
+			"1",
+		},
+		{
+			`let m = macro() { quote(try { 4 } finally { let tmp = 3; tmp }) };
+			m();`,
+			"4",
+		},
+	}
+
+	for _, tt := range tests {
+		program := testParseProgram(tt.input)
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		expanded, errors := ExpandMacros(program, env)
+		if len(errors) != 0 {
+			t.Fatalf("unexpected macro errors: %v", errors)
+		}
+
+		if result := Eval(expanded, env); result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestGensym(t *testing.T) {
+	first := testEval(`gensym()`)
+	second := testEval(`gensym("tmp")`)
+
+	names := []string{}
+	for _, obj := range []object.Object{first, second} {
+		quote, ok := obj.(*object.Quote)
+		if !ok {
+			t.Fatalf("gensym did not return a Quote. got=%T (%+v)", obj, obj)
+		}
+		ident, ok := quote.Node.(*ast.Identifier)
+		if !ok {
+			t.Fatalf("gensym did not quote an identifier. got=%T", quote.Node)
+		}
+		names = append(names, ident.Value)
+	}
+
+	if !strings.HasPrefix(names[0], "g#") || !strings.HasPrefix(names[1], "tmp#") {
+		t.Errorf("wrong prefixes. got=%q", names)
+	}
+	if names[0] == names[1] {
+		t.Errorf("gensym returned the same name twice: %q", names[0])
+	}
+}
@@ -7,39 +7,214 @@ import (
 	"fmt"
 )
 
+// quote returns node as a Quote with its let-bound names renamed apart and its unquote
+// calls evaluated. The changes are made to a copy, so quoting the same node again, as
+// every call of a macro does with its body, starts from the original.
 func quote(node ast.Node, env *object.Environment) object.Object {
-	node = evalUnquoteCalls(node, env)
+	quoted := ast.Clone(node)
+	renameLetBindings(quoted)
+
+	node, err := evalUnquoteCalls(quoted, env)
+	if errObj, ok := err.(*object.Error); ok {
+		return errObj
+	}
+	if err != nil {
+		return newError("%s", err)
+	}
+
 	return &object.Quote{Node: node}
 }
 
-func evalUnquoteCalls(quoted ast.Node, env *object.Environment) ast.Node {
-	return ast.Modify(quoted, func(node ast.Node) ast.Node {
-		if !isUnquoteCall(node) {
+// evalUnquoteCalls replaces every unquote call in quoted with the AST of the value its
+// argument evaluates to, and splices the elements of every unquote_splicing argument
+// into the argument list, array literal or block the call is in. It fails on the first
+// argument that raises an error or evaluates to a value with no AST form.
+func evalUnquoteCalls(quoted ast.Node, env *object.Environment) (ast.Node, error) {
+	var unquoteErr error
+
+	modified, err := ast.Modify(quoted, func(node ast.Node) ast.Node {
+		if unquoteErr != nil {
 			return node
 		}
 
-		call, ok := node.(*ast.CallExpression)
-		if !ok {
-			return node
+		if isUnquoteCall(node) {
+			call := node.(*ast.CallExpression)
+			if len(call.Arguments) != 1 {
+				return node
+			}
+
+			converted, err := convertObjectToASTNode(Eval(call.Arguments[0], env))
+			if err != nil {
+				unquoteErr = err
+				return node
+			}
+
+			return converted
 		}
 
-		if len(call.Arguments) != 1 {
-			return node
+		switch node := node.(type) {
+		case *ast.DestructuringLetStatement:
+			return unquotedLetStatement(node)
+
+		case *ast.CallExpression:
+			if !isUnquoteSplicingCall(node) {
+				node.Arguments, unquoteErr = spliceExpressions(node.Arguments, env)
+			}
+
+		case *ast.ArrayLiteral:
+			node.Elements, unquoteErr = spliceExpressions(node.Elements, env)
+
+		case *ast.BlockStatement:
+			node.Statements, unquoteErr = spliceStatements(node.Statements, env)
+		}
+
+		if unquoteErr == nil {
+			unquoteErr = checkMisplacedSplicing(node)
 		}
 
-		unquoted := Eval(call.Arguments[0], env)
-		return convertObjectToASTNode(unquoted)
+		return node
 	})
+	if unquoteErr != nil {
+		return nil, unquoteErr
+	}
+	if isUnquoteSplicingCall(modified) {
+		return nil, misplacedSplicingError(modified)
+	}
+
+	return modified, err
+}
+
+// spliceExpressions replaces the unquote_splicing calls in exps with the expressions they splice in.
+func spliceExpressions(exps []ast.Expression, env *object.Environment) ([]ast.Expression, error) {
+	spliced := []ast.Expression{}
+
+	for _, exp := range exps {
+		if !isUnquoteSplicingCall(exp) {
+			spliced = append(spliced, exp)
+			continue
+		}
+
+		nodes, err := evalUnquoteSplicing(exp.(*ast.CallExpression), env)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, node := range nodes {
+			spliced = append(spliced, node.(ast.Expression))
+		}
+	}
+
+	return spliced, nil
+}
+
+// spliceStatements replaces the statements of a block that consist of an unquote_splicing
+// call with a statement for each expression it splices in.
+func spliceStatements(stmts []ast.Statement, env *object.Environment) ([]ast.Statement, error) {
+	spliced := []ast.Statement{}
+
+	for _, stmt := range stmts {
+		expStmt, ok := stmt.(*ast.ExpressionStatement)
+		if !ok || !isUnquoteSplicingCall(expStmt.Expression) {
+			spliced = append(spliced, stmt)
+			continue
+		}
+
+		nodes, err := evalUnquoteSplicing(expStmt.Expression.(*ast.CallExpression), env)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, node := range nodes {
+			spliced = append(spliced, &ast.ExpressionStatement{
+				Token:      expStmt.Token,
+				Expression: node.(ast.Expression),
+			})
+		}
+	}
+
+	return spliced, nil
+}
+
+// evalUnquoteSplicing evaluates the argument of an unquote_splicing call, which must be an
+// array, such as the rest parameter of a variadic macro, and converts its elements to AST.
+func evalUnquoteSplicing(call *ast.CallExpression, env *object.Environment) ([]ast.Node, error) {
+	if len(call.Arguments) != 1 {
+		return nil, fmt.Errorf("wrong number of arguments to `unquote_splicing`. got=%d, want=1",
+			len(call.Arguments))
+	}
+
+	evaluated := Eval(call.Arguments[0], env)
+	if errObj, ok := evaluated.(*object.Error); ok {
+		return nil, errObj
+	}
+
+	array, ok := evaluated.(*object.Array)
+	if !ok {
+		return nil, fmt.Errorf("argument to `unquote_splicing` must be ARRAY, got %s",
+			evaluated.Type())
+	}
+
+	nodes := []ast.Node{}
+	for _, element := range array.Elements {
+		node, err := convertObjectToASTNode(element)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+// checkMisplacedSplicing reports an unquote_splicing call left among node's children once
+// its lists have been spliced, which is in a place that holds a single expression. A
+// statement made of the call is left for the enclosing block to splice.
+func checkMisplacedSplicing(node ast.Node) error {
+	if _, ok := node.(*ast.ExpressionStatement); ok {
+		return nil
+	}
+
+	for _, child := range ast.Children(node) {
+		if isUnquoteSplicingCall(child) {
+			return misplacedSplicingError(child)
+		}
+	}
+
+	return nil
 }
 
-func convertObjectToASTNode(obj object.Object) ast.Node {
+func misplacedSplicingError(call ast.Node) error {
+	return fmt.Errorf("cannot splice %s here: unquote_splicing only works in argument lists, array literals and blocks",
+		call.String())
+}
+
+// unquotedLetStatement turns `let unquote(name) = value;` into a plain let statement once
+// the unquote call has been replaced by an identifier, such as one made by gensym().
+func unquotedLetStatement(let *ast.DestructuringLetStatement) ast.Node {
+	name, ok := let.Pattern.(*ast.Identifier)
+	if !ok {
+		return let
+	}
+
+	if fl, ok := let.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = name.Value
+	}
+
+	return &ast.LetStatement{Token: let.Token, Name: name, Value: let.Value}
+}
+
+// convertObjectToASTNode returns an expression that evaluates to obj. A function becomes
+// a function literal, whose free variables refer to whatever they are bound to where the
+// literal is spliced in. An error is returned as is; builtins, macros and other values
+// with no AST form are reported as errors.
+func convertObjectToASTNode(obj object.Object) (ast.Node, error) {
 	switch obj := obj.(type) {
 	case *object.Integer:
 		t := token.Token{
 			Type:    token.INT,
 			Literal: fmt.Sprintf("%d", obj.Value),
 		}
-		return &ast.IntegerLiteral{Token: t, Value: obj.Value}
+		return &ast.IntegerLiteral{Token: t, Value: obj.Value}, nil
 
 	case *object.Boolean:
 		var t token.Token
@@ -48,13 +223,69 @@ func convertObjectToASTNode(obj object.Object) ast.Node {
 		} else {
 			t = token.Token{Type: token.FALSE, Literal: "false"}
 		}
-		return &ast.Boolean{Token: t, Value: obj.Value}
+		return &ast.Boolean{Token: t, Value: obj.Value}, nil
+
+	case *object.String:
+		t := token.Token{Type: token.STRING, Literal: obj.Value}
+		return &ast.StringLiteral{Token: t, Value: obj.Value}, nil
+
+	case *object.Null:
+		return &ast.NullLiteral{Token: token.Token{Literal: "null"}}, nil
+
+	case *object.Array:
+		elements := make([]ast.Expression, len(obj.Elements))
+		for i, element := range obj.Elements {
+			node, err := convertObjectToASTNode(element)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = node.(ast.Expression)
+		}
+
+		t := token.Token{Type: token.LBRACKET, Literal: "["}
+		return &ast.ArrayLiteral{Token: t, Elements: elements}, nil
+
+	case *object.Hash:
+		pairs := make(map[ast.Expression]ast.Expression, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			key, err := convertObjectToASTNode(pair.Key)
+			if err != nil {
+				return nil, err
+			}
+			value, err := convertObjectToASTNode(pair.Value)
+			if err != nil {
+				return nil, err
+			}
+			pairs[key.(ast.Expression)] = value.(ast.Expression)
+		}
+
+		t := token.Token{Type: token.LBRACE, Literal: "{"}
+		return &ast.HashLiteral{Token: t, Pairs: pairs}, nil
+
+	case *object.Function:
+		literal := &ast.FunctionLiteral{
+			Token:      token.Token{Type: token.FUNCTION, Literal: "func"},
+			Parameters: obj.Parameters,
+			Defaults:   obj.Defaults,
+			Rest:       obj.Rest,
+			Body:       obj.Body,
+			Name:       obj.Name,
+		}
+		// The function object keeps its own AST, so the spliced literal gets a copy
+		return ast.Clone(literal), nil
 
 	case *object.Quote:
-		return obj.Node
+		// The same quoted argument may be unquoted more than once, and each use needs its own nodes
+		return ast.Clone(obj.Node), nil
+
+	case *object.Error:
+		return nil, obj
+
+	case nil:
+		return nil, fmt.Errorf("cannot unquote a value that evaluates to nothing")
 
 	default:
-		return nil
+		return nil, fmt.Errorf("cannot unquote %s: it has no AST form", obj.Type())
 	}
 }
 
@@ -66,3 +297,12 @@ func isUnquoteCall(node ast.Node) bool {
 
 	return callExpression.Function.TokenLiteral() == "unquote"
 }
+
+func isUnquoteSplicingCall(node ast.Node) bool {
+	callExpression, ok := node.(*ast.CallExpression)
+	if !ok {
+		return false
+	}
+
+	return callExpression.Function.TokenLiteral() == "unquote_splicing"
+}
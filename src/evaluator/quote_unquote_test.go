@@ -97,6 +97,31 @@ func TestQuoteUnquote(t *testing.T) {
             quote(unquote(4 + 4) + unquote(quotedInfixExpression))`,
 			`(8 + (4 + 4))`,
 		},
+		{
+			`quote(puts(unquote(1 + 2), unquote(true)))`,
+			`puts(3, true)`,
+		},
+		{
+			`quote(len(unquote("four")))`,
+			`len(four)`,
+		},
+		{
+			`quote(unquote([1, 2 + 3, [true]]))`,
+			`[1, 5, [true]]`,
+		},
+		{
+			`quote(unquote({"a": [1]}))`,
+			`{a:[1]}`,
+		},
+		{
+			`quote(unquote(if (false) { 1 }))`,
+			`null`,
+		},
+		{
+			`let double = func(x) { x * 2 };
+            quote(unquote(double)(3))`,
+			`func<double>(x) (x * 2)(3)`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -117,3 +142,77 @@ func TestQuoteUnquote(t *testing.T) {
 		}
 	}
 }
+
+func TestUnquoteSplicing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let xs = [quote(1), quote(2 + 3)];
+            quote(f(0, unquote_splicing(xs), 9))`,
+			`f(0, 1, (2 + 3), 9)`,
+		},
+		{
+			`quote([unquote_splicing([1, "two", true]), unquote_splicing([])])`,
+			`[1, two, true]`,
+		},
+		{
+			`quote(f(unquote_splicing([])))`,
+			`f()`,
+		},
+		{
+			`let stmts = [quote(puts(1)), quote(2 * 3)];
+            quote(if (true) { unquote_splicing(stmts); 4 })`,
+			`iftrue puts(1)(2 * 3)4`,
+		},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		quote, ok := evaluated.(*object.Quote)
+		if !ok {
+			t.Fatalf("expected *object.Quote. got=%T (%+v)", evaluated, evaluated)
+		}
+
+		if quote.Node.String() != tt.expected {
+			t.Errorf("not equal. got=%q, want=%q", quote.Node.String(), tt.expected)
+		}
+	}
+}
+
+func TestUnquoteErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(unquote(len))`, "cannot unquote BUILTIN: it has no AST form"},
+		{`quote(unquote([1, len]))`, "cannot unquote BUILTIN: it has no AST form"},
+		{`quote(1 + unquote(1 + true))`, "type mismatch: INTEGER + BOOLEAN"},
+		{`quote(unquote(missing))`, "identifier not found: missing"},
+		{`quote(f(unquote_splicing(1)))`, "argument to `unquote_splicing` must be ARRAY, got INTEGER"},
+		{`quote(f(unquote_splicing([len])))`, "cannot unquote BUILTIN: it has no AST form"},
+		{`quote(f(unquote_splicing([1], [2])))`, "wrong number of arguments to `unquote_splicing`. got=2, want=1"},
+		{
+			`quote(1 + unquote_splicing([1]))`,
+			"cannot splice unquote_splicing([1]) here: unquote_splicing only works in argument lists, array literals and blocks",
+		},
+		{
+			`quote(unquote_splicing([1]))`,
+			"cannot splice unquote_splicing([1]) here: unquote_splicing only works in argument lists, array literals and blocks",
+		},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("expected *object.Error. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expected {
+			t.Errorf("wrong error message. want=%q, got=%q", tt.expected, errObj.Message)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package ir
+
+import (
+	"bytes"
+	"chui/code"
+	"fmt"
+	"strings"
+)
+
+// Dump() lists the blocks of f in layout order, each under its label (b0, b1, ...) with one instruction per line.
+// Conditional jumps name the block they go to, and a `-> b2` line ends each block with a Next edge. The handlers
+// are listed last as `.handler start end target slot`. annotate, unless nil, returns a comment for an instruction,
+// as for code.Disassemble.
+func Dump(f *Function, annotate func(op code.Opcode, operands []int) string) string {
+	var out bytes.Buffer
+
+	for _, b := range f.Blocks {
+		fmt.Fprintf(&out, "%s:\n", b)
+
+		for _, in := range b.Instructions {
+			def, err := code.Lookup(byte(in.Op))
+			if err != nil {
+				fmt.Fprintf(&out, "    ERROR: %s\n", err)
+				continue
+			}
+
+			text := []string{def.Name}
+			jumpIndex, jumps := code.JumpOperand(in.Op)
+			for i, operand := range in.Operands {
+				if jumps && i == jumpIndex {
+					text = append(text, b.Branch.String())
+				} else {
+					text = append(text, fmt.Sprint(operand))
+				}
+			}
+
+			line := strings.Join(text, " ")
+			if annotate != nil {
+				if comment := annotate(in.Op, in.Operands); comment != "" {
+					line = fmt.Sprintf("%-24s ; %s", line, comment)
+				}
+			}
+			fmt.Fprintf(&out, "    %s\n", line)
+		}
+
+		if b.Next != nil {
+			fmt.Fprintf(&out, "    -> %s\n", b.Next)
+		}
+	}
+
+	for _, h := range f.Handlers {
+		fmt.Fprintf(&out, "    .handler %s %s %s %d\n", h.Start, h.End, h.Target, h.Slot)
+	}
+
+	return out.String()
+}
+
+func (b *Block) String() string {
+	return fmt.Sprintf("b%d", b.ID)
+}
+
+func (f *Function) String() string {
+	return Dump(f, nil)
+}
@@ -0,0 +1,59 @@
+package ir
+
+import (
+	"chui/code"
+	"fmt"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	ins := concat(
+		code.Make(code.OpTry, 0),            // 0000
+		code.Make(code.OpGetLocal, 0),       // 0003
+		code.Make(code.OpJumpNotTruthy, 12), // 0005
+		code.Make(code.OpConstant, 0),       // 0008
+		code.Make(code.OpThrow),             // 0011
+		code.Make(code.OpNull),              // 0012
+		code.Make(code.OpJump, 18),          // 0013
+		code.Make(code.OpSetLocal, 1),       // 0016
+		code.Make(code.OpGetLocal, 1),       // 0018
+		code.Make(code.OpReturnValue),       // 0020
+	)
+	handlers := []code.Handler{{Start: 3, End: 13, Target: 16, Slot: 0}}
+
+	annotate := func(op code.Opcode, operands []int) string {
+		if op == code.OpConstant {
+			return fmt.Sprintf("constant %d", operands[0])
+		}
+		return ""
+	}
+
+	expected := `b0:
+    OpTry 0
+    -> b1
+b1:
+    OpGetLocal 0
+    OpJumpNotTruthy b3
+    -> b2
+b2:
+    OpConstant 0             ; constant 0
+    OpThrow
+b3:
+    OpNull
+    -> b4
+b4:
+    -> b6
+b5:
+    OpSetLocal 1
+    -> b6
+b6:
+    OpGetLocal 1
+    OpReturnValue
+b7:
+    .handler b1 b4 b5 0
+`
+
+	if actual := Dump(build(t, ins, handlers, nil), annotate); actual != expected {
+		t.Errorf("wrong dump.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+}
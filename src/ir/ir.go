@@ -0,0 +1,310 @@
+// Package ir holds the intermediate representation the compiler emits a function's code into and optimizes it in: a
+// control flow graph of basic blocks with explicit edges, linearized into instructions once it is complete. Build
+// turns instructions back into one, to list the graph of existing bytecode.
+package ir
+
+import (
+	"chui/code"
+	"fmt"
+	"sort"
+)
+
+// Instruction is an instruction in a block. The offset a conditional jump goes to is not among its operands,
+// the block's Branch edge holds it. Unconditional jumps are not instructions, but the block's Next edge.
+type Instruction struct {
+	Op       code.Opcode
+	Operands []int // the jump operand of a conditional jump is left 0
+	Line     int   // source position, 0 if unknown
+	Column   int
+}
+
+// Block is a basic block: instructions that run one after the other, entered at the first one only.
+type Block struct {
+	ID           int
+	Instructions []Instruction // only the last one can be a conditional jump, a return or a throw
+	Branch       *Block        // where the conditional jump ending the block goes, nil without one
+	Next         *Block        // where control goes after the block, nil after a return or throw and for the exit
+}
+
+// Successors() returns the blocks control can go to from b, not counting handlers.
+func (b *Block) Successors() []*Block {
+	var successors []*Block
+	if b.Branch != nil {
+		successors = append(successors, b.Branch)
+	}
+	if b.Next != nil {
+		successors = append(successors, b.Next)
+	}
+	return successors
+}
+
+// Destination() returns the first block with instructions control entering b gets to, following the Next edges of
+// empty blocks, or the empty block control stops at.
+func (b *Block) Destination() *Block {
+	seen := map[*Block]bool{}
+	for len(b.Instructions) == 0 && b.Next != nil && !seen[b] {
+		seen[b] = true
+		b = b.Next
+	}
+	return b
+}
+
+// Handler is an exception handler guarding the blocks from Start up to, but not including, End in layout order.
+type Handler struct {
+	Start  *Block
+	End    *Block
+	Target *Block
+	Slot   int
+}
+
+// Function is the control flow graph of a function body or the main program. Blocks are in the order they are laid
+// out in, the first one is the entry and the last one is the exit: an empty block where execution ends.
+type Function struct {
+	Blocks   []*Block
+	Handlers []Handler // in the order of the handler table, inner handlers after the ones enclosing them
+}
+
+// Entry() returns the block execution starts at.
+func (f *Function) Entry() *Block {
+	return f.Blocks[0]
+}
+
+// Exit() returns the empty block at the end, where execution falls off the code.
+func (f *Function) Exit() *Block {
+	return f.Blocks[len(f.Blocks)-1]
+}
+
+// terminates() reports whether nothing runs after op.
+func terminates(op code.Opcode) bool {
+	switch op {
+	case code.OpJump, code.OpReturn, code.OpReturnValue, code.OpThrow, code.OpNoMatch:
+		return true
+	}
+	return false
+}
+
+// Build() splits instructions, as DecodeInstructions returns them, into the basic blocks of a function. end is the
+// offset after the last instruction. The handlers and the source map are those of the instructions.
+func Build(instructions []code.Instruction, end int, handlers []code.Handler, sourceMap code.SourceMap) (*Function, error) {
+	starts := map[int]bool{0: true, end: true}
+	isInstruction := map[int]bool{end: true}
+	for i, in := range instructions {
+		isInstruction[in.Offset] = true
+		if jumpIndex, ok := code.JumpOperand(in.Op); ok {
+			starts[in.Operands[jumpIndex]] = true
+		}
+		if _, jumps := code.JumpOperand(in.Op); (jumps || terminates(in.Op)) && i+1 < len(instructions) {
+			starts[instructions[i+1].Offset] = true
+		}
+	}
+	for _, h := range handlers {
+		starts[h.Start], starts[h.End], starts[h.Target] = true, true, true
+	}
+	for offset := range starts {
+		if !isInstruction[offset] {
+			return nil, fmt.Errorf("%04d is not the start of an instruction", offset)
+		}
+	}
+
+	offsets := make([]int, 0, len(starts))
+	for offset := range starts {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+
+	f := &Function{}
+	blocks := map[int]*Block{}
+	for _, offset := range offsets {
+		b := &Block{ID: len(f.Blocks)}
+		f.Blocks = append(f.Blocks, b)
+		blocks[offset] = b
+	}
+
+	i := 0 // the index of the block being filled
+	positions := sourceMap
+	line, column := 0, 0
+	for _, in := range instructions {
+		if in.Offset == offsets[i+1] {
+			if current := f.Blocks[i]; current.Next == nil && !current.Terminated() {
+				current.Next = f.Blocks[i+1]
+			}
+			i++
+		}
+		current := f.Blocks[i]
+
+		for len(positions) > 0 && positions[0].Offset <= in.Offset {
+			line, column = positions[0].Line, positions[0].Column
+			positions = positions[1:]
+		}
+
+		if in.Op == code.OpJump {
+			current.Next = blocks[in.Operands[0]]
+			continue
+		}
+
+		operands := append([]int{}, in.Operands...)
+		if jumpIndex, ok := code.JumpOperand(in.Op); ok {
+			current.Branch = blocks[operands[jumpIndex]]
+			operands[jumpIndex] = 0
+		}
+		current.Instructions = append(current.Instructions, Instruction{Op: in.Op, Operands: operands, Line: line, Column: column})
+	}
+
+	if last := f.Blocks[i]; i+1 < len(f.Blocks) && last.Next == nil && !last.Terminated() {
+		last.Next = f.Blocks[i+1]
+	}
+
+	for _, h := range handlers {
+		f.Handlers = append(f.Handlers, Handler{Start: blocks[h.Start], End: blocks[h.End], Target: blocks[h.Target], Slot: h.Slot})
+	}
+
+	return f, nil
+}
+
+// Terminated() reports whether b ends with an instruction nothing runs after, such as a return or a throw.
+func (b *Block) Terminated() bool {
+	n := len(b.Instructions)
+	return n > 0 && terminates(b.Instructions[n-1].Op)
+}
+
+// Linearize() lays the blocks out as instructions, in the order of f.Blocks, adding an OpJump after each block whose
+// Next edge doesn't go where the block laid out after it leads. It returns the instructions with their handler table
+// and source map.
+func (f *Function) Linearize() (code.Instructions, []code.Handler, code.SourceMap) {
+	jumps := make([]bool, len(f.Blocks))
+	for i, b := range f.Blocks {
+		jumps[i] = b.Next != nil && (i+1 == len(f.Blocks) || b.Next.Destination() != f.Blocks[i+1].Destination())
+	}
+
+	// Every instruction gets an offset of its own, which EncodeInstructions relocates to where it is laid out. A
+	// block starts at the offset of its first instruction, an empty one at that of the instruction after it.
+	starts := map[*Block]int{}
+	offset := 0
+	for i, b := range f.Blocks {
+		starts[b] = offset
+		offset += len(b.Instructions)
+		if jumps[i] {
+			offset++
+		}
+	}
+
+	var instructions []code.Instruction
+	var sourceMap code.SourceMap
+	add := func(op code.Opcode, operands []int, line, column int) {
+		offset := len(instructions)
+		if n := len(sourceMap); line > 0 && (n == 0 || sourceMap[n-1].Line != line || sourceMap[n-1].Column != column) {
+			sourceMap = append(sourceMap, code.SourcePosition{Offset: offset, Line: line, Column: column})
+		}
+		instructions = append(instructions, code.Instruction{Offset: offset, Op: op, Operands: operands})
+	}
+
+	for i, b := range f.Blocks {
+		line, column := 0, 0
+		for _, in := range b.Instructions {
+			operands := append([]int{}, in.Operands...)
+			if jumpIndex, ok := code.JumpOperand(in.Op); ok {
+				operands[jumpIndex] = starts[b.Branch]
+			}
+			add(in.Op, operands, in.Line, in.Column)
+			line, column = in.Line, in.Column
+		}
+
+		if jumps[i] {
+			add(code.OpJump, []int{starts[b.Next]}, line, column)
+		}
+	}
+
+	var handlers []code.Handler
+	for _, h := range f.Handlers {
+		handlers = append(handlers, code.Handler{Start: starts[h.Start], End: starts[h.End], Target: starts[h.Target], Slot: h.Slot})
+	}
+
+	ins, relocation := code.EncodeInstructions(instructions)
+	return ins, relocation.Handlers(handlers), relocation.SourceMap(sourceMap)
+}
+
+// RemoveUnreachable() drops the blocks control can't get to from the entry, along with the handlers whose target
+// can't be reached. A handler's target is reached when an OpTry saving its slot is. Handlers guarding dropped blocks
+// are moved to the blocks laid out after them.
+func (f *Function) RemoveUnreachable() {
+	reached := map[*Block]bool{}
+	work := []*Block{f.Entry()}
+	reach := func(b *Block) {
+		if !reached[b] {
+			reached[b] = true
+			work = append(work, b)
+		}
+	}
+	reached[f.Entry()] = true
+
+	for len(work) > 0 {
+		b := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		for _, successor := range b.Successors() {
+			reach(successor)
+		}
+		for _, in := range b.Instructions {
+			if in.Op != code.OpTry {
+				continue
+			}
+			for _, h := range f.Handlers {
+				if h.Slot == in.Operands[0] {
+					reach(h.Target)
+				}
+			}
+		}
+	}
+	reached[f.Exit()] = true
+
+	// The first block kept at or after each block
+	kept := map[*Block]*Block{}
+	var blocks []*Block
+	for i := len(f.Blocks) - 1; i >= 0; i-- {
+		b := f.Blocks[i]
+		if reached[b] {
+			blocks = append([]*Block{b}, blocks...)
+		}
+		kept[b] = blocks[0]
+	}
+
+	var handlers []Handler
+	for _, h := range f.Handlers {
+		if reached[h.Target] {
+			handlers = append(handlers, Handler{Start: kept[h.Start], End: kept[h.End], Target: h.Target, Slot: h.Slot})
+		}
+	}
+
+	f.Blocks, f.Handlers = blocks, handlers
+}
+
+// CompactTrySlots() numbers the try slots of the OpTry instructions left in f from 0, in layout order, and returns
+// how many there are. Handlers for slots no OpTry saves any more can never run and are dropped.
+func (f *Function) CompactTrySlots() int {
+	slots := map[int]int{}
+	for _, b := range f.Blocks {
+		for i, in := range b.Instructions {
+			if in.Op != code.OpTry {
+				continue
+			}
+
+			slot, ok := slots[in.Operands[0]]
+			if !ok {
+				slot = len(slots)
+				slots[in.Operands[0]] = slot
+			}
+			b.Instructions[i].Operands = []int{slot}
+		}
+	}
+
+	var handlers []Handler
+	for _, h := range f.Handlers {
+		if slot, ok := slots[h.Slot]; ok {
+			h.Slot = slot
+			handlers = append(handlers, h)
+		}
+	}
+	f.Handlers = handlers
+
+	return len(slots)
+}
@@ -0,0 +1,217 @@
+package ir
+
+import (
+	"chui/code"
+	"reflect"
+	"testing"
+)
+
+func concat(s ...code.Instructions) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+	return out
+}
+
+func build(t *testing.T, ins code.Instructions, handlers []code.Handler, sourceMap code.SourceMap) *Function {
+	t.Helper()
+
+	decoded, err := code.DecodeInstructions(ins)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	fn, err := Build(decoded, len(ins), handlers, sourceMap)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	return fn
+}
+
+// ifElse is `if (x) { 2 } else { 3 }` in a function
+var ifElse = concat(
+	code.Make(code.OpGetLocal, 0),       // 0000
+	code.Make(code.OpJumpNotTruthy, 11), // 0002
+	code.Make(code.OpConstant, 0),       // 0005
+	code.Make(code.OpJump, 14),          // 0008
+	code.Make(code.OpConstant, 1),       // 0011
+	code.Make(code.OpReturnValue),       // 0014
+)
+
+func TestBuild(t *testing.T) {
+	fn := build(t, ifElse, nil, code.SourceMap{{Offset: 0, Line: 1, Column: 5}, {Offset: 11, Line: 2, Column: 3}})
+
+	if len(fn.Blocks) != 5 {
+		t.Fatalf("wrong number of blocks. want=5, got=%d\n%s", len(fn.Blocks), fn)
+	}
+	b0, b1, b2, b3, exit := fn.Blocks[0], fn.Blocks[1], fn.Blocks[2], fn.Blocks[3], fn.Blocks[4]
+
+	edges := []struct {
+		block           *Block
+		branch, next    *Block
+		numInstructions int
+	}{
+		{b0, b2, b1, 2},
+		{b1, nil, b3, 1}, // the OpJump is the Next edge
+		{b2, nil, b3, 1},
+		{b3, nil, nil, 1},
+		{exit, nil, nil, 0},
+	}
+	for _, e := range edges {
+		if e.block.Branch != e.branch || e.block.Next != e.next || len(e.block.Instructions) != e.numInstructions {
+			t.Errorf("wrong block %s:\n%s", e.block, fn)
+		}
+	}
+
+	if fn.Entry() != b0 || fn.Exit() != exit {
+		t.Errorf("wrong entry %s or exit %s", fn.Entry(), fn.Exit())
+	}
+
+	if in := b1.Instructions[0]; in.Line != 1 || in.Column != 5 {
+		t.Errorf("wrong position of %s. want=1:5, got=%d:%d", b1, in.Line, in.Column)
+	}
+	if in := b2.Instructions[0]; in.Line != 2 || in.Column != 3 {
+		t.Errorf("wrong position of %s. want=2:3, got=%d:%d", b2, in.Line, in.Column)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	ins := concat(code.Make(code.OpJump, 1), code.Make(code.OpNull))
+
+	decoded, err := code.DecodeInstructions(ins)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	_, err = Build(decoded, len(ins), nil, nil)
+	if err == nil || err.Error() != "0001 is not the start of an instruction" {
+		t.Errorf("wrong error: %v", err)
+	}
+}
+
+func TestLinearizeRoundTrip(t *testing.T) {
+	ins := concat(
+		code.Make(code.OpTry, 0),      // 0000
+		code.Make(code.OpGetLocal, 0), // 0003
+		code.Make(code.OpThrow),       // 0005
+		code.Make(code.OpJump, 11),    // 0006
+		code.Make(code.OpSetLocal, 1), // 0009
+		code.Make(code.OpReturnValue), // 0011
+	)
+	handlers := []code.Handler{{Start: 3, End: 6, Target: 9, Slot: 0}}
+	sourceMap := code.SourceMap{{Offset: 0, Line: 1, Column: 1}, {Offset: 3, Line: 1, Column: 5}, {Offset: 9, Line: 2, Column: 1}}
+
+	linearized, linearizedHandlers, linearizedSourceMap := build(t, ins, handlers, sourceMap).Linearize()
+
+	if !reflect.DeepEqual(linearized, ins) {
+		t.Errorf("wrong instructions.\nwant=\n%s\ngot=\n%s", ins, linearized)
+	}
+	if !reflect.DeepEqual(linearizedHandlers, handlers) {
+		t.Errorf("wrong handlers. want=%v, got=%v", handlers, linearizedHandlers)
+	}
+	if !reflect.DeepEqual(linearizedSourceMap, sourceMap) {
+		t.Errorf("wrong source map. want=%v, got=%v", sourceMap, linearizedSourceMap)
+	}
+}
+
+func TestLinearizeAddsJumps(t *testing.T) {
+	fn := build(t, ifElse, nil, nil)
+
+	// Lay the else branch out before the then branch
+	b := fn.Blocks
+	fn.Blocks = []*Block{b[0], b[2], b[1], b[3], b[4]}
+
+	ins, _, _ := fn.Linearize()
+
+	expected := concat(
+		code.Make(code.OpGetLocal, 0),
+		code.Make(code.OpJumpNotTruthy, 8),
+		code.Make(code.OpJump, 14),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpJump, 17),
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpReturnValue),
+	)
+	if !reflect.DeepEqual(ins, expected) {
+		t.Errorf("wrong instructions.\nwant=\n%s\ngot=\n%s", expected, ins)
+	}
+}
+
+func TestRemoveUnreachable(t *testing.T) {
+	ins := concat(
+		code.Make(code.OpTry, 0),      // 0000
+		code.Make(code.OpJump, 7),     // 0003
+		code.Make(code.OpNull),        // 0006, jumped over, where the first handler starts
+		code.Make(code.OpGetLocal, 0), // 0007
+		code.Make(code.OpThrow),       // 0009
+		code.Make(code.OpPop),         // 0010, the first handler's target
+		code.Make(code.OpNull),        // 0011
+		code.Make(code.OpReturnValue), // 0012
+		code.Make(code.OpTry, 1),      // 0013, after the return
+		code.Make(code.OpNull),        // 0016
+		code.Make(code.OpReturnValue), // 0017, the second handler's target
+	)
+	handlers := []code.Handler{
+		{Start: 6, End: 10, Target: 10, Slot: 0},
+		{Start: 16, End: 17, Target: 17, Slot: 1},
+	}
+
+	fn := build(t, ins, handlers, nil)
+	fn.RemoveUnreachable()
+	linearized, linearizedHandlers, _ := fn.Linearize()
+
+	expected := concat(
+		code.Make(code.OpTry, 0),
+		code.Make(code.OpGetLocal, 0),
+		code.Make(code.OpThrow),
+		code.Make(code.OpPop),
+		code.Make(code.OpNull),
+		code.Make(code.OpReturnValue),
+	)
+	if !reflect.DeepEqual(linearized, expected) {
+		t.Errorf("wrong instructions.\nwant=\n%s\ngot=\n%s", expected, linearized)
+	}
+
+	// The first handler starts at the block after the one dropped, the second one can't be reached
+	want := []code.Handler{{Start: 3, End: 6, Target: 6, Slot: 0}}
+	if !reflect.DeepEqual(linearizedHandlers, want) {
+		t.Errorf("wrong handlers. want=%v, got=%v", want, linearizedHandlers)
+	}
+}
+
+func TestCompactTrySlots(t *testing.T) {
+	ins := concat(
+		code.Make(code.OpJump, 6),     // 0000
+		code.Make(code.OpTry, 0),      // 0003, jumped over
+		code.Make(code.OpTry, 1),      // 0006
+		code.Make(code.OpNull),        // 0009
+		code.Make(code.OpReturnValue), // 0010
+	)
+	handlers := []code.Handler{
+		{Start: 9, End: 10, Target: 10, Slot: 0},
+		{Start: 9, End: 10, Target: 10, Slot: 1},
+	}
+
+	fn := build(t, ins, handlers, nil)
+	fn.RemoveUnreachable()
+	if n := fn.CompactTrySlots(); n != 1 {
+		t.Errorf("wrong number of try slots. want=1, got=%d", n)
+	}
+	linearized, linearizedHandlers, _ := fn.Linearize()
+
+	expected := concat(
+		code.Make(code.OpTry, 0),
+		code.Make(code.OpNull),
+		code.Make(code.OpReturnValue),
+	)
+	if !reflect.DeepEqual(linearized, expected) {
+		t.Errorf("wrong instructions.\nwant=\n%s\ngot=\n%s", expected, linearized)
+	}
+
+	// The handler for the slot of the OpTry dropped is dropped too
+	want := []code.Handler{{Start: 3, End: 4, Target: 4, Slot: 0}}
+	if !reflect.DeepEqual(linearizedHandlers, want) {
+		t.Errorf("wrong handlers. want=%v, got=%v", want, linearizedHandlers)
+	}
+}
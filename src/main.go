@@ -1,14 +1,58 @@
 package main
 
 import (
+	"chui/asm"
+	"chui/ast"
+	"chui/compiler"
+	"chui/evaluator"
+	"chui/lexer"
+	"chui/object"
+	"chui/parser"
 	"chui/repl"
+	"chui/vm"
+	"flag"
 	"fmt"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strings"
 )
 
+// fold turns constant folding off when false, to see the bytecode the program's source compiles to as written.
+var fold = flag.Bool("fold", true, "evaluate constant expressions at compile time")
+
+// peephole turns the peephole optimizer off when false, to see the instructions the compiler emits.
+var peephole = flag.Bool("peephole", true, "rewrite short instruction sequences into shorter ones")
+
+// deadcode turns dead code elimination, and the warnings about dead code, off when false.
+var deadcode = flag.Bool("deadcode", true, "drop unreachable code and unused values, with warnings")
+
 // main() - The entry point of the Chui programming language.
+// With a file argument it runs that program, otherwise it starts the REPL. The commands
+// `chui expand [-trace] file` print the program in file after macro expansion,
+// `chui compile [-o out] file` compile it to a bytecode file, `chui run file` runs one and
+// `chui disasm [-ir] file` lists the bytecode of a program or bytecode file. `-fold=false`,
+// `-peephole=false` and `-deadcode=false` before the command turn constant folding, the peephole
+// optimizer and dead code elimination off. Warnings about dead code are printed to stderr.
 func main() {
+	engine := flag.String("engine", "vm", "use 'vm' or 'eval' to run a program file")
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "expand":
+		os.Exit(expandFile(flag.Args()[1:]))
+	case "compile":
+		os.Exit(compileFile(flag.Args()[1:]))
+	case "run":
+		os.Exit(runBytecodeFile(flag.Args()[1:]))
+	case "disasm":
+		os.Exit(disasmFile(flag.Args()[1:]))
+	}
+
+	if flag.NArg() > 0 {
+		os.Exit(runFile(flag.Arg(0), *engine))
+	}
+
 	user, err := user.Current()
 
 	if err != nil {
@@ -30,3 +74,282 @@ func main() {
 	fmt.Printf("Feel free to type in commands\n")
 	repl.Start(os.Stdin, os.Stdout)
 }
+
+// newCompiler() returns a compiler running the optimizations the command line asks for.
+func newCompiler() *compiler.Compiler {
+	comp := compiler.New()
+	comp.SetOptimizations(compiler.Optimizations{Fold: *fold, Peephole: *peephole, DeadCode: *deadcode})
+	return comp
+}
+
+// printWarnings() prints the compiler's warnings about the program in path to stderr.
+func printWarnings(path string, comp *compiler.Compiler) {
+	for _, w := range comp.Warnings() {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s\n", path, w)
+	}
+}
+
+// parseFile() reads and parses the program in path, printing its parse errors to stderr.
+func parseFile(path string) (*ast.Program, bool) {
+	input, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return nil, false
+	}
+
+	p := parser.New(lexer.New(string(input)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, msg)
+		}
+		return nil, false
+	}
+
+	return program, true
+}
+
+// expandFileMacros() expands the macros of the program parsed from path, printing the
+// calls that can't be expanded to stderr. trace is passed on to ExpandMacrosWithTrace.
+func expandFileMacros(path string, program *ast.Program, trace func(evaluator.ExpansionStep)) (ast.Node, bool) {
+	macroEnv := object.NewEnvironment()
+	evaluator.DefineMacros(program, macroEnv)
+	expanded, macroErrors := evaluator.ExpandMacrosWithTrace(program, macroEnv, trace)
+	if len(macroErrors) != 0 {
+		for _, err := range macroErrors {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		}
+		return nil, false
+	}
+
+	return expanded, true
+}
+
+// expandFile() implements the expand command: it prints the program in the file named by
+// args with its macros expanded, and with -trace every expanded macro call before it.
+func expandFile(args []string) int {
+	flags := flag.NewFlagSet("expand", flag.ExitOnError)
+	trace := flags.Bool("trace", false, "print every macro call and what it expands to")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: chui expand [-trace] file\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	path := flags.Arg(0)
+
+	program, ok := parseFile(path)
+	if !ok {
+		return 1
+	}
+
+	var printStep func(evaluator.ExpansionStep)
+	if *trace {
+		printStep = func(step evaluator.ExpansionStep) {
+			fmt.Printf("%s\n\n", step)
+		}
+	}
+
+	expanded, ok := expandFileMacros(path, program, printStep)
+	if !ok {
+		return 1
+	}
+
+	fmt.Print(ast.Format(expanded))
+	return 0
+}
+
+// compileFile() implements the compile command: it compiles the program in the file named
+// by args and writes its bytecode to the -o file, by default the program's path with the
+// extension replaced by .chuic.
+func compileFile(args []string) int {
+	flags := flag.NewFlagSet("compile", flag.ExitOnError)
+	out := flags.String("o", "", "the bytecode file to write")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: chui compile [-o out] file\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	path := flags.Arg(0)
+
+	if *out == "" {
+		*out = strings.TrimSuffix(path, filepath.Ext(path)) + ".chuic"
+	}
+
+	program, ok := parseFile(path)
+	if !ok {
+		return 1
+	}
+
+	expanded, ok := expandFileMacros(path, program, nil)
+	if !ok {
+		return 1
+	}
+
+	comp := newCompiler()
+	if err := comp.Compile(expanded); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 1
+	}
+	printWarnings(path, comp)
+
+	data, err := comp.Bytecode().Encode()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 1
+	}
+
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// runBytecodeFile() implements the run command: it runs the bytecode file named by args with the VM.
+func runBytecodeFile(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: chui run file\n")
+		return 2
+	}
+	path := args[0]
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	bytecode, err := compiler.Decode(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		return 1
+	}
+
+	return runVM(path, bytecode)
+}
+
+// disasmFile() implements the disasm command: it prints the listing of the bytecode file
+// named by args, or of the bytecode a program file compiles to, and with -ir its control
+// flow graph instead.
+func disasmFile(args []string) int {
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	dumpIR := flags.Bool("ir", false, "list the basic blocks of the code and the edges between them")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: chui disasm [-ir] file\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	path := flags.Arg(0)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	var bytecode *compiler.Bytecode
+	if compiler.IsBytecode(data) {
+		bytecode, err = compiler.Decode(data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			return 1
+		}
+	} else {
+		program, ok := parseFile(path)
+		if !ok {
+			return 1
+		}
+
+		expanded, ok := expandFileMacros(path, program, nil)
+		if !ok {
+			return 1
+		}
+
+		comp := newCompiler()
+		if err := comp.Compile(expanded); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			return 1
+		}
+		printWarnings(path, comp)
+		bytecode = comp.Bytecode()
+	}
+
+	if *dumpIR {
+		fmt.Print(asm.DumpIR(bytecode))
+	} else {
+		fmt.Print(asm.Disassemble(bytecode))
+	}
+	return 0
+}
+
+// runFile() runs the program in path with the given engine and returns the process exit status.
+// Errors are printed to stderr, runtime errors as a traceback.
+func runFile(path string, engine string) int {
+	program, ok := parseFile(path)
+	if !ok {
+		return 1
+	}
+
+	// Macros are expanded before the program runs, whichever engine runs it
+	expanded, ok := expandFileMacros(path, program, nil)
+	if !ok {
+		return 1
+	}
+
+	switch engine {
+	case "eval":
+		result := evaluator.Eval(expanded, object.NewEnvironment())
+		if runtimeErr, ok := result.(*object.Error); ok {
+			fmt.Fprintln(os.Stderr, runtimeErr.Traceback(path))
+			return 1
+		}
+		return 0
+
+	case "vm":
+		comp := newCompiler()
+		err := comp.Compile(expanded)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			return 1
+		}
+		printWarnings(path, comp)
+
+		return runVM(path, comp.Bytecode())
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown engine %q, use 'vm' or 'eval'\n", engine)
+		return 2
+	}
+}
+
+// runVM() runs bytecode compiled from path and returns the process exit status, printing
+// runtime errors to stderr as a traceback.
+func runVM(path string, bytecode *compiler.Bytecode) int {
+	err := vm.New(bytecode).Run()
+	if err == nil {
+		return 0
+	}
+
+	if runtimeErr, ok := err.(*object.Error); ok {
+		fmt.Fprintln(os.Stderr, runtimeErr.Traceback(path))
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+	}
+	return 1
+}
@@ -6,6 +6,7 @@ package object
 import (
 	"bytes"
 	"chui/ast"
+	"chui/code"
 	"fmt"
 	"hash/fnv"
 	"strings"
@@ -35,6 +36,9 @@ const (
 	QUOTE_OBJ = "QUOTE"
 
 	MACRO_OBJ = "MACRO"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CLOSURE_OBJ           = "CLOSURE"
 )
 
 type HashKey struct {
@@ -91,15 +95,180 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Error kinds. Errors raised by the runtime itself are RuntimeErrors, `throw` raises
+// an Error unless the thrown hash names its own "kind".
+const (
+	RUNTIME_ERROR = "RuntimeError"
+	THROWN_ERROR  = "Error"
+)
+
+// Names used in stack traces for the top-level program and for functions not bound by a let statement.
+const (
+	MAIN_FUNCTION      = "<main>"
+	ANONYMOUS_FUNCTION = "<anonymous>"
+)
+
+// TraceFrame is an entry of an error's stack trace: a function that was running and the position it was at.
+type TraceFrame struct {
+	Function string
+	Line     int
+	Column   int
+}
+
 type Error struct {
 	Message string
+	Kind    string // one of the error kinds above or a thrown kind, empty means RUNTIME_ERROR
+	Value   Object // the value passed to `throw`, nil for errors raised by the runtime
+	Line    int    // where the error was raised, 0 if unknown
+	Column  int
+	Trace   []TraceFrame // the calls the error was raised in, innermost first, empty if raised at the top level
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
 
+// Error() lets an *Error travel as a Go error, which is how the VM raises them.
+func (e *Error) Error() string { return e.Message }
+
+// ToHash() returns the value a catch clause binds the error to: a hash with the
+// "message", "kind", "line" and "column" keys, on top of the pairs of a thrown hash.
+func (e *Error) ToHash() *Hash {
+	pairs := make(map[HashKey]HashPair)
+
+	if thrown, ok := e.Value.(*Hash); ok {
+		for k, v := range thrown.Pairs {
+			pairs[k] = v
+		}
+	}
+
+	kind := e.kind()
+
+	set := func(key string, value Object) {
+		k := &String{Value: key}
+		pairs[k.HashKey()] = HashPair{Key: k, Value: value}
+	}
+	set("message", &String{Value: e.Message})
+	set("kind", &String{Value: kind})
+	set("line", &Integer{Value: int64(e.Line)})
+	set("column", &Integer{Value: int64(e.Column)})
+
+	return &Hash{Pairs: pairs}
+}
+
+// Traceback() formats the error like a Python traceback, the most recent call last.
+// source names the program in the "File" lines, such as a file name or "<stdin>".
+func (e *Error) Traceback(source string) string {
+	frames := e.Trace
+	if len(frames) == 0 {
+		frames = []TraceFrame{{Function: MAIN_FUNCTION, Line: e.Line, Column: e.Column}}
+	}
+
+	var out bytes.Buffer
+
+	out.WriteString("Traceback (most recent call last):\n")
+
+	// Like Python, a frame repeated by deep recursion is printed a few times and then summarised
+	const maxRepeats = 3
+	repeats := 0
+
+	for i := len(frames) - 1; i >= 0; i-- {
+		f := frames[i]
+
+		if i < len(frames)-1 && f == frames[i+1] {
+			repeats++
+		} else {
+			out.WriteString(repeatedFrames(repeats - maxRepeats))
+			repeats = 1
+		}
+
+		if repeats > maxRepeats {
+			continue
+		}
+
+		function := f.Function
+		if function == "" {
+			function = MAIN_FUNCTION
+		}
+
+		if f.Line == 0 {
+			fmt.Fprintf(&out, "  File %q, in %s\n", source, function)
+		} else {
+			fmt.Fprintf(&out, "  File %q, line %d, column %d, in %s\n", source, f.Line, f.Column, function)
+		}
+	}
+
+	out.WriteString(repeatedFrames(repeats - maxRepeats))
+
+	fmt.Fprintf(&out, "%s: %s", e.kind(), e.Message)
+
+	return out.String()
+}
+
+// repeatedFrames() returns the traceback line standing for n frames left out, nothing if n is not positive.
+func repeatedFrames(n int) string {
+	if n <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("  [Previous line repeated %d more times]\n", n)
+}
+
+// kind() returns the error's kind, errors created without one are RuntimeErrors.
+func (e *Error) kind() string {
+	if e.Kind == "" {
+		return RUNTIME_ERROR
+	}
+
+	return e.Kind
+}
+
+// NewThrownError() creates the error raised by `throw value`. A thrown hash provides the
+// message, kind and position from its keys when it has them, so rethrowing a caught error
+// keeps it intact; any other value is the message.
+func NewThrownError(value Object) *Error {
+	err := &Error{Kind: THROWN_ERROR, Value: value}
+
+	switch value := value.(type) {
+	case *String:
+		err.Message = value.Value
+
+	case *Hash:
+		err.Message = value.Inspect()
+		if message, ok := hashValue(value, "message").(*String); ok {
+			err.Message = message.Value
+		}
+		if kind, ok := hashValue(value, "kind").(*String); ok {
+			err.Kind = kind.Value
+		}
+		if line, ok := hashValue(value, "line").(*Integer); ok {
+			err.Line = int(line.Value)
+		}
+		if column, ok := hashValue(value, "column").(*Integer); ok {
+			err.Column = int(column.Value)
+		}
+
+	default:
+		err.Message = value.Inspect()
+	}
+
+	return err
+}
+
+// hashValue() returns the value stored under a string key, nil if there is none.
+func hashValue(h *Hash, key string) Object {
+	pair, ok := h.Pairs[(&String{Value: key}).HashKey()]
+	if !ok {
+		return nil
+	}
+
+	return pair.Value
+}
+
 type Function struct {
+	Name       string // the name the function was bound to by a let statement, empty if none
 	Parameters []*ast.Identifier
+	Defaults   []ast.Expression // one per parameter, nil entries for parameters without a default
+	Rest       *ast.Identifier  // nil unless the function is variadic
 	Body       *ast.BlockStatement
 	Env        *Environment
 }
@@ -109,9 +278,16 @@ func (f *Function) Inspect() string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, p := range f.Parameters {
+	for i, p := range f.Parameters {
+		if f.Defaults != nil && f.Defaults[i] != nil {
+			params = append(params, p.String()+" = "+f.Defaults[i].String())
+			continue
+		}
 		params = append(params, p.String())
 	}
+	if f.Rest != nil {
+		params = append(params, "..."+f.Rest.String())
+	}
 
 	out.WriteString("fn")
 	out.WriteString("(")
@@ -200,6 +376,7 @@ func (q *Quote) Inspect() string {
 
 type Macro struct {
 	Parameters []*ast.Identifier
+	Rest       *ast.Identifier // nil unless the macro is variadic
 	Body       *ast.BlockStatement
 	Env        *Environment
 }
@@ -212,6 +389,9 @@ func (m *Macro) Inspect() string {
 	for _, p := range m.Parameters {
 		params = append(params, p.String())
 	}
+	if m.Rest != nil {
+		params = append(params, "..."+m.Rest.String())
+	}
 
 	out.WriteString("macro")
 	out.WriteString("(")
@@ -222,3 +402,33 @@ func (m *Macro) Inspect() string {
 
 	return out.String()
 }
+
+// CompiledFunction holds the bytecode of a function literal, produced by the compiler.
+type CompiledFunction struct {
+	Name          string // the name the function was bound to by a let statement, empty if none
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int  // named parameters, not counting the rest parameter
+	NumDefaults   int  // trailing named parameters that have a default value
+	Variadic      bool // extra arguments are collected into an array in the local after the named parameters
+
+	Handlers    []code.Handler // the exception handler table, see code.Handler
+	NumTrySlots int            // the number of stack depths OpTry saves, one per try expression
+	SourceMap   code.SourceMap
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// Closure pairs a compiled function with the free variables it captured when it was created.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
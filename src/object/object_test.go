@@ -58,3 +58,60 @@ func TestIntegerHashKey(t *testing.T) {
 		t.Errorf("integers with twoerent content have same hash keys")
 	}
 }
+
+func TestErrorTraceback(t *testing.T) {
+	tests := []struct {
+		err      *Error
+		expected string
+	}{
+		{
+			&Error{Message: "boom", Line: 3, Column: 7},
+			`Traceback (most recent call last):
+  File "test", line 3, column 7, in <main>
+RuntimeError: boom`,
+		},
+		{
+			&Error{
+				Message: "boom",
+				Kind:    THROWN_ERROR,
+				Trace: []TraceFrame{
+					{Function: "f", Line: 2, Column: 3},
+					{Function: ANONYMOUS_FUNCTION},
+					{Line: 5, Column: 2},
+				},
+			},
+			`Traceback (most recent call last):
+  File "test", line 5, column 2, in <main>
+  File "test", in <anonymous>
+  File "test", line 2, column 3, in f
+Error: boom`,
+		},
+		{
+			&Error{
+				Message: "stack overflow",
+				Trace: []TraceFrame{
+					{Function: "r", Line: 1, Column: 9},
+					{Function: "r", Line: 1, Column: 9},
+					{Function: "r", Line: 1, Column: 9},
+					{Function: "r", Line: 1, Column: 9},
+					{Function: "r", Line: 1, Column: 9},
+					{Function: MAIN_FUNCTION, Line: 2, Column: 2},
+				},
+			},
+			`Traceback (most recent call last):
+  File "test", line 2, column 2, in <main>
+  File "test", line 1, column 9, in r
+  File "test", line 1, column 9, in r
+  File "test", line 1, column 9, in r
+  [Previous line repeated 2 more times]
+RuntimeError: stack overflow`,
+		},
+	}
+
+	for _, tt := range tests {
+		traceback := tt.err.Traceback("test")
+		if traceback != tt.expected {
+			t.Errorf("wrong traceback.\nwant=\n%s\ngot=\n%s", tt.expected, traceback)
+		}
+	}
+}
@@ -69,6 +69,8 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -146,21 +148,30 @@ func (p *Parser) ParseProgram() *ast.Program {
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 	case token.LET:
+		if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+			return p.parseDestructuringLetStatement()
+		}
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
 }
 
-func (p *Parser) parseLetStatement() *ast.LetStatement {
+func (p *Parser) parseLetStatement() ast.Statement {
 	stmt := &ast.LetStatement{Token: p.curToken}
 
 	if !p.expectPeek(token.IDENT) {
 		return nil
 	}
 
+	if p.curToken.Literal == "unquote" && p.peekTokenIs(token.LPAREN) {
+		return p.parseUnquotedLetStatement(stmt.Token)
+	}
+
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
 	if !p.expectPeek(token.ASSIGN) {
@@ -171,6 +182,65 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Value = p.parseExpression(LOWEST)
 
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// parseUnquotedLetStatement parses `let unquote(name) = value;`, which lets quoted macro code
+// bind a name made by gensym(). Until quote() replaces the unquote call with an identifier,
+// the statement is a DestructuringLetStatement with the call as its pattern.
+func (p *Parser) parseUnquotedLetStatement(letToken token.Token) ast.Statement {
+	stmt := &ast.DestructuringLetStatement{Token: letToken}
+
+	function := p.parseIdentifier()
+	p.nextToken()
+	stmt.Pattern = p.parseCallExpression(function)
+
+	if !p.expectPeek(token.ASSIGN) {
+		return nil
+	}
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseDestructuringLetStatement() *ast.DestructuringLetStatement {
+	stmt := &ast.DestructuringLetStatement{Token: p.curToken}
+
+	p.nextToken()
+
+	if p.curTokenIs(token.LBRACKET) {
+		stmt.Pattern = p.parseArrayPattern(true)
+	} else {
+		stmt.Pattern = p.parseHashPattern(true)
+	}
+
+	if stmt.Pattern == nil {
+		return nil
+	}
+
+	if !p.expectPeek(token.ASSIGN) {
+		return nil
+	}
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -192,6 +262,20 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 
@@ -341,6 +425,56 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Body = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken()
+
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			expression.CatchParameter = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+			if !p.expectPeek(token.RPAREN) {
+				return nil
+			}
+		}
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+
+		expression.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+
+		expression.Finally = p.parseBlockStatement()
+	}
+
+	if expression.Catch == nil && expression.Finally == nil {
+		p.errors = append(p.errors, "try without catch or finally")
+		return nil
+	}
+
+	return expression
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
@@ -365,7 +499,13 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Parameters = p.parseFunctionParameters()
+	params := p.parseFunctionParameters()
+	if params == nil {
+		return nil
+	}
+	lit.Parameters = params.identifiers
+	lit.Defaults = params.defaults
+	lit.Rest = params.rest
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
@@ -376,31 +516,71 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return lit
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	identifiers := []*ast.Identifier{}
+// functionParameters is a parsed parameter list, e.g. `(a, b = 10, ...rest)`.
+type functionParameters struct {
+	identifiers []*ast.Identifier
+	defaults    []ast.Expression // nil when no parameter has a default
+	rest        *ast.Identifier
+}
+
+func (p *Parser) parseFunctionParameters() *functionParameters {
+	params := &functionParameters{identifiers: []*ast.Identifier{}}
+	defaults := []ast.Expression{}
+	hasDefaults := false
 
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return identifiers
+		return params
 	}
 
-	p.nextToken()
+	for {
+		p.nextToken()
 
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	identifiers = append(identifiers, ident)
+		if p.curTokenIs(token.ELLIPSIS) {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			params.rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			break
+		}
+
+		if !p.curTokenIs(token.IDENT) {
+			msg := fmt.Sprintf("expected parameter name, got %s instead", p.curToken.Type)
+			p.errors = append(p.errors, msg)
+			return nil
+		}
 
-	for p.peekTokenIs(token.COMMA) {
-		p.nextToken()
-		p.nextToken()
 		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		identifiers = append(identifiers, ident)
+		params.identifiers = append(params.identifiers, ident)
+
+		var defaultValue ast.Expression
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken()
+			p.nextToken()
+			defaultValue = p.parseExpression(LOWEST)
+			hasDefaults = true
+		} else if hasDefaults {
+			msg := fmt.Sprintf("parameter %s without a default follows a parameter with one", ident.Value)
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+		defaults = append(defaults, defaultValue)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
 	}
 
 	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
 
-	return identifiers
+	if hasDefaults {
+		params.defaults = defaults
+	}
+
+	return params
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
@@ -498,7 +678,16 @@ func (p *Parser) parseMacroLiteral() ast.Expression {
 		return nil
 	}
 
-	lit.Parameters = p.parseFunctionParameters()
+	params := p.parseFunctionParameters()
+	if params == nil {
+		return nil
+	}
+	if params.defaults != nil {
+		p.errors = append(p.errors, "macro parameters cannot have defaults")
+		return nil
+	}
+	lit.Parameters = params.identifiers
+	lit.Rest = params.rest
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
@@ -508,3 +697,201 @@ func (p *Parser) parseMacroLiteral() ast.Expression {
 
 	return lit
 }
+
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expression := &ast.MatchExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	expression.Subject = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Arms = []*ast.MatchArm{}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return nil
+		}
+		expression.Arms = append(expression.Arms, arm)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	return expression
+}
+
+func (p *Parser) parseMatchArm() *ast.MatchArm {
+	arm := &ast.MatchArm{Token: p.curToken}
+
+	arm.Pattern = p.parsePattern(false)
+	if arm.Pattern == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.IF) {
+		p.nextToken()
+		p.nextToken()
+		arm.Guard = p.parseExpression(LOWEST)
+	}
+
+	if !p.expectPeek(token.ARROW) {
+		return nil
+	}
+
+	p.nextToken()
+	arm.Body = p.parseExpression(LOWEST)
+
+	return arm
+}
+
+// parsePattern parses the pattern starting at curToken. Patterns are literals,
+// identifiers (bindings, or `_` as a wildcard), array patterns and hash patterns.
+// allowDefaults permits `= default` after the elements of array and hash patterns,
+// which only destructuring let statements support.
+func (p *Parser) parsePattern(allowDefaults bool) ast.Expression {
+	switch p.curToken.Type {
+	case token.IDENT:
+		return p.parseIdentifier()
+	case token.INT:
+		return p.parseIntegerLiteral()
+	case token.STRING:
+		return p.parseStringLiteral()
+	case token.TRUE, token.FALSE:
+		return p.parseBoolean()
+	case token.MINUS:
+		if !p.peekTokenIs(token.INT) {
+			p.peekError(token.INT)
+			return nil
+		}
+		return p.parsePrefixExpression()
+	case token.LBRACKET:
+		return p.parseArrayPattern(allowDefaults)
+	case token.LBRACE:
+		return p.parseHashPattern(allowDefaults)
+	default:
+		msg := fmt.Sprintf("unexpected %s in pattern", p.curToken.Type)
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+}
+
+// parsePatternElement parses a nested pattern, followed by its default value when allowed.
+func (p *Parser) parsePatternElement(allowDefaults bool) ast.Expression {
+	element := p.parsePattern(allowDefaults)
+	if element == nil {
+		return nil
+	}
+
+	return p.parsePatternDefault(element, allowDefaults)
+}
+
+func (p *Parser) parsePatternDefault(target ast.Expression, allowDefaults bool) ast.Expression {
+	if !allowDefaults || !p.peekTokenIs(token.ASSIGN) {
+		return target
+	}
+
+	p.nextToken()
+	pattern := &ast.DefaultPattern{Token: p.curToken, Target: target}
+
+	p.nextToken()
+	pattern.Default = p.parseExpression(LOWEST)
+
+	return pattern
+}
+
+func (p *Parser) parseArrayPattern(allowDefaults bool) ast.Expression {
+	pattern := &ast.ArrayPattern{Token: p.curToken}
+	pattern.Elements = []ast.Expression{}
+
+	for !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+
+		if p.curTokenIs(token.ELLIPSIS) {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			pattern.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			break
+		}
+
+		element := p.parsePatternElement(allowDefaults)
+		if element == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, element)
+
+		if !p.peekTokenIs(token.RBRACKET) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return pattern
+}
+
+func (p *Parser) parseHashPattern(allowDefaults bool) ast.Expression {
+	pattern := &ast.HashPattern{Token: p.curToken}
+	pattern.Pairs = []ast.HashPatternPair{}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+
+		var key, value ast.Expression
+
+		if p.curTokenIs(token.IDENT) && !p.peekTokenIs(token.COLON) {
+			// Shorthand `{name}` binds the "name" entry to `name`
+			key = &ast.StringLiteral{
+				Token: token.Token{Type: token.STRING, Literal: p.curToken.Literal},
+				Value: p.curToken.Literal,
+			}
+			value = p.parsePatternDefault(p.parseIdentifier(), allowDefaults)
+		} else {
+			key = p.parseExpression(LOWEST)
+
+			if !p.expectPeek(token.COLON) {
+				return nil
+			}
+
+			p.nextToken()
+			value = p.parsePatternElement(allowDefaults)
+		}
+
+		if value == nil {
+			return nil
+		}
+
+		pattern.Pairs = append(pattern.Pairs, ast.HashPatternPair{Key: key, Value: value})
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	return pattern
+}
@@ -586,6 +586,119 @@ func TestFunctionParameterParsing(t *testing.T) {
 	}
 }
 
+func TestDefaultAndRestParameterParsing(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedParams   []string
+		expectedDefaults []string
+		expectedRest     string
+	}{
+		{"func(a, b = 10) {};", []string{"a", "b"}, []string{"", "10"}, ""},
+		{"func(a = 1, b = a * 2) {};", []string{"a", "b"}, []string{"1", "(a * 2)"}, ""},
+		{"func(...rest) {};", []string{}, nil, "rest"},
+		{"func(a, b = 10, ...rest) {};", []string{"a", "b"}, []string{"", "10"}, "rest"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		function := stmt.Expression.(*ast.FunctionLiteral)
+
+		if len(function.Parameters) != len(tt.expectedParams) {
+			t.Fatalf("length parameters wrong. want %d, got=%d\n",
+				len(tt.expectedParams), len(function.Parameters))
+		}
+
+		for i, ident := range tt.expectedParams {
+			testLiteralExpression(t, function.Parameters[i], ident)
+		}
+
+		if tt.expectedDefaults == nil && function.Defaults != nil {
+			t.Errorf("function should not have defaults. got=%v", function.Defaults)
+		}
+
+		for i, expected := range tt.expectedDefaults {
+			actual := function.Defaults[i]
+			if expected == "" {
+				if actual != nil {
+					t.Errorf("parameter %d should not have a default. got=%s", i, actual)
+				}
+				continue
+			}
+			if actual == nil || actual.String() != expected {
+				t.Errorf("parameter %d default wrong. want=%q, got=%v", i, expected, actual)
+			}
+		}
+
+		if tt.expectedRest == "" {
+			if function.Rest != nil {
+				t.Errorf("function should not be variadic. got=%s", function.Rest)
+			}
+		} else {
+			testLiteralExpression(t, function.Rest, tt.expectedRest)
+		}
+	}
+}
+
+func TestFunctionParameterParsingErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"func(a = 1, b) {}", "parameter b without a default follows a parameter with one"},
+		{"func(...rest, a) {}", "expected next token to be ), got , instead"},
+		{"func(1) {}", "expected parameter name, got INT instead"},
+		{"macro(a = 1) {}", "macro parameters cannot have defaults"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q, got none", tt.input)
+			continue
+		}
+
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong error for %q. want=%q, got=%q",
+				tt.input, tt.expectedError, errors[0])
+		}
+	}
+}
+
+func TestFunctionLiteralWithName(t *testing.T) {
+	input := `let myFunction = func() { };`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.LetStatement. got=%T",
+			program.Statements[0])
+	}
+
+	function, ok := stmt.Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("stmt.Value is not ast.FunctionLiteral. got=%T",
+			stmt.Value)
+	}
+
+	if function.Name != "myFunction" {
+		t.Fatalf("function literal name wrong. want 'myFunction', got=%q\n",
+			function.Name)
+	}
+}
+
 func TestCallExpressionParsing(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 
@@ -1116,6 +1229,319 @@ func TestMacroLiteralParsing(t *testing.T) {
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
 
+func TestVariadicMacroLiteralParsing(t *testing.T) {
+	input := `macro(first, ...rest) { quote(unquote(first)) }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	macro, ok := stmt.Expression.(*ast.MacroLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MacroLiteral. got=%T", stmt.Expression)
+	}
+
+	if len(macro.Parameters) != 1 {
+		t.Fatalf("macro literal parameters wrong. want 1, got=%d", len(macro.Parameters))
+	}
+	testLiteralExpression(t, macro.Parameters[0], "first")
+
+	if macro.Rest == nil {
+		t.Fatalf("macro.Rest is nil")
+	}
+	testLiteralExpression(t, macro.Rest, "rest")
+
+	expected := "macro(first, ...rest) quote(unquote(first))"
+	if macro.String() != expected {
+		t.Errorf("macro.String() wrong. want=%q, got=%q", expected, macro.String())
+	}
+}
+
+func TestMatchExpressionParsing(t *testing.T) {
+	input := `match (x) { [first, ...rest] => first, {"name": n} => n, 0 => 1, y if y > 5 => y, _ => -1 }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("statement is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	match, ok := stmt.Expression.(*ast.MatchExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MatchExpression. got=%T",
+			stmt.Expression)
+	}
+
+	if !testIdentifier(t, match.Subject, "x") {
+		return
+	}
+
+	if len(match.Arms) != 5 {
+		t.Fatalf("match.Arms has not 5 arms. got=%d", len(match.Arms))
+	}
+
+	array, ok := match.Arms[0].Pattern.(*ast.ArrayPattern)
+	if !ok {
+		t.Fatalf("arm 0 pattern is not ast.ArrayPattern. got=%T",
+			match.Arms[0].Pattern)
+	}
+	if len(array.Elements) != 1 {
+		t.Fatalf("array pattern has not 1 element. got=%d", len(array.Elements))
+	}
+	testIdentifier(t, array.Elements[0], "first")
+	if array.Rest == nil || array.Rest.Value != "rest" {
+		t.Fatalf("array pattern rest is not 'rest'. got=%v", array.Rest)
+	}
+	testIdentifier(t, match.Arms[0].Body, "first")
+
+	hash, ok := match.Arms[1].Pattern.(*ast.HashPattern)
+	if !ok {
+		t.Fatalf("arm 1 pattern is not ast.HashPattern. got=%T",
+			match.Arms[1].Pattern)
+	}
+	if len(hash.Pairs) != 1 {
+		t.Fatalf("hash pattern has not 1 pair. got=%d", len(hash.Pairs))
+	}
+	key, ok := hash.Pairs[0].Key.(*ast.StringLiteral)
+	if !ok || key.Value != "name" {
+		t.Fatalf("hash pattern key is not \"name\". got=%T (%+v)",
+			hash.Pairs[0].Key, hash.Pairs[0].Key)
+	}
+	testIdentifier(t, hash.Pairs[0].Value, "n")
+
+	testLiteralExpression(t, match.Arms[2].Pattern, 0)
+	testLiteralExpression(t, match.Arms[2].Body, 1)
+
+	testIdentifier(t, match.Arms[3].Pattern, "y")
+	testInfixExpression(t, match.Arms[3].Guard, "y", ">", 5)
+
+	testIdentifier(t, match.Arms[4].Pattern, "_")
+	if match.Arms[4].Guard != nil {
+		t.Errorf("arm 4 should not have a guard. got=%s", match.Arms[4].Guard)
+	}
+}
+
+func TestDestructuringLetStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [a, b] = arr;", "let [a, b] = arr;"},
+		{"let [a, b = 10, ...rest] = arr;", "let [a, b = 10, ...rest] = arr;"},
+		{"let [[a, b], c] = arr;", "let [[a, b], c] = arr;"},
+		{"let {name, age} = person;", "let {name:name, age:age} = person;"},
+		{"let {name, age = 1 + 2} = person;", "let {name:name, age:age = (1 + 2)} = person;"},
+		{`let {"first": f, "tags": [t]} = person;`, "let {first:f, tags:[t]} = person;"},
+		// Quoted macro code binds a name computed by unquote
+		{"let unquote(name) = 1 + 2;", "let unquote(name) = (1 + 2);"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+				len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.DestructuringLetStatement)
+		if !ok {
+			t.Fatalf("statement is not ast.DestructuringLetStatement. got=%T",
+				program.Statements[0])
+		}
+
+		if stmt.String() != tt.expected {
+			t.Errorf("wrong statement. want=%q, got=%q", tt.expected, stmt.String())
+		}
+	}
+}
+
+func TestMatchExpressionParsingErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"match (x) { 1 + 2 => 3 }", "expected next token to be =>, got + instead"},
+		{"match (x) { [a, ...] => a }", "expected next token to be IDENT, got ] instead"},
+		{"match (x) { func => 1 }", "unexpected FUNCTION in pattern"},
+		{"match (x) { [a = 1] => a }", "expected next token to be ,, got = instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q, got none", tt.input)
+			continue
+		}
+
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong error for %q. want=%q, got=%q",
+				tt.input, tt.expectedError, errors[0])
+		}
+	}
+}
+
+func TestThrowStatement(t *testing.T) {
+	input := `throw "boom";`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ThrowStatement)
+	if !ok {
+		t.Fatalf("statement is not ast.ThrowStatement. got=%T", program.Statements[0])
+	}
+
+	if stmt.TokenLiteral() != "throw" {
+		t.Fatalf("stmt.TokenLiteral not 'throw', got %q", stmt.TokenLiteral())
+	}
+
+	if stmt.String() != `throw boom;` {
+		t.Errorf("wrong statement. got=%q", stmt.String())
+	}
+}
+
+func TestTryExpressionParsing(t *testing.T) {
+	tests := []struct {
+		input          string
+		catchParameter string
+		hasCatch       bool
+		hasFinally     bool
+	}{
+		{"try { x } catch (e) { e }", "e", true, false},
+		{"try { x } catch { 1 }", "", true, false},
+		{"try { x } finally { y }", "", false, true},
+		{"try { x } catch (err) { 1 } finally { y }", "err", true, true},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+				len(program.Statements))
+		}
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.TryExpression)
+		if !ok {
+			t.Fatalf("exp not *ast.TryExpression. got=%T", stmt.Expression)
+		}
+
+		if len(exp.Body.Statements) != 1 {
+			t.Errorf("try body is not 1 statements. got=%d", len(exp.Body.Statements))
+		}
+
+		if (exp.Catch != nil) != tt.hasCatch {
+			t.Errorf("catch clause presence wrong for %q", tt.input)
+		}
+
+		if (exp.Finally != nil) != tt.hasFinally {
+			t.Errorf("finally clause presence wrong for %q", tt.input)
+		}
+
+		if tt.catchParameter == "" {
+			if exp.CatchParameter != nil {
+				t.Errorf("expected no catch parameter, got=%s", exp.CatchParameter)
+			}
+			continue
+		}
+
+		if !testIdentifier(t, exp.CatchParameter, tt.catchParameter) {
+			return
+		}
+	}
+}
+
+func TestTryExpressionParsingErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"try { x }", "try without catch or finally"},
+		{"try { x } catch (1) { 1 }", "expected next token to be IDENT, got INT instead"},
+		{"try x catch { 1 }", "expected next token to be {, got IDENT instead"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("expected parser errors for %q, got none", tt.input)
+			continue
+		}
+
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong error for %q. want=%q, got=%q",
+				tt.input, tt.expectedError, errors[0])
+		}
+	}
+}
+
+func TestFormattedProgramsParseBack(t *testing.T) {
+	tests := []string{
+		"let x = 1 + 2 * 3 - (4 - 5); -(a + b) * !c;",
+		"let add = func(a, b = 2, ...rest) { return a + b; }; add(1)(2)[0];",
+		"func(x) { x }(3); if (x < 1) { puts(\"small\") } else { [1, {\"a\": true}] }",
+		"let [a, b = 10, ...rest] = arr; let {name, \"age\": years = 0} = person;",
+		"match (x) { [first, ...rest] if first > 0 => rest, {\"k\": v} => v, _ => 0 }",
+		"try { throw 1 } catch (e) { e } finally { puts(1) }; try { 1 } catch { 2 };",
+		"let m = macro(a, ...rest) { quote(unquote(a) + unquote_splicing(rest)) };",
+	}
+
+	for _, input := range tests {
+		p := New(lexer.New(input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		formatted := ast.Format(program)
+		p = New(lexer.New(formatted))
+		reparsed := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if !ast.Equal(program, reparsed) {
+			t.Errorf("formatted program parses to a different tree.\ninput=%q\nformatted=%q\nreparsed=%q",
+				input, formatted, reparsed.String())
+		}
+		if ast.Format(reparsed) != formatted {
+			t.Errorf("formatting is not stable for %q.\nfirst=%q\nsecond=%q",
+				input, formatted, ast.Format(reparsed))
+		}
+	}
+}
+
 func checkParserErrors(t *testing.T, p *Parser) {
 	errors := p.Errors()
 	if len(errors) == 0 {
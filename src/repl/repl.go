@@ -5,13 +5,16 @@ package repl
 
 import (
 	"bufio"
+	"chui/ast"
 	"chui/compiler"
+	"chui/evaluator"
 	"chui/lexer"
 	"chui/object"
 	"chui/parser"
 	"chui/vm"
 	"fmt"
 	"io"
+	"strings"
 )
 
 const PROMPT = ">> "
@@ -24,6 +27,12 @@ func Start(in io.Reader, out io.Writer) {
 	constants := []object.Object{}
 	globals := make([]object.Object, vm.GlobalsSize)
 	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	// Macros defined on one line stay available on the next ones
+	macroEnv := object.NewEnvironment()
 
 	for {
 		fmt.Fprint(out, PROMPT)
@@ -33,6 +42,11 @@ func Start(in io.Reader, out io.Writer) {
 		}
 
 		line := scanner.Text()
+		if command, code, ok := metaCommand(line); ok {
+			expandLine(out, command, code, macroEnv)
+			continue
+		}
+
 		l := lexer.New(line)
 		p := parser.New(l)
 
@@ -42,30 +56,83 @@ func Start(in io.Reader, out io.Writer) {
 			continue
 		}
 
+		evaluator.DefineMacros(program, macroEnv)
+		expanded, macroErrors := evaluator.ExpandMacros(program, macroEnv)
+		if len(macroErrors) != 0 {
+			printMacroErrors(out, macroErrors)
+			continue
+		}
+
 		comp := compiler.NewWithState(symbolTable, constants)
-		err := comp.Compile(program)
+		err := comp.Compile(expanded)
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Compilation failed:\n%s\n", err)
 			continue
 		}
 
 		code := comp.Bytecode()
-		// constants := code.Constants
+		constants = code.Constants
 
 		machine := vm.NewWithGlobalStore(code, globals)
 		err = machine.Run()
+		globals = machine.Globals()
 		if err != nil {
-			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n%s\n", err)
+			printRuntimeError(out, err)
 			continue
 		}
 
+		// A line that only defines macros leaves nothing to print
 		lastPopped := machine.LastPoppedStackElem()
+		if lastPopped == nil {
+			continue
+		}
+
 		io.WriteString(out, lastPopped.Inspect())
 		io.WriteString(out, "\n")
 
 	}
 }
 
+// metaCommand splits a line such as `:expand unless(x, y)` into the command and the code after it.
+func metaCommand(line string) (command, code string, ok bool) {
+	trimmed := strings.TrimSpace(line)
+	for _, command := range []string{":expand", ":trace"} {
+		if trimmed == command || strings.HasPrefix(trimmed, command+" ") {
+			return command, strings.TrimSpace(strings.TrimPrefix(trimmed, command)), true
+		}
+	}
+	return "", "", false
+}
+
+// expandLine prints code with its macros expanded, using the macros defined so far, without
+// running it. The :trace command prints every expanded macro call first. Macros that code
+// defines are only used for the preview.
+func expandLine(out io.Writer, command, code string, macroEnv *object.Environment) {
+	p := parser.New(lexer.New(code))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(out, p.Errors())
+		return
+	}
+
+	var trace func(evaluator.ExpansionStep)
+	if command == ":trace" {
+		trace = func(step evaluator.ExpansionStep) {
+			io.WriteString(out, step.String()+"\n\n")
+		}
+	}
+
+	previewEnv := object.NewEnclosedEnvironment(macroEnv)
+	evaluator.DefineMacros(program, previewEnv)
+	expanded, macroErrors := evaluator.ExpandMacrosWithTrace(program, previewEnv, trace)
+	if len(macroErrors) != 0 {
+		printMacroErrors(out, macroErrors)
+		return
+	}
+
+	io.WriteString(out, ast.Format(expanded))
+}
+
 const chui_FACE = `
 ░▒▓██████▓▒░░▒▓█▓▒░░▒▓█▓▒░▒▓█▓▒░░▒▓█▓▒░▒▓█▓▒░ 
 ░▒▓█▓▒░░▒▓█▓▒░▒▓█▓▒░░▒▓█▓▒░▒▓█▓▒░░▒▓█▓▒░▒▓█▓▒░ 
@@ -78,10 +145,36 @@ const chui_FACE = `
 
 // printParserErrors is a helper function that prints parser errors to the output writer.
 func printParserErrors(out io.Writer, errors []string) {
+	printErrors(out, "parser", errors)
+}
+
+// printMacroErrors prints the macro calls that could not be expanded, like parser errors.
+func printMacroErrors(out io.Writer, errors []*evaluator.MacroError) {
+	messages := []string{}
+	for _, err := range errors {
+		messages = append(messages, err.Error())
+	}
+
+	printErrors(out, "macro", messages)
+}
+
+func printErrors(out io.Writer, phase string, errors []string) {
 	io.WriteString(out, chui_FACE)
 	io.WriteString(out, "Woops! We ran into some monkey business here!\n")
-	io.WriteString(out, " parser errors:\n")
+	io.WriteString(out, " "+phase+" errors:\n")
 	for _, msg := range errors {
 		io.WriteString(out, "\t"+msg+"\n")
 	}
 }
+
+// printRuntimeError prints an error raised while running the program, as a traceback when it has one.
+func printRuntimeError(out io.Writer, err error) {
+	io.WriteString(out, "Woops! Executing bytecode failed:\n")
+
+	if runtimeErr, ok := err.(*object.Error); ok {
+		io.WriteString(out, runtimeErr.Traceback("<stdin>")+"\n")
+		return
+	}
+
+	io.WriteString(out, err.Error()+"\n")
+}
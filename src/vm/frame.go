@@ -0,0 +1,69 @@
+package vm
+
+import (
+	"chui/code"
+	"chui/object"
+)
+
+// Frame holds the execution state of a single function call.
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int           // the stack pointer before the call, where the frame's locals start
+	numArgs     int           // the number of named parameters the caller passed arguments for
+	trySP       []int         // the stack pointers saved by OpTry, indexed by try slot
+	caught      []caughtError // the error the handlers of each try slot last caught, indexed by try slot
+}
+
+// caughtError is an error a handler caught, along with the hash the VM pushed for it.
+type caughtError struct {
+	hash *object.Hash
+	err  *object.Error
+}
+
+// NewFrame() creates a frame for calling cl, its locals starting at basePointer.
+func NewFrame(cl *object.Closure, basePointer int, numArgs int) *Frame {
+	f := &Frame{
+		cl:          cl,
+		ip:          -1,
+		basePointer: basePointer,
+		numArgs:     numArgs,
+	}
+
+	if cl.Fn.NumTrySlots > 0 {
+		f.trySP = make([]int, cl.Fn.NumTrySlots)
+		f.caught = make([]caughtError, cl.Fn.NumTrySlots)
+	}
+
+	return f
+}
+
+// Instructions() returns the instructions of the function being executed.
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
+
+// readOperand() reads the next operand of the instruction being executed, which is width bytes wide, or as wide as
+// OpWide makes it when wide is set, and moves ip past it.
+func (f *Frame) readOperand(width int, wide bool) int {
+	if wide {
+		width = code.WideOperandWidth(width)
+	}
+
+	operand := code.ReadOperand(f.cl.Fn.Instructions[f.ip+1:], width)
+	f.ip += width
+
+	return operand
+}
+
+// rethrown() returns the error a handler of the frame caught as value, nil if value isn't the hash of one. Throwing
+// that hash again, as a finally handler does, raises the error as it was, with the position and trace it was raised with.
+func (f *Frame) rethrown(value object.Object) *object.Error {
+	for _, c := range f.caught {
+		if c.hash != nil && c.hash == value {
+			return c.err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,395 @@
+package vm
+
+import (
+	"chui/code"
+	"chui/compiler"
+	"chui/object"
+	"fmt"
+)
+
+// StackDepths holds the most values each code block of verified bytecode has on the stack
+// at once, on top of its locals.
+type StackDepths struct {
+	Main      int
+	Functions map[int]int // by the index of the function in the constant pool
+}
+
+// Verify() checks that bytecode can run without crashing the VM: every opcode is defined,
+// operands refer to existing constants, globals, locals, builtins, free variables and try slots,
+// functions have room for their parameters, no block has more try slots than OpTry instructions,
+// jumps and handlers land on instructions, and every instruction is reached with the same
+// stack depth on every path, never taking more values off the stack than are on it.
+// Functions must end every path with a return. It returns the stack depth each block needs.
+func Verify(bytecode *compiler.Bytecode) (*StackDepths, error) {
+	v := &verifier{constants: bytecode.Constants}
+
+	blocks := []*verifiedBlock{{
+		name:        "main",
+		ins:         bytecode.Instructions,
+		handlers:    bytecode.Handlers,
+		numTrySlots: bytecode.NumTrySlots,
+	}}
+	for i, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			blocks = append(blocks, &verifiedBlock{
+				name:        fmt.Sprintf("function %q (constant %d)", fn.Name, i),
+				ins:         fn.Instructions,
+				handlers:    fn.Handlers,
+				numTrySlots: fn.NumTrySlots,
+				fn:          fn,
+				index:       i,
+			})
+		}
+	}
+
+	for _, b := range blocks {
+		if err := v.decode(b); err != nil {
+			return nil, err
+		}
+	}
+
+	// The free variables a function can read are the ones every OpClosure creating it captures
+	v.numFree = map[int]int{}
+	for _, b := range blocks {
+		for _, in := range b.instructions {
+			if in.op == code.OpClosure {
+				index, numFree := in.operands[0], in.operands[1]
+				if n, ok := v.numFree[index]; !ok || numFree < n {
+					v.numFree[index] = numFree
+				}
+			}
+		}
+	}
+
+	depths := &StackDepths{Functions: map[int]int{}}
+	for _, b := range blocks {
+		if err := v.checkLayout(b); err != nil {
+			return nil, err
+		}
+		if err := v.checkOperands(b); err != nil {
+			return nil, err
+		}
+		if err := v.checkHandlers(b); err != nil {
+			return nil, err
+		}
+
+		maxDepth, err := v.checkStack(b)
+		if err != nil {
+			return nil, err
+		}
+
+		if b.fn == nil {
+			depths.Main = maxDepth
+		} else {
+			depths.Functions[b.index] = maxDepth
+		}
+	}
+
+	return depths, nil
+}
+
+type verifier struct {
+	constants []object.Object
+	numFree   map[int]int // by the constant index of a function, missing for functions never closed over
+}
+
+// verifiedBlock is the main program or a compiled function being verified.
+type verifiedBlock struct {
+	name        string
+	ins         code.Instructions
+	handlers    []code.Handler
+	numTrySlots int
+	fn          *object.CompiledFunction // nil for the main program
+	index       int                      // the function's index in the constant pool
+
+	instructions map[int]verifiedInstruction // by offset
+	offsets      []int                       // the offsets of the instructions, in order
+}
+
+type verifiedInstruction struct {
+	op       code.Opcode
+	def      *code.Definition
+	operands []int
+	next     int // the offset of the instruction after this one
+}
+
+func (b *verifiedBlock) errorf(offset int, format string, a ...interface{}) error {
+	return fmt.Errorf("invalid bytecode in %s at %04d: %s", b.name, offset, fmt.Sprintf(format, a...))
+}
+
+// isTarget() reports whether offset is somewhere a jump or handler may go: the start of an
+// instruction or the end of the block.
+func (b *verifiedBlock) isTarget(offset int) bool {
+	_, ok := b.instructions[offset]
+	return ok || offset == len(b.ins)
+}
+
+// decode() splits the block into instructions, failing on undefined opcodes, missing operands and misplaced OpWide prefixes.
+func (v *verifier) decode(b *verifiedBlock) error {
+	b.instructions = map[int]verifiedInstruction{}
+
+	for i := 0; i < len(b.ins); {
+		op, def, operands, read, err := code.ReadInstruction(b.ins[i:])
+		if err != nil {
+			return b.errorf(i, "%s", err)
+		}
+
+		b.instructions[i] = verifiedInstruction{op: op, def: def, operands: operands, next: i + read}
+		b.offsets = append(b.offsets, i)
+		i += read
+	}
+
+	return nil
+}
+
+// checkLayout() checks that the block's function has room in its locals for its parameters and that the block has
+// no more try slots than OpTry instructions to save them, as the VM allocates both when it calls the function.
+func (v *verifier) checkLayout(b *verifiedBlock) error {
+	numTries := 0
+	for _, offset := range b.offsets {
+		if b.instructions[offset].op == code.OpTry {
+			numTries++
+		}
+	}
+	if b.numTrySlots > numTries {
+		return fmt.Errorf("invalid bytecode in %s: %d try slots for %d OpTry instructions", b.name, b.numTrySlots, numTries)
+	}
+
+	if b.fn == nil {
+		return nil
+	}
+
+	fn := b.fn
+	if fn.NumDefaults > fn.NumParameters {
+		return fmt.Errorf("invalid bytecode in %s: %d defaults for %d parameters", b.name, fn.NumDefaults, fn.NumParameters)
+	}
+
+	// The rest array of a variadic function takes the local after its parameters
+	needed := fn.NumParameters
+	if fn.Variadic {
+		needed++
+	}
+	if needed > fn.NumLocals {
+		return fmt.Errorf("invalid bytecode in %s: its parameters need %d locals, it has %d", b.name, needed, fn.NumLocals)
+	}
+
+	return nil
+}
+
+// checkOperands() checks that the operands of every instruction refer to something that exists.
+func (v *verifier) checkOperands(b *verifiedBlock) error {
+	numLocals, numParameters := 0, 0
+	if b.fn != nil {
+		numLocals, numParameters = b.fn.NumLocals, b.fn.NumParameters
+	}
+
+	for _, offset := range b.offsets {
+		in := b.instructions[offset]
+
+		switch in.op {
+		case code.OpConstant:
+			if in.operands[0] >= len(v.constants) {
+				return b.errorf(offset, "constant %d out of range, the pool has %d", in.operands[0], len(v.constants))
+			}
+
+		case code.OpClosure:
+			if in.operands[0] >= len(v.constants) {
+				return b.errorf(offset, "constant %d out of range, the pool has %d", in.operands[0], len(v.constants))
+			}
+			if _, ok := v.constants[in.operands[0]].(*object.CompiledFunction); !ok {
+				return b.errorf(offset, "constant %d is not a function", in.operands[0])
+			}
+
+		case code.OpGetGlobal, code.OpSetGlobal:
+			if in.operands[0] >= MaxGlobals {
+				return b.errorf(offset, "global %d out of range, there can be %d", in.operands[0], MaxGlobals)
+			}
+
+		case code.OpGetLocal, code.OpSetLocal:
+			if in.operands[0] >= numLocals {
+				return b.errorf(offset, "local %d out of range, %s has %d", in.operands[0], b.name, numLocals)
+			}
+
+		case code.OpGetBuiltin:
+			if in.operands[0] >= len(object.Builtins) {
+				return b.errorf(offset, "builtin %d out of range, there are %d", in.operands[0], len(object.Builtins))
+			}
+
+		case code.OpGetFree:
+			numFree := 0
+			if b.fn != nil {
+				n, ok := v.numFree[b.index]
+				if !ok {
+					// The function is never closed over, so it never runs
+					continue
+				}
+				numFree = n
+			}
+			if in.operands[0] >= numFree {
+				return b.errorf(offset, "free variable %d out of range, %s has %d", in.operands[0], b.name, numFree)
+			}
+
+		case code.OpJumpIfArgPassed:
+			if in.operands[0] >= numParameters {
+				return b.errorf(offset, "parameter %d out of range, %s has %d", in.operands[0], b.name, numParameters)
+			}
+
+		case code.OpTry:
+			if in.operands[0] >= b.numTrySlots {
+				return b.errorf(offset, "try slot %d out of range, %s has %d", in.operands[0], b.name, b.numTrySlots)
+			}
+
+		case code.OpHash:
+			if in.operands[0]%2 != 0 {
+				return b.errorf(offset, "OpHash needs an even number of keys and values, got %d", in.operands[0])
+			}
+
+		case code.OpReturn, code.OpReturnValue:
+			if in.op == code.OpReturn && b.fn == nil {
+				return b.errorf(offset, "OpReturn outside of a function")
+			}
+		}
+
+		if jumpIndex, ok := code.JumpOperand(in.op); ok && !b.isTarget(in.operands[jumpIndex]) {
+			return b.errorf(offset, "jump to %04d, which is not the start of an instruction", in.operands[jumpIndex])
+		}
+	}
+
+	return nil
+}
+
+func (v *verifier) checkHandlers(b *verifiedBlock) error {
+	for i, h := range b.handlers {
+		switch {
+		case !b.isTarget(h.Start) || !b.isTarget(h.End) || h.Start > h.End:
+			return fmt.Errorf("invalid bytecode in %s: handler %d covers %04d to %04d, which is not a range of instructions",
+				b.name, i, h.Start, h.End)
+		case !b.isTarget(h.Target):
+			return fmt.Errorf("invalid bytecode in %s: handler %d resumes at %04d, which is not the start of an instruction",
+				b.name, i, h.Target)
+		case h.Slot >= b.numTrySlots:
+			return fmt.Errorf("invalid bytecode in %s: handler %d uses try slot %d, %s has %d",
+				b.name, i, h.Slot, b.name, b.numTrySlots)
+		}
+	}
+
+	return nil
+}
+
+// checkStack() follows every path through the block from its first instruction and from
+// the handlers, tracking the depth of the stack, and returns the deepest it gets.
+func (v *verifier) checkStack(b *verifiedBlock) (int, error) {
+	depths := map[int]int{} // the depth before the instruction at an offset
+	trySlotDepths := map[int]int{}
+	work := []int{}
+	maxDepth := 0
+
+	reach := func(from, offset, depth int) error {
+		if offset == len(b.ins) {
+			if b.fn != nil {
+				return b.errorf(from, "execution runs past the end of the function")
+			}
+			return nil
+		}
+
+		if known, ok := depths[offset]; ok {
+			if known != depth {
+				return b.errorf(offset, "reached with %d values on the stack from %04d, with %d on another path",
+					depth, from, known)
+			}
+			return nil
+		}
+
+		depths[offset] = depth
+		work = append(work, offset)
+		return nil
+	}
+
+	if err := reach(0, 0, 0); err != nil {
+		return 0, err
+	}
+
+	for len(work) > 0 {
+		offset := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		in := b.instructions[offset]
+		depth := depths[offset]
+
+		pops, pushes := stackEffect(in.op, in.operands)
+		if depth < pops {
+			return 0, b.errorf(offset, "%s takes %d values off the stack, which holds %d", in.def.Name, pops, depth)
+		}
+		after := depth - pops + pushes
+		if after > maxDepth {
+			maxDepth = after
+		}
+
+		if in.op == code.OpTry {
+			slot := in.operands[0]
+			if known, ok := trySlotDepths[slot]; ok && known != depth {
+				return 0, b.errorf(offset, "try slot %d saved with %d values on the stack, with %d elsewhere", slot, depth, known)
+			}
+			trySlotDepths[slot] = depth
+
+			// A handler resumes with the stack as OpTry saved it, plus the error
+			for _, h := range b.handlers {
+				if h.Slot == slot {
+					if err := reach(offset, h.Target, depth+1); err != nil {
+						return 0, err
+					}
+				}
+			}
+		}
+
+		if jumpIndex, ok := code.JumpOperand(in.op); ok {
+			if err := reach(offset, in.operands[jumpIndex], after); err != nil {
+				return 0, err
+			}
+		}
+
+		switch in.op {
+		case code.OpJump, code.OpReturn, code.OpReturnValue, code.OpThrow, code.OpNoMatch:
+			// Nothing runs after these
+		default:
+			if err := reach(offset, in.next, after); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return maxDepth, nil
+}
+
+// stackEffect() returns how many values an instruction takes off the stack and how many it puts on.
+func stackEffect(op code.Opcode, operands []int) (int, int) {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpGetGlobal, code.OpGetLocal,
+		code.OpGetBuiltin, code.OpGetFree, code.OpCurrentClosure:
+		return 0, 1
+
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpEqual, code.OpNotEqual, code.OpGreaterThan,
+		code.OpIndex, code.OpMatchEqual, code.OpHasKey:
+		return 2, 1
+
+	case code.OpMinus, code.OpBang, code.OpMatchArray, code.OpMatchArrayRest, code.OpMatchHash, code.OpArraySlice:
+		return 1, 1
+
+	case code.OpPop, code.OpSetGlobal, code.OpSetLocal, code.OpJumpNotTruthy, code.OpThrow, code.OpNoMatch,
+		code.OpReturnValue:
+		return 1, 0
+
+	case code.OpArray, code.OpHash:
+		return operands[0], 1
+
+	case code.OpCall:
+		// The function and its arguments are replaced by the result
+		return operands[0] + 1, 1
+
+	case code.OpClosure:
+		return operands[1], 1
+	}
+
+	// OpJump, OpJumpIfArgPassed, OpTry and OpReturn leave the stack alone
+	return 0, 0
+}
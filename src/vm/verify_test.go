@@ -0,0 +1,199 @@
+package vm
+
+import (
+	"chui/asm"
+	"chui/compiler"
+	"chui/object"
+	"reflect"
+	"testing"
+)
+
+func TestVerifyStackDepths(t *testing.T) {
+	listing := `
+.const
+    int 1
+    func "add" params=2 locals=2 defaults=0 variadic=false
+.main
+    OpClosure 1 0
+    OpConstant 0
+    OpConstant 0
+    OpConstant 0
+    OpArray 2
+    OpCall 2
+    OpPop
+.func 1 tryslots=1
+    OpTry 0
+start:
+    OpGetLocal 0
+    OpGetLocal 1
+    OpAdd
+    OpReturnValue
+caught:
+    OpPop
+    OpNull
+    OpReturnValue
+    .handler start caught caught 0`
+
+	bytecode, err := asm.Assemble(listing)
+	if err != nil {
+		t.Fatalf("assembler error: %s", err)
+	}
+
+	depths, err := Verify(bytecode)
+	if err != nil {
+		t.Fatalf("verify error: %s", err)
+	}
+
+	expected := &StackDepths{Main: 4, Functions: map[int]int{1: 2}}
+	if !reflect.DeepEqual(depths, expected) {
+		t.Errorf("wrong stack depths. want=%+v, got=%+v", expected, depths)
+	}
+}
+
+func TestVerifyCompiledPrograms(t *testing.T) {
+	inputs := []string{
+		`let f = func(a, b = 2, ...rest) { if (a > b) { return a; } b + len(rest) }; f(1); f(3, 2, 1)`,
+		`let x = try { throw {"a": 1} } catch (e) { e["a"] } finally { puts("done") }; x`,
+		`let g = func(x) { match (x) { [a, ...r] => a, {"k": v} => v, 1 => 2, _ => 0 } }; g([1])`,
+		`let adder = func(a) { func(b) { a + b } }; adder(1)(2)`,
+		`let f = func(x) { if (x) { return 1 } else { return 2 }; try { 1 } catch (e) { 2 } }; f(true)`,
+		`if (true) { let z = 1; }; let x = if (true) { let z = 1; }; let c = len([]) > 0; if (c) { let y = 2; };`,
+	}
+
+	for _, input := range inputs {
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		if _, err := Verify(comp.Bytecode()); err != nil {
+			t.Errorf("compiled program %q rejected: %s", input, err)
+		}
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	tests := []struct {
+		listing       string
+		expectedError string
+	}{
+		{
+			".const\nint 1\n.main\nOpConstant 1\nOpPop",
+			"invalid bytecode in main at 0000: constant 1 out of range, the pool has 1",
+		},
+		{
+			".main\nOpGetLocal 0",
+			"invalid bytecode in main at 0000: local 0 out of range, main has 0",
+		},
+		{
+			".main\nOpGetBuiltin 200",
+			"invalid bytecode in main at 0000: builtin 200 out of range, there are 7",
+		},
+		{
+			".const\nint 1\n.main\nOpClosure 0 0",
+			"invalid bytecode in main at 0000: constant 0 is not a function",
+		},
+		{
+			".main\nOpJump 1\nOpNull",
+			"invalid bytecode in main at 0000: jump to 0001, which is not the start of an instruction",
+		},
+		{
+			".main\nOpWide OpJump 1\nOpNull",
+			"invalid bytecode in main at 0000: jump to 0001, which is not the start of an instruction",
+		},
+		{
+			".main\nOpGetBuiltin 300",
+			"invalid bytecode in main at 0000: builtin 300 out of range, there are 7",
+		},
+		{
+			".main\nOpTry 0",
+			"invalid bytecode in main at 0000: try slot 0 out of range, main has 0",
+		},
+		{
+			".main\nOpNull\nOpHash 1",
+			"invalid bytecode in main at 0001: OpHash needs an even number of keys and values, got 1",
+		},
+		{
+			".main\nOpReturn",
+			"invalid bytecode in main at 0000: OpReturn outside of a function",
+		},
+		{
+			".main\nOpPop",
+			"invalid bytecode in main at 0000: OpPop takes 1 values off the stack, which holds 0",
+		},
+		{
+			".main\nOpTrue\nOpJumpNotTruthy else\nOpNull\nelse:\nOpNull\nOpPop",
+			"invalid bytecode in main at 0005: reached with 1 values on the stack from 0004, with 0 on another path",
+		},
+		{
+			".const\nfunc \"f\" params=0 locals=0 defaults=0 variadic=false\n.main\nOpClosure 0 0\n.func 0\nOpNull\nOpPop",
+			`invalid bytecode in function "f" (constant 0) at 0001: execution runs past the end of the function`,
+		},
+		{
+			".const\nfunc \"f\" params=0 locals=0 defaults=0 variadic=false\n.main\nOpClosure 0 1\n.func 0\nOpReturn",
+			`invalid bytecode in main at 0000: OpClosure takes 1 values off the stack, which holds 0`,
+		},
+		{
+			".const\nfunc \"f\" params=0 locals=0 defaults=0 variadic=false\n.main\nOpNull\nOpClosure 0 1\n.func 0\nOpGetFree 1\nOpReturnValue",
+			`invalid bytecode in function "f" (constant 0) at 0000: free variable 1 out of range, function "f" (constant 0) has 1`,
+		},
+		{
+			".main tryslots=1\nOpTry 0\nOpNull\n.handler 3 4 3 1",
+			"invalid bytecode in main: handler 0 uses try slot 1, main has 1",
+		},
+		{
+			".main\nOpWide OpGetGlobal 4000000\nOpPop",
+			"invalid bytecode in main at 0000: global 4000000 out of range, there can be 1048576",
+		},
+		{
+			".const\nfunc \"f\" params=5000 locals=0 defaults=5000 variadic=true\n.main\nOpClosure 0 0\nOpPop\n.func 0\nOpNull\nOpReturnValue",
+			`invalid bytecode in function "f" (constant 0): its parameters need 5001 locals, it has 0`,
+		},
+		{
+			".const\nfunc \"f\" params=1 locals=1 defaults=2 variadic=false\n.main\nOpClosure 0 0\nOpPop\n.func 0\nOpNull\nOpReturnValue",
+			`invalid bytecode in function "f" (constant 0): 2 defaults for 1 parameters`,
+		},
+		{
+			".main tryslots=1000000000\nOpNull\nOpPop",
+			"invalid bytecode in main: 1000000000 try slots for 0 OpTry instructions",
+		},
+		{
+			".main tryslots=1\nOpTry 0\n.handler 0 2 0 0",
+			"invalid bytecode in main: handler 0 covers 0000 to 0002, which is not a range of instructions",
+		},
+	}
+
+	for _, tt := range tests {
+		bytecode, err := asm.Assemble(tt.listing)
+		if err != nil {
+			t.Fatalf("assembler error for %q: %s", tt.listing, err)
+		}
+
+		_, err = Verify(bytecode)
+		if err == nil {
+			t.Errorf("expected a verify error for %q", tt.listing)
+			continue
+		}
+
+		if err.Error() != tt.expectedError {
+			t.Errorf("wrong error for %q.\nwant=%q\ngot=%q", tt.listing, tt.expectedError, err.Error())
+		}
+	}
+}
+
+func TestRunRejectsMalformedBytecode(t *testing.T) {
+	bytecode := &compiler.Bytecode{
+		Instructions: []byte{255},
+		Constants:    []object.Object{},
+	}
+
+	err := New(bytecode).Run()
+	if err == nil {
+		t.Fatalf("expected an error running malformed bytecode")
+	}
+
+	expected := "invalid bytecode in main at 0000: opcode 255 undefined"
+	if err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%q", expected, err.Error())
+	}
+}
@@ -10,8 +10,12 @@ import (
 	"fmt"
 )
 
+// GlobalsSize is the number of globals a VM starts with room for, the store grows when a program has more,
+// up to MaxGlobals.
 const GlobalsSize = 65536
+const MaxGlobals = 1 << 20
 const StackSize = 2048
+const MaxFrames = 1024
 
 var True = &object.Boolean{Value: true}
 var False = &object.Boolean{Value: false}
@@ -19,21 +23,42 @@ var False = &object.Boolean{Value: false}
 var Null = &object.Null{}
 
 type VM struct {
-	constants    []object.Object
-	instructions code.Instructions
-	stack        []object.Object
-	sp           int // Always points to the next value. Top of stack is stack[sp-1]
-	globals      []object.Object
+	constants []object.Object
+	stack     []object.Object
+	sp        int // Always points to the next value. Top of stack is stack[sp-1]
+	globals   []object.Object
+
+	frames      []*Frame
+	framesIndex int
+
+	bytecode *compiler.Bytecode
+	verified bool // set once Run has checked bytecode with Verify
 }
 
-// New() creates a new VM with the given bytecode.
+// New() creates a new VM with the given bytecode. The main program runs as a closure of its own in the first frame.
 func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Handlers:     bytecode.Handlers,
+		NumTrySlots:  bytecode.NumTrySlots,
+		SourceMap:    bytecode.SourceMap,
+	}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
 	return &VM{
-		instructions: bytecode.Instructions,
-		constants:    bytecode.Constants,
-		stack:        make([]object.Object, StackSize), // The stack is preallocated to have a StackSize number of elements, which should be enough for us
-		sp:           0,                                // sp will always point to the next free slot in the stack. If there’s one element on the stack, located at index 0, the value of sp would be 1 and to access the element we’d use stack[sp-1].
-		globals:      make([]object.Object, GlobalsSize),
+		constants: bytecode.Constants,
+		stack:     make([]object.Object, StackSize), // The stack is preallocated to have a StackSize number of elements, which should be enough for us
+		sp:        0,                                // sp will always point to the next free slot in the stack. If there’s one element on the stack, located at index 0, the value of sp would be 1 and to access the element we’d use stack[sp-1].
+		globals:   make([]object.Object, GlobalsSize),
+
+		frames:      frames,
+		framesIndex: 1,
+
+		bytecode: bytecode,
 	}
 }
 
@@ -43,6 +68,48 @@ func NewWithGlobalStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
 	return vm
 }
 
+// Globals() returns the global store, which Run may have grown past the one the VM was created with.
+func (vm *VM) Globals() []object.Object {
+	return vm.globals
+}
+
+// growGlobals() makes room in the global store for the global at index.
+func (vm *VM) growGlobals(index int) {
+	if index < len(vm.globals) {
+		return
+	}
+
+	size := 2 * len(vm.globals)
+	if size <= index {
+		size = index + 1
+	}
+
+	vm.globals = append(vm.globals, make([]object.Object, size-len(vm.globals))...)
+}
+
+// currentFrame() returns the frame of the function being executed.
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+// pushFrame() makes f the current frame.
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= MaxFrames {
+		return fmt.Errorf("stack overflow: too many nested calls")
+	}
+
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+
+	return nil
+}
+
+// popFrame() returns to the caller's frame.
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
 // StackTop() pushes an object onto the stack.
 func (vm *VM) StackTop() object.Object {
 	if vm.sp == 0 {
@@ -52,17 +119,114 @@ func (vm *VM) StackTop() object.Object {
 	return vm.stack[vm.sp-1]
 }
 
-// Run() executes the VM's bytecode instructions. It iterates over the instructions, decodes the current instruction, and executes the corresponding operation.
+// Run() executes the VM's bytecode instructions. An error raised by an instruction resumes execution at the innermost
+// exception handler covering it, unwinding the frames of the calls in between. Without such a handler Run returns the
+// error, as an *object.Error that knows its kind and the source position it was raised at.
+// The bytecode is checked with Verify first, malformed bytecode is rejected before anything runs.
 func (vm *VM) Run() error {
-	for ip := 0; ip < len(vm.instructions); ip++ {
-		op := code.Opcode(vm.instructions[ip])
+	if !vm.verified {
+		if _, err := Verify(vm.bytecode); err != nil {
+			return err
+		}
+		vm.verified = true
+	}
+
+	for {
+		err := vm.run()
+		if err == nil {
+			return nil
+		}
+
+		runtimeErr := vm.newRuntimeError(err)
+		if !vm.unwind(runtimeErr) {
+			return runtimeErr
+		}
+	}
+}
+
+// newRuntimeError() turns an error raised by the current instruction into an *object.Error located at that instruction,
+// with a stack trace built from the frames of the calls being executed. An error rethrown as it was keeps its own.
+func (vm *VM) newRuntimeError(err error) *object.Error {
+	runtimeErr, ok := err.(*object.Error)
+	if !ok {
+		runtimeErr = &object.Error{Message: err.Error(), Kind: object.RUNTIME_ERROR}
+	}
+
+	if runtimeErr.Line == 0 {
+		frame := vm.currentFrame()
+		runtimeErr.Line, runtimeErr.Column = frame.cl.Fn.SourceMap.Lookup(frame.ip)
+	}
+
+	if runtimeErr.Trace == nil {
+		runtimeErr.Trace = vm.stackTrace()
+	}
+
+	return runtimeErr
+}
+
+// stackTrace() returns the function and source position of every frame, innermost first.
+func (vm *VM) stackTrace() []object.TraceFrame {
+	trace := make([]object.TraceFrame, 0, vm.framesIndex)
+
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+
+		name := frame.cl.Fn.Name
+		if i == 0 {
+			name = object.MAIN_FUNCTION
+		} else if name == "" {
+			name = object.ANONYMOUS_FUNCTION
+		}
+
+		line, column := frame.cl.Fn.SourceMap.Lookup(frame.ip)
+		trace = append(trace, object.TraceFrame{Function: name, Line: line, Column: column})
+	}
+
+	return trace
+}
+
+// unwind() looks for a handler for err, from the current frame outwards. When it finds one the frames above it are
+// dropped, the stack is reset to the depth saved by the handler's OpTry and the error is pushed for the handler to bind.
+func (vm *VM) unwind(err *object.Error) bool {
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+
+		handler, ok := code.FindHandler(frame.cl.Fn.Handlers, frame.ip)
+		if !ok {
+			continue
+		}
+
+		vm.framesIndex = i + 1
+		vm.sp = frame.trySP[handler.Slot]
+		frame.ip = handler.Target - 1
+
+		hash := err.ToHash()
+		frame.caught[handler.Slot] = caughtError{hash: hash, err: err}
+
+		return vm.push(hash) == nil
+	}
+
+	return false
+}
+
+// run() iterates over the instructions of the current frame, decodes the current instruction, and executes the corresponding operation.
+func (vm *VM) run() error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+	var wide bool // set by OpWide for the instruction after it
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
 
 		switch op {
 
 		case code.OpConstant:
-			// code.ReadUint16(): I am fast :D
-			constIndex := code.ReadUint16(vm.instructions[ip+1:])
-			ip += 2
+			constIndex := vm.currentFrame().readOperand(2, wide)
 
 			err := vm.push(vm.constants[constIndex])
 
@@ -107,16 +271,15 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpJump:
-			pos := int(code.ReadUint16(vm.instructions[ip+1:]))
-			ip = pos - 1
+			pos := vm.currentFrame().readOperand(2, wide)
+			vm.currentFrame().ip = pos - 1
 
 		case code.OpJumpNotTruthy:
-			pos := int(code.ReadUint16(vm.instructions[ip+1:]))
-			ip += 2
+			pos := vm.currentFrame().readOperand(2, wide)
 
 			condition := vm.pop()
 			if !isTruthy(condition) {
-				ip = pos - 1
+				vm.currentFrame().ip = pos - 1
 			}
 
 		case code.OpNull:
@@ -126,24 +289,242 @@ func (vm *VM) Run() error {
 			}
 
 		case code.OpGetGlobal:
-			globalIndex := code.ReadUint16(vm.instructions[ip+1:])
-			ip += 2
+			globalIndex := vm.currentFrame().readOperand(2, wide)
+			vm.growGlobals(globalIndex)
+
+			global := vm.globals[globalIndex]
+			if global == nil {
+				return fmt.Errorf("global %d is read before it is set", globalIndex)
+			}
 
-			err := vm.push(vm.globals[globalIndex])
+			err := vm.push(global)
 			if err != nil {
 				return err
 			}
 
 		case code.OpSetGlobal:
-			globalIndex := code.ReadUint16(vm.instructions[ip+1:])
-			ip += 2
+			globalIndex := vm.currentFrame().readOperand(2, wide)
+			vm.growGlobals(globalIndex)
 
 			vm.globals[globalIndex] = vm.pop()
 
+		case code.OpArray:
+			numElements := vm.currentFrame().readOperand(2, wide)
+
+			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+
+			err := vm.push(array)
+			if err != nil {
+				return err
+			}
+
+		case code.OpHash:
+			numElements := vm.currentFrame().readOperand(2, wide)
+
+			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp = vm.sp - numElements
+
+			err = vm.push(hash)
+			if err != nil {
+				return err
+			}
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+
+			err := vm.executeIndexExpression(left, index)
+			if err != nil {
+				return err
+			}
+
+		case code.OpMatchEqual:
+			right := vm.pop()
+			left := vm.pop()
+
+			err := vm.push(nativeBoolToBooleanObject(literalsEqual(left, right)))
+			if err != nil {
+				return err
+			}
+
+		case code.OpMatchArray, code.OpMatchArrayRest:
+			length := vm.currentFrame().readOperand(2, wide)
+
+			array, ok := vm.pop().(*object.Array)
+			matched := ok && len(array.Elements) == length
+			if ok && op == code.OpMatchArrayRest {
+				matched = len(array.Elements) >= length
+			}
+
+			err := vm.push(nativeBoolToBooleanObject(matched))
+			if err != nil {
+				return err
+			}
+
+		case code.OpMatchHash:
+			_, ok := vm.pop().(*object.Hash)
+
+			err := vm.push(nativeBoolToBooleanObject(ok))
+			if err != nil {
+				return err
+			}
+
+		case code.OpHasKey:
+			key := vm.pop()
+			subject := vm.pop()
+
+			hash, ok := subject.(*object.Hash)
+			if !ok {
+				return fmt.Errorf("cannot look up keys in %s", subject.Type())
+			}
+
+			hashKey, ok := key.(object.Hashable)
+			if !ok {
+				return fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+
+			_, ok = hash.Pairs[hashKey.HashKey()]
+
+			err := vm.push(nativeBoolToBooleanObject(ok))
+			if err != nil {
+				return err
+			}
+
+		case code.OpArraySlice:
+			start := vm.currentFrame().readOperand(2, wide)
+
+			err := vm.executeArraySlice(vm.pop(), start)
+			if err != nil {
+				return err
+			}
+
+		case code.OpNoMatch:
+			subject := vm.pop()
+			return fmt.Errorf("no match arm matched value: %s", subject.Inspect())
+
+		case code.OpCall:
+			numArgs := vm.currentFrame().readOperand(1, wide)
+
+			err := vm.executeCall(numArgs)
+			if err != nil {
+				return err
+			}
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+
+			// A top-level return ends the program, its value becomes the last popped element
+			if vm.framesIndex == 1 {
+				return nil
+			}
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			err := vm.push(returnValue)
+			if err != nil {
+				return err
+			}
+
+		case code.OpTry:
+			slot := vm.currentFrame().readOperand(2, wide)
+
+			vm.currentFrame().trySP[slot] = vm.sp
+
+		case code.OpThrow:
+			value := vm.pop()
+			if err := vm.currentFrame().rethrown(value); err != nil {
+				return err
+			}
+
+			return object.NewThrownError(value)
+
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			err := vm.push(Null)
+			if err != nil {
+				return err
+			}
+
+		case code.OpGetLocal:
+			localIndex := vm.currentFrame().readOperand(1, wide)
+
+			frame := vm.currentFrame()
+
+			err := vm.push(vm.stack[frame.basePointer+localIndex])
+			if err != nil {
+				return err
+			}
+
+		case code.OpSetLocal:
+			localIndex := vm.currentFrame().readOperand(1, wide)
+
+			frame := vm.currentFrame()
+
+			vm.stack[frame.basePointer+localIndex] = vm.pop()
+
+		case code.OpGetBuiltin:
+			builtinIndex := vm.currentFrame().readOperand(1, wide)
+
+			definition := object.Builtins[builtinIndex]
+
+			err := vm.push(definition.Builtin)
+			if err != nil {
+				return err
+			}
+
+		case code.OpClosure:
+			constIndex := vm.currentFrame().readOperand(2, wide)
+			numFree := vm.currentFrame().readOperand(1, wide)
+
+			err := vm.pushClosure(constIndex, numFree)
+			if err != nil {
+				return err
+			}
+
+		case code.OpGetFree:
+			freeIndex := vm.currentFrame().readOperand(1, wide)
+
+			currentClosure := vm.currentFrame().cl
+
+			err := vm.push(currentClosure.Free[freeIndex])
+			if err != nil {
+				return err
+			}
+
+		case code.OpCurrentClosure:
+			currentClosure := vm.currentFrame().cl
+
+			err := vm.push(currentClosure)
+			if err != nil {
+				return err
+			}
+
+		case code.OpJumpIfArgPassed:
+			paramIndex := vm.currentFrame().readOperand(1, wide)
+			pos := vm.currentFrame().readOperand(2, wide)
+
+			if paramIndex < vm.currentFrame().numArgs {
+				vm.currentFrame().ip = pos - 1
+			}
+
 		case code.OpPop:
 			vm.pop()
 
+		case code.OpWide:
+			// The operands of the next instruction are read twice as wide
+			wide = true
+			continue
+
 		}
+
+		wide = false
 	}
 	return nil
 }
@@ -171,6 +552,12 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 		return vm.executeIntegerComparison(op, left, right)
 	}
 
+	// Strings are equal by value: the same string may be one constant or two, or made at run time
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ && op != code.OpGreaterThan {
+		equal := left.(*object.String).Value == right.(*object.String).Value
+		return vm.push(nativeBoolToBooleanObject(equal == (op == code.OpEqual)))
+	}
+
 	switch op {
 	case code.OpEqual:
 		return vm.push(nativeBoolToBooleanObject(right == left))
@@ -192,8 +579,12 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	leftType := left.Type()
 	rightType := right.Type()
 
-	if leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ {
+	switch {
+	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
 		return vm.executeBinaryIntegerOperation(op, left, right)
+
+	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
+		return vm.executeBinaryStringOperation(op, left, right)
 	}
 
 	return fmt.Errorf("unsupported types for Binary operation: %s %s", leftType, rightType)
@@ -220,6 +611,9 @@ func (vm *VM) executeBinaryIntegerOperation(
 		result = leftValue * rightValue
 
 	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
 		result = leftValue / rightValue
 
 	default:
@@ -317,3 +711,239 @@ func (vm *VM) executeMinusOperator() error {
 
 	return vm.push(&object.Integer{Value: -value})
 }
+
+// executeBinaryStringOperation() executes a binary operation on two string objects. Only concatenation is supported.
+func (vm *VM) executeBinaryStringOperation(
+	op code.Opcode,
+	left, right object.Object,
+) error {
+	if op != code.OpAdd {
+		return fmt.Errorf("unknown string operator: %d", op)
+	}
+
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	return vm.push(&object.String{Value: leftValue + rightValue})
+}
+
+// buildArray() creates an array from the stack elements between startIndex and endIndex.
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+
+	for i := startIndex; i < endIndex; i++ {
+		elements[i-startIndex] = vm.stack[i]
+	}
+
+	return &object.Array{Elements: elements}
+}
+
+// buildHash() creates a hash from the alternating keys and values on the stack between startIndex and endIndex.
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	hashedPairs := make(map[object.HashKey]object.HashPair)
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		pair := object.HashPair{Key: key, Value: value}
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+
+		hashedPairs[hashKey.HashKey()] = pair
+	}
+
+	return &object.Hash{Pairs: hashedPairs}, nil
+}
+
+// executeIndexExpression() indexes into an array or a hash and pushes the result.
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeArrayIndex(left, index)
+
+	case left.Type() == object.HASH_OBJ:
+		return vm.executeHashIndex(left, index)
+
+	default:
+		return fmt.Errorf("index operator not supported: %s", left.Type())
+	}
+}
+
+// executeArrayIndex() pushes the array element at index, or Null when the index is out of bounds.
+func (vm *VM) executeArrayIndex(array, index object.Object) error {
+	arrayObject := array.(*object.Array)
+	i := index.(*object.Integer).Value
+	max := int64(len(arrayObject.Elements) - 1)
+
+	if i < 0 || i > max {
+		return vm.push(Null)
+	}
+
+	return vm.push(arrayObject.Elements[i])
+}
+
+// executeHashIndex() pushes the value stored under index, or Null when the key is missing.
+func (vm *VM) executeHashIndex(hash, index object.Object) error {
+	hashObject := hash.(*object.Hash)
+
+	key, ok := index.(object.Hashable)
+	if !ok {
+		return fmt.Errorf("unusable as hash key: %s", index.Type())
+	}
+
+	pair, ok := hashObject.Pairs[key.HashKey()]
+	if !ok {
+		return vm.push(Null)
+	}
+
+	return vm.push(pair.Value)
+}
+
+// executeArraySlice() pushes a new array holding the elements of array from start on.
+func (vm *VM) executeArraySlice(array object.Object, start int) error {
+	arrayObject, ok := array.(*object.Array)
+	if !ok {
+		return fmt.Errorf("cannot slice %s", array.Type())
+	}
+
+	if start > len(arrayObject.Elements) {
+		start = len(arrayObject.Elements)
+	}
+
+	elements := make([]object.Object, len(arrayObject.Elements)-start)
+	copy(elements, arrayObject.Elements[start:])
+
+	return vm.push(&object.Array{Elements: elements})
+}
+
+// literalsEqual() compares two scalar objects by value. Objects of different types are never equal.
+func literalsEqual(left, right object.Object) bool {
+	switch left := left.(type) {
+	case *object.Integer:
+		right, ok := right.(*object.Integer)
+		return ok && left.Value == right.Value
+
+	case *object.String:
+		right, ok := right.(*object.String)
+		return ok && left.Value == right.Value
+
+	case *object.Boolean:
+		right, ok := right.(*object.Boolean)
+		return ok && left.Value == right.Value
+
+	default:
+		return false
+	}
+}
+
+// executeCall() calls the closure or builtin sitting below the numArgs arguments on top of the stack.
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+
+	default:
+		return fmt.Errorf("not a function: %s", callee.Type())
+	}
+}
+
+// callClosure() checks the argument count against the function's parameters and pushes a new frame.
+// The arguments become the first locals of the frame. For a variadic function, the arguments past the
+// named parameters are collected into an array stored in the local right after them.
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	fn := cl.Fn
+	required := fn.NumParameters - fn.NumDefaults
+
+	if numArgs < required || (!fn.Variadic && numArgs > fn.NumParameters) {
+		return fmt.Errorf("wrong number of arguments. got=%d, want=%s",
+			numArgs, arityString(required, fn.NumParameters, fn.Variadic))
+	}
+
+	basePointer := vm.sp - numArgs
+	passed := numArgs
+
+	if basePointer+fn.NumLocals >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+
+	if fn.Variadic {
+		rest := []object.Object{}
+		if numArgs > fn.NumParameters {
+			rest = append(rest, vm.stack[basePointer+fn.NumParameters:vm.sp]...)
+			passed = fn.NumParameters
+		}
+
+		vm.stack[basePointer+fn.NumParameters] = &object.Array{Elements: rest}
+	}
+
+	frame := NewFrame(cl, basePointer, passed)
+
+	err := vm.pushFrame(frame)
+	if err != nil {
+		return err
+	}
+
+	vm.sp = frame.basePointer + fn.NumLocals
+
+	return nil
+}
+
+// callBuiltin() calls a builtin function with the arguments on top of the stack and pushes its result.
+// An error returned by the builtin aborts execution, like it does in the evaluator.
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	result := builtin.Fn(args...)
+	vm.sp = vm.sp - numArgs - 1
+
+	if err, ok := result.(*object.Error); ok {
+		return fmt.Errorf("%s", err.Message)
+	}
+
+	if result != nil {
+		return vm.push(result)
+	}
+
+	return vm.push(Null)
+}
+
+// pushClosure() wraps the compiled function at constIndex in a closure capturing the numFree values on top of the stack.
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp = vm.sp - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
+
+// arityString() describes how many arguments a function accepts, e.g. "2", "1 to 3" or "at least 1".
+func arityString(required, total int, variadic bool) string {
+	switch {
+	case variadic:
+		return fmt.Sprintf("at least %d", required)
+
+	case required == total:
+		return fmt.Sprintf("%d", required)
+
+	default:
+		return fmt.Sprintf("%d to %d", required, total)
+	}
+}
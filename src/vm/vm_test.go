@@ -1,12 +1,15 @@
 package vm
 
 import (
+	"chui/asm"
 	"chui/ast"
 	"chui/compiler"
+	"chui/evaluator"
 	"chui/lexer"
 	"chui/object"
 	"chui/parser"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -79,6 +82,11 @@ func TestConditionals(t *testing.T) {
 		{"if (false) { 10 }", Null},
 		{"!(if (false) { 5; })", true},
 		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+		{"if (true) { let z = 1; }", Null},
+		{"let x = if (true) { let z = 1; }; x", Null},
+		{"let c = len([]) > 0; if (c) { 1 } else { let y = 2; }", Null},
+		{"let c = len([]) == 0; if (c) { let y = 2; }", Null},
+		{"if (true) { }", Null},
 	}
 	runVmTests(t, tests)
 }
@@ -93,6 +101,759 @@ func TestGlobalLetStatements(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestStringExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`"chui"`, "chui"},
+		{`"ch" + "ui"`, "chui"},
+		{`"ch" + "u" + "i"`, "chui"},
+	}
+
+	runVmTests(t, tests)
+}
+
+// TestStringComparisons() checks that folding and interning string constants doesn't change what equality gives.
+func TestStringComparisons(t *testing.T) {
+	tests := []vmTestCase{
+		{`"q" == "q"`, true},
+		{`"q" != "q"`, false},
+		{`"q" == "r"`, false},
+		{`"ab" == "a" + "b"`, true},
+		{`let a = "a"; "ab" == a + "b"`, true},
+		{`let s = "ab"; let f = func(x) { x + "b" }; s == f("a")`, true},
+		{`"ab" != "a" + "b"`, false},
+	}
+
+	runVmTestsWith(t, tests, everyOptimization())
+}
+
+func TestArrayLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"[]", []int{}},
+		{"[1, 2, 3]", []int{1, 2, 3}},
+		{"[1 + 2, 3 * 4, 5 + 6]", []int{3, 12, 11}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestHashLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"{}", map[object.HashKey]int64{}},
+		{
+			"{1: 2, 2: 3}",
+			map[object.HashKey]int64{
+				(&object.Integer{Value: 1}).HashKey(): 2,
+				(&object.Integer{Value: 2}).HashKey(): 3,
+			},
+		},
+		{
+			"{1 + 1: 2 * 2, 3 + 3: 4 * 4}",
+			map[object.HashKey]int64{
+				(&object.Integer{Value: 2}).HashKey(): 4,
+				(&object.Integer{Value: 6}).HashKey(): 16,
+			},
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestIndexExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3][1]", 2},
+		{"[1, 2, 3][0 + 2]", 3},
+		{"[[1, 1, 1]][0][0]", 1},
+		{"[][0]", Null},
+		{"[1, 2, 3][99]", Null},
+		{"[1][-1]", Null},
+		{"{1: 1, 2: 2}[1]", 1},
+		{"{1: 1, 2: 2}[2]", 2},
+		{"{1: 1}[0]", Null},
+		{"{}[0]", Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`match (0) { 0 => 1, _ => 2 }`, 1},
+		{`match (5) { 0 => 1, _ => 2 }`, 2},
+		{`match (-3) { -3 => 1, _ => 2 }`, 1},
+		{`match ("chui") { "monkey" => 1, "chui" => 2 }`, 2},
+		{`match (true) { false => 1, true => 2 }`, 2},
+		{`match (7) { x => x * 2 }`, 14},
+		{`match ([1, 2, 3]) { [a, b] => 0, [a, b, c] => a + b + c }`, 6},
+		{`match ([1, 2, 3]) { [first, ...rest] => rest }`, []int{2, 3}},
+		{`match ([1]) { [first, ...rest] => rest }`, []int{}},
+		{`match ([]) { [first, ...rest] => 1, [] => 2 }`, 2},
+		{`match ([[1, 2], 3]) { [[a, b], c] => a * b * c }`, 6},
+		{`match ([1, 2]) { [1, x] => x, _ => 0 }`, 2},
+		{`match ([3, 2]) { [1, x] => x, _ => 0 }`, 0},
+		{`match ({"name": 5, "age": 3}) { {"name": n} => n }`, 5},
+		{`match ({"age": 3}) { {"name": n} => n, {"age": a} => a }`, 3},
+		{`match ({1: [4, 5]}) { {1: [x, y]} => x + y }`, 9},
+		{`match (5) { {"name": n} => n, [x] => x, _ => 0 }`, 0},
+		{`match (10) { x if x < 5 => 1, x if x > 5 => 2 }`, 2},
+		{`match (3) { x if x < 5 => 1, x if x > 5 => 2 }`, 1},
+		{`match ([1, 2]) { [a, b] if a > b => 1, [a, b] => 2 }`, 2},
+		{`let x = 1; match (5) { x => x }; x`, 1},
+		{`match (1) { 2 => 3, x if x == 2 => 4, _ => if (false) { 5 } }`, Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMatchExpressionWithoutMatchingArm(t *testing.T) {
+	program := parse(`match (5) { 1 => 1, [a] => a }`)
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+
+	expected := "no match arm matched value: 5"
+	if err.Error() != expected {
+		t.Fatalf("wrong VM error: want=%q, got=%q", expected, err)
+	}
+}
+
+func TestDestructuringLetStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let [a, b] = [1, 2]; a;", 1},
+		{"let [a, b] = [1, 2]; b;", 2},
+		{"let [a, b] = [1]; b;", Null},
+		{"let [a, b = 10] = [1]; b;", 10},
+		{"let [a, b = 10] = [1, 2]; b;", 2},
+		{"let [a, ...rest] = [1, 2, 3]; rest;", []int{2, 3}},
+		{"let [a, b, ...rest] = [1]; rest;", []int{}},
+		{"let [[a, b], c] = [[1, 2], 3]; a + b + c;", 6},
+		{"let [_, b] = [1, 2]; b;", 2},
+		{`let {name, age} = {"name": 5, "age": 3}; name * age;`, 15},
+		{`let {name, age} = {"name": 5}; age;`, Null},
+		{`let {name, age = 7} = {"name": 5}; age;`, 7},
+		{`let {"tags": [first, second = first * 2]} = {"tags": [4]}; second;`, 8},
+		{`let {"tags": [t] = [9]} = {}; t;`, 9},
+		{`let x = 2; let [a = x * 3] = []; a;`, 6},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let fivePlusTen = func() { 5 + 10; }; fivePlusTen();", 15},
+		{"let one = func() { 1; }; let two = func() { 2; }; one() + two()", 3},
+		{"let early = func() { return 99; 100; }; early();", 99},
+		{"let noReturn = func() { }; noReturn();", Null},
+		{"let identity = func(a) { a; }; identity(4);", 4},
+		{"let sum = func(a, b) { let c = a + b; c; }; sum(1, 2) + sum(3, 4);", 10},
+		{
+			"let globalNum = 10; let minusOne = func() { let num = 1; globalNum - num; }; minusOne();",
+			9,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []vmTestCase{
+		{"let add = func(a, b = 10) { a + b }; add(1);", 11},
+		{"let add = func(a, b = 10) { a + b }; add(1, 2);", 3},
+		{"let f = func(a, b = a * 2) { b }; f(4);", 8},
+		{"let x = 7; let f = func(a = x) { a }; f();", 7},
+		{"let f = func(a, ...rest) { rest }; f(1, 2, 3);", []int{2, 3}},
+		{"let f = func(a, ...rest) { rest }; f(1);", []int{}},
+		{"let f = func(a = 1, ...rest) { a + len(rest) }; f();", 1},
+		{"let f = func(a = 1, ...rest) { a + len(rest) }; f(5, 6, 7);", 7},
+		{"let f = func(...xs) { len(xs) }; f(1, 2, 3, 4);", 4},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctionsWithWrongArguments(t *testing.T) {
+	tests := []vmTestCase{
+		{"func() { 1; }(1);", "wrong number of arguments. got=1, want=0"},
+		{"func(a) { a; }();", "wrong number of arguments. got=0, want=1"},
+		{"func(a, b) { a + b; }(1);", "wrong number of arguments. got=1, want=2"},
+		{"func(a, b = 2) { a + b; }(1, 2, 3);", "wrong number of arguments. got=3, want=1 to 2"},
+		{"func(a, ...rest) { a; }();", "wrong number of arguments. got=0, want=at least 1"},
+		{"let x = 1; x();", "not a function: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if err.Error() != tt.expected {
+			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len([1, 2, 3])`, 3},
+		{`puts("hello")`, Null},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, Null},
+		{`last([1, 2, 3])`, 3},
+		{`rest([1, 2, 3])`, []int{2, 3}},
+		{`push([], 1)`, []int{1}},
+	}
+
+	runVmTests(t, tests)
+
+	errorTests := []vmTestCase{
+		{`len(1)`, "argument to `len` not supported, got INTEGER"},
+		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+	}
+
+	for _, tt := range errorTests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		if err.Error() != tt.expected {
+			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			"let newClosure = func(a) { func() { a; }; }; let closure = newClosure(99); closure();",
+			99,
+		},
+		{
+			"let newAdder = func(a, b) { func(c) { a + b + c }; }; let adder = newAdder(1, 2); adder(8);",
+			11,
+		},
+		{
+			`let newAdderOuter = func(a, b) {
+				let c = a + b;
+				func(d) {
+					let e = d + c;
+					func(f) { e + f; };
+				};
+			};
+			let newAdderInner = newAdderOuter(1, 2);
+			let adder = newAdderInner(3);
+			adder(8);`,
+			14,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFibonacci(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`let fibonacci = func(x) {
+				if (x == 0) { return 0; }
+				if (x == 1) { return 1; }
+				fibonacci(x - 1) + fibonacci(x - 2);
+			};
+			fibonacci(15);`,
+			610,
+		},
+		{
+			`let wrapper = func() {
+				let countDown = func(x) {
+					if (x == 0) { return 0; }
+					countDown(x - 1);
+				};
+				countDown(1);
+			};
+			wrapper();`,
+			0,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"try { 1 } catch (e) { 2 }", 1},
+		{"try { throw 1; 2 } catch (e) { 3 }", 3},
+		{`try { throw "boom"; 1 } catch (e) { e["message"] }`, "boom"},
+		{`try { throw "boom" } catch (e) { e["kind"] }`, "Error"},
+		{`try { 1 + true } catch (e) { e["kind"] }`, "RuntimeError"},
+		{`try { 1 / 0 } catch (e) { e["message"] }`, "division by zero"},
+		{`let zero = 0; try { 5 / zero } catch (e) { e["kind"] }`, "RuntimeError"},
+		{`try { throw {"message": "bad", "kind": "ValueError"} } catch (e) { e["kind"] }`, "ValueError"},
+		{`try { throw {"message": "bad", "code": 7} } catch (e) { e["code"] }`, 7},
+		{`try { throw "x" } catch { 5 }`, 5},
+		{`try { } catch (e) { 1 }`, Null},
+		{`let f = func() { throw "inner" }; try { f() } catch (e) { e["message"] }`, "inner"},
+		{`try { try { throw "a" } catch (e) { throw e["message"] + "b" } } catch (e) { e["message"] }`, "ab"},
+		{`let g = func(n) { if (n == 0) { throw "deep" } g(n - 1) }; try { g(5) } catch (e) { e["message"] }`, "deep"},
+		{`let f = func() { throw "x" }; 1 + try { 2 + f() } catch (e) { 10 }`, 11},
+		{`let e = 1; try { throw "x" } catch (e) { 2 }; e`, 1},
+		{"try { 1 } finally { 2 }", 1},
+		{`try { try { throw "x" } finally { 1 } } catch (e) { e["message"] }`, "x"},
+		{`try { try { throw "x" } finally { throw "y" } } catch (e) { e["message"] }`, "y"},
+		{`try { try { throw "x" } catch (e) { throw "y" } finally { 1 } } catch (e) { e["message"] }`, "y"},
+		{"let f = func() { try { return 1 } finally { 5 } }; f()", 1},
+		{"let f = func() { try { return 1 } finally { return 2 } }; f()", 2},
+		{`let f = func() { try { throw "x" } catch (e) { return 3 } finally { 4 } }; f()`, 3},
+		{`let f = func() { try { try { return 1 } finally { throw "y" } } catch (e) { return e["message"] } }; f()`, "y"},
+		{"try {\n  1 + true\n} catch (e) { e[\"line\"] * 100 + e[\"column\"] }", 205},
+		{"try {\n  throw \"x\"\n} catch (e) { e[\"line\"] * 100 + e[\"column\"] }", 203},
+		{"try { try {\n throw \"x\" } catch (e) { throw e } } catch (e) { e[\"line\"] }", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestUncaughtErrors(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedError  string
+		expectedKind   string
+		expectedLine   int
+		expectedColumn int
+	}{
+		{`throw "oops"`, "oops", object.THROWN_ERROR, 1, 1},
+		{"let a = 1;\nlet b = a + true;", "unsupported types for Binary operation: INTEGER BOOLEAN", object.RUNTIME_ERROR, 2, 11},
+		{"let f = func() {\n  [1][\"a\"]\n};\nf()", "index operator not supported: ARRAY", object.RUNTIME_ERROR, 2, 6},
+		{`try { throw "oops" } finally { 1 }`, "oops", object.THROWN_ERROR, 1, 7},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		runtimeErr, ok := err.(*object.Error)
+		if !ok {
+			t.Fatalf("error is not *object.Error. got=%T (%+v)", err, err)
+		}
+
+		if runtimeErr.Message != tt.expectedError || runtimeErr.Kind != tt.expectedKind {
+			t.Errorf("wrong VM error: want=%s %q, got=%s %q",
+				tt.expectedKind, tt.expectedError, runtimeErr.Kind, runtimeErr.Message)
+		}
+
+		if runtimeErr.Line != tt.expectedLine || runtimeErr.Column != tt.expectedColumn {
+			t.Errorf("wrong error position for %q. want=%d:%d, got=%d:%d",
+				tt.input, tt.expectedLine, tt.expectedColumn, runtimeErr.Line, runtimeErr.Column)
+		}
+	}
+}
+
+func TestStackTraces(t *testing.T) {
+	tests := []struct {
+		input             string
+		expectedTraceback string
+	}{
+		{
+			"let f = func(x) {\n  throw \"boom\"\n};\nlet g = func() { f(1) };\ng();",
+			`Traceback (most recent call last):
+  File "test", line 5, column 2, in <main>
+  File "test", line 4, column 19, in g
+  File "test", line 2, column 3, in f
+Error: boom`,
+		},
+		{
+			"let f = func(a, b) { a };\nlet g = func() {\n  f(1)\n};\ng()",
+			`Traceback (most recent call last):
+  File "test", line 5, column 2, in <main>
+  File "test", line 3, column 4, in g
+RuntimeError: wrong number of arguments. got=1, want=2`,
+		},
+		{
+			"func() { throw \"x\" }()",
+			`Traceback (most recent call last):
+  File "test", line 1, column 21, in <main>
+  File "test", line 1, column 10, in <anonymous>
+Error: x`,
+		},
+		{
+			"let f = func(a = len(1)) { a };\nf()",
+			`Traceback (most recent call last):
+  File "test", line 2, column 2, in <main>
+  File "test", line 1, column 21, in f
+RuntimeError: argument to ` + "`len`" + ` not supported, got INTEGER`,
+		},
+		{
+			// The finally handler rethrows the error as it was raised
+			"let f = func() {\n  try {\n    len(1)\n  } finally { 1 }\n};\nlet g = func() { f() };\ng()",
+			`Traceback (most recent call last):
+  File "test", line 7, column 2, in <main>
+  File "test", line 6, column 19, in g
+  File "test", line 3, column 8, in f
+RuntimeError: argument to ` + "`len`" + ` not supported, got INTEGER`,
+		},
+		{
+			"let f = func() { try { throw \"a\" } catch (e) { throw \"b\" } finally { 1 } };\nf()",
+			`Traceback (most recent call last):
+  File "test", line 2, column 2, in <main>
+  File "test", line 1, column 48, in f
+Error: b`,
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error but resulted in none.")
+		}
+
+		runtimeErr, ok := err.(*object.Error)
+		if !ok {
+			t.Fatalf("error is not *object.Error. got=%T (%+v)", err, err)
+		}
+
+		if traceback := runtimeErr.Traceback("test"); traceback != tt.expectedTraceback {
+			t.Errorf("wrong traceback.\nwant=\n%s\ngot=\n%s", tt.expectedTraceback, traceback)
+		}
+	}
+}
+
+// TestWideOperands() runs programs whose constant indexes, global indexes, locals, argument counts, free variables
+// and jump targets need OpWide.
+func TestWideOperands(t *testing.T) {
+	// More than 64KB of code to jump over
+	filler := strings.Repeat("true; ", 33000)
+
+	// Identifiers can't hold digits, so the globals are named by their index written in letters
+	name := func(i int) string {
+		letters := ""
+		for ; i > 0; i /= 26 {
+			letters = string(rune('a'+i%26)) + letters
+		}
+		return "g" + letters
+	}
+	var globals strings.Builder
+	for i := 0; i < 70000; i++ {
+		fmt.Fprintf(&globals, "let %s = %d;\n", name(i), i)
+	}
+
+	// 300 parameters, the last with a default, 300 arguments and a closure over 300 locals
+	params, args, sum := []string{}, []string{}, []string{}
+	for i := 0; i < 300; i++ {
+		params = append(params, name(i+1))
+		args = append(args, fmt.Sprint(i))
+		sum = append(sum, name(i+1))
+	}
+	many := fmt.Sprintf("let f = func(%s = 299) { func() { %s } }; f(%s)() + f(%s)()",
+		strings.Join(params, ", "), strings.Join(sum, " + "), strings.Join(args, ", "), strings.Join(args[:299], ", "))
+
+	tests := []vmTestCase{
+		{"if (true) { " + filler + "1 } else { 2 }", 1},
+		{"if (false) { " + filler + "1 } else { 2 }", 2},
+		{globals.String() + name(69999) + " - " + name(1), 69998},
+		{"let f = func(x = if (true) { " + filler + "3 }) { try { " + filler + "throw x } catch (e) { 42 } }; f()", 42},
+		{many, 2 * 299 * 300 / 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRunDecodedBytecode(t *testing.T) {
+	input := "let f = func(x, ...rest) {\n  try { throw x } catch (e) { len(rest) + x }\n};\nf(10, 1, 2);\nf(\"a\")"
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	data, err := comp.Bytecode().Encode()
+	if err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	bytecode, err := compiler.Decode(data)
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+
+	// The source maps survive the round trip, so the error still points at the source
+	err = New(bytecode).Run()
+	runtimeErr, ok := err.(*object.Error)
+	if !ok {
+		t.Fatalf("error is not *object.Error. got=%T (%+v)", err, err)
+	}
+
+	expected := `Traceback (most recent call last):
+  File "test", line 5, column 2, in <main>
+  File "test", line 2, column 41, in f
+RuntimeError: unsupported types for Binary operation: INTEGER STRING`
+	if traceback := runtimeErr.Traceback("test"); traceback != expected {
+		t.Errorf("wrong traceback.\nwant=\n%s\ngot=\n%s", expected, traceback)
+	}
+}
+
+// TestAssembledBytecode() runs hand-written bytecode, without the parser and compiler.
+func TestAssembledBytecode(t *testing.T) {
+	tests := []struct {
+		listing  string
+		expected interface{}
+	}{
+		{
+			`
+.const
+    int 2
+    int 3
+.main
+    OpConstant 0
+    OpConstant 1
+    OpMul
+    OpPop`,
+			6,
+		},
+		{
+			// Sums the numbers from 1 to n by calling itself
+			`
+.const
+    int 1
+    func "sum" params=1 locals=1 defaults=0 variadic=false
+    int 4
+.main
+    OpClosure 1 0
+    OpConstant 2
+    OpCall 1
+    OpPop
+.func 1
+    OpConstant 0
+    OpGetLocal 0
+    OpGreaterThan
+    OpJumpNotTruthy recurse
+    OpGetLocal 0
+    OpReturnValue
+recurse:
+    OpGetLocal 0
+    OpCurrentClosure
+    OpGetLocal 0
+    OpConstant 0
+    OpSub
+    OpCall 1
+    OpAdd
+    OpReturnValue`,
+			10,
+		},
+		{
+			`
+.const
+    string "boom"
+.main tryslots=1
+    OpTry 0
+start:
+    OpConstant 0
+    OpThrow
+    OpJump end
+caught:
+    OpGetBuiltin 0
+    OpConstant 0
+    OpCall 1
+end:
+    OpPop
+    .handler start caught caught 0`,
+			4,
+		},
+	}
+
+	for _, tt := range tests {
+		bytecode, err := asm.Assemble(tt.listing)
+		if err != nil {
+			t.Fatalf("assembler error: %s", err)
+		}
+
+		vm := New(bytecode)
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+// TestAssembledRuntimeErrors() runs hand-written bytecode that passes Verify but fails when it runs.
+func TestAssembledRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		listing       string
+		expectedError string
+	}{
+		{".main\nOpGetGlobal 3\nOpGetGlobal 3\nOpAdd\nOpPop", "global 3 is read before it is set"},
+		{".const\nint 1\n.main\nOpConstant 0\nOpConstant 0\nOpHasKey\nOpPop", "cannot look up keys in INTEGER"},
+	}
+
+	for _, tt := range tests {
+		bytecode, err := asm.Assemble(tt.listing)
+		if err != nil {
+			t.Fatalf("assembler error: %s", err)
+		}
+
+		err = New(bytecode).Run()
+		if err == nil {
+			t.Fatalf("expected VM error for %q but resulted in none.", tt.listing)
+		}
+
+		runtimeErr, ok := err.(*object.Error)
+		if !ok {
+			t.Fatalf("error is not *object.Error. got=%T (%+v)", err, err)
+		}
+		if runtimeErr.Message != tt.expectedError || runtimeErr.Kind != object.RUNTIME_ERROR {
+			t.Errorf("wrong VM error for %q: want=%q, got=%s %q", tt.listing, tt.expectedError, runtimeErr.Kind, runtimeErr.Message)
+		}
+	}
+}
+
+func TestExpandedMacros(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`let unless = macro(cond, cons, alt) { quote(if (!(unquote(cond))) { unquote(cons) } else { unquote(alt) }) };
+			unless(10 > 5, 1, 2);`,
+			2,
+		},
+		{
+			`let twice = macro(x) { quote(unquote(x) + unquote(x)) };
+			let f = func(a) { twice(a * 2) };
+			f(3);`,
+			12,
+		},
+		{
+			`let tmp = 1;
+			let double = macro(e) { quote(if (true) { let tmp = unquote(e); tmp + tmp }) };
+			double(5) + tmp;`,
+			11,
+		},
+		{
+			`let tmp = 5;
+			let m = macro() { quote(func() { let tmp = 1; tmp }() + tmp) };
+			m();`,
+			6,
+		},
+		{
+			`let with_name = macro(value) {
+				let name = gensym("n");
+				quote(if (true) { let unquote(name) = unquote(value); unquote(name) * 2 })
+			};
+			with_name(21);`,
+			42,
+		},
+		{
+			`let join = macro() { quote(unquote("mon") + unquote("key")) };
+			join();`,
+			"monkey",
+		},
+		{
+			`let table = macro() { quote(unquote({"squares": [1, 4, 9]})) };
+			table()["squares"][2];`,
+			9,
+		},
+		{
+			`let inline = macro() { let square = func(x) { x * x }; quote(unquote(square)(7)) };
+			inline();`,
+			49,
+		},
+		{
+			`let nothing = macro() { quote(unquote(if (false) { 1 })) };
+			nothing();`,
+			Null,
+		},
+		{
+			`let list = macro(...xs) { quote([unquote_splicing(xs)]) };
+			let sum = func(arr) { if (len(arr) == 0) { 0 } else { first(arr) + sum(rest(arr)) } };
+			sum(list(1, 2 * 3, 10 - 1)) + len(list());`,
+			16,
+		},
+		{
+			`let call = macro(f, ...args) { quote(unquote(f)(unquote_splicing(args))) };
+			call(func(a, b, c) { a * b + c }, 2, 3, 4);`,
+			10,
+		},
+		{
+			`let f = func(x) {
+				let inc = macro(e) { quote(unquote(e) + 1) };
+				let inc_twice = macro(e) { quote(inc(inc(unquote(e)))) };
+				inc_twice(x)
+			};
+			f(5);`,
+			7,
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		macroEnv := object.NewEnvironment()
+		evaluator.DefineMacros(program, macroEnv)
+		expanded, macroErrors := evaluator.ExpandMacros(program, macroEnv)
+		if len(macroErrors) != 0 {
+			t.Fatalf("macro errors: %v", macroErrors)
+		}
+
+		comp := compiler.New()
+		err := comp.Compile(expanded)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
 // parse() parses the input string and returns the AST.
 func parse(input string) *ast.Program {
 	l := lexer.New(input)
@@ -117,6 +878,22 @@ func testIntegerObject(expected int64, actual object.Object) error {
 	return nil
 }
 
+// testStringObject() tests the string object.
+func testStringObject(expected string, actual object.Object) error {
+	result, ok := actual.(*object.String)
+	if !ok {
+		return fmt.Errorf("object is not String. got=%T (%+v)",
+			actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%q, want=%q",
+			result.Value, expected)
+	}
+
+	return nil
+}
+
 // testBooleanObject() tests the boolean object.
 func testBooleanObject(expected bool, actual object.Object) error {
 	result, ok := actual.(*object.Boolean)
@@ -138,30 +915,48 @@ type vmTestCase struct {
 	expected interface{}
 }
 
-// runVmTests() runs the tests for the VM.
+// runVmTests() runs the tests for the VM, compiling each program with and without the
+// compiler's optimizations, which must not change what it evaluates to.
 func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 
-	// Iterate over the tests.
-	for _, tt := range tests {
-		program := parse(tt.input)
-		comp := compiler.New()
-		err := comp.Compile(program)
+	runVmTestsWith(t, tests, []compiler.Optimizations{{Fold: true, Peephole: true, DeadCode: true}, {Peephole: true}, {}})
+}
 
-		if err != nil {
-			t.Fatalf("compiler error: %s", err)
-		}
+// everyOptimization() returns every combination of the compiler's optimizations.
+func everyOptimization() []compiler.Optimizations {
+	combinations := []compiler.Optimizations{}
+	for i := 0; i < 8; i++ {
+		combinations = append(combinations, compiler.Optimizations{Fold: i&1 != 0, Peephole: i&2 != 0, DeadCode: i&4 != 0})
+	}
+	return combinations
+}
 
-		vm := New(comp.Bytecode())
-		err = vm.Run()
+// runVmTestsWith() runs the tests compiled with each of the given optimizations.
+func runVmTestsWith(t *testing.T, tests []vmTestCase, combinations []compiler.Optimizations) {
+	t.Helper()
 
-		if err != nil {
-			t.Fatalf("vm error: %s", err)
-		}
+	for _, optimizations := range combinations {
+		for _, tt := range tests {
+			program := parse(tt.input)
+			comp := compiler.New()
+			comp.SetOptimizations(optimizations)
+			err := comp.Compile(program)
+
+			if err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			vm := New(comp.Bytecode())
+			err = vm.Run()
 
-		stackElem := vm.LastPoppedStackElem()
-		testExpectedObject(t, tt.expected, stackElem)
+			if err != nil {
+				t.Fatalf("vm error with %+v: %s", optimizations, err)
+			}
 
+			stackElem := vm.LastPoppedStackElem()
+			testExpectedObject(t, tt.expected, stackElem)
+		}
 	}
 }
 
@@ -193,6 +988,57 @@ func testExpectedObject(
 			t.Errorf("object is not Null: %T (%+v)", actual, actual)
 		}
 
+	case string:
+		err := testStringObject(expected, actual)
+		if err != nil {
+			t.Errorf("testStringObject failed: %s", err)
+		}
+
+	case []int:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("object not Array: %T (%+v)", actual, actual)
+			return
+		}
+
+		if len(array.Elements) != len(expected) {
+			t.Errorf("wrong num of elements. want=%d, got=%d",
+				len(expected), len(array.Elements))
+			return
+		}
+
+		for i, expectedElem := range expected {
+			err := testIntegerObject(int64(expectedElem), array.Elements[i])
+			if err != nil {
+				t.Errorf("testIntegerObject failed: %s", err)
+			}
+		}
+
+	case map[object.HashKey]int64:
+		hash, ok := actual.(*object.Hash)
+		if !ok {
+			t.Errorf("object is not Hash. got=%T (%+v)", actual, actual)
+			return
+		}
+
+		if len(hash.Pairs) != len(expected) {
+			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d",
+				len(expected), len(hash.Pairs))
+			return
+		}
+
+		for expectedKey, expectedValue := range expected {
+			pair, ok := hash.Pairs[expectedKey]
+			if !ok {
+				t.Errorf("no pair for given key in Pairs")
+			}
+
+			err := testIntegerObject(expectedValue, pair.Value)
+			if err != nil {
+				t.Errorf("testIntegerObject failed: %s", err)
+			}
+		}
+
 	}
 
 }